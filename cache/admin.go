@@ -0,0 +1,334 @@
+package cache
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"cache/lru"
+)
+
+/**
+ * @Description: 管理和排查问题用的接口,需要admin token
+ * GET  /_admin/groups                    所有group的配置
+ * GET  /_admin/groups/<group>            group的配置和统计信息
+ * GET  /_admin/groups/<group>/keys       分页列出缓存的key,参数 cache=main|remote offset limit
+ * POST /_admin/groups/<group>/purge      清空group的缓存,带上key参数时只删除该key
+ * GET  /_admin/ring                      一致性hash环上的节点
+ * GET  /_admin/owner?key=<key>           key属于哪个节点
+ * GET  /_admin/overview                  监控页面使用的概况,见 dashboard.go
+ * GET  /_admin/dashboard                 监控页面,不需要token
+ */
+
+const (
+	defaultAdminPath     = "/_admin/"
+	defaultAdminKeyLimit = 100
+	maxAdminKeyLimit     = 1000
+)
+
+/**
+ * @Description: group的配置
+ */
+type GroupConfig struct {
+	Name               string  `json:"name"`
+	MaxBytes           int64   `json:"max_bytes"`
+	TTL                string  `json:"ttl,omitempty"`
+	StaleGrace         string  `json:"stale_grace,omitempty"`
+	EarlyRefreshBeta   float64 `json:"early_refresh_beta,omitempty"`
+	ServeStaleWindow   string  `json:"serve_stale_window,omitempty"`
+	ServeStaleMaxBytes int64   `json:"serve_stale_max_bytes,omitempty"`
+	Compression        string  `json:"compression,omitempty"`
+	CompressThreshold  int     `json:"compress_threshold,omitempty"`
+	CompressInMemory   bool    `json:"compress_in_memory,omitempty"`
+	EncryptionKeyID    string  `json:"encryption_key_id,omitempty"`
+	Distributed        bool    `json:"distributed"` //是否注册了NodePicker
+}
+
+/**
+ * @Description: 返回group的配置
+ * @receiver g
+ * @return GroupConfig
+ */
+func (g *Group) Config() GroupConfig {
+	c := GroupConfig{
+		Name:             g.name,
+		MaxBytes:         g.cache.maxBytes,
+		EarlyRefreshBeta: g.earlyBeta,
+		Distributed:      g.nodePicker != nil,
+	}
+	if g.ttl > 0 {
+		c.TTL = g.ttl.String()
+	}
+	if g.staleGrace > 0 {
+		c.StaleGrace = g.staleGrace.String()
+	}
+	if g.stale != nil {
+		c.ServeStaleWindow = g.stale.window.String()
+		c.ServeStaleMaxBytes = g.stale.maxBytes
+	}
+	if g.codec != nil {
+		c.Compression = g.codec.Name()
+		c.CompressThreshold = g.compressThreshold
+		c.CompressInMemory = g.compressInMemory
+	}
+	if g.keyring != nil {
+		c.EncryptionKeyID = g.keyring.Current()
+	}
+	return c
+}
+
+/**
+ * @Description: 缓存中的一个key
+ */
+type KeyInfo struct {
+	Key        string     `json:"key"`
+	Bytes      int64      `json:"bytes"` //key和value占用的内存,value为压缩加密后的大小
+	AgeSeconds float64    `json:"age_seconds"`
+	Expire     *time.Time `json:"expire,omitempty"`
+}
+
+/**
+ * @Description: 从最近使用到最久未使用分页列出key
+ * @receiver c
+ * @param offset
+ * @param limit
+ * @param now
+ * @return total
+ * @return keys
+ */
+func (c *cache) keys(offset, limit int, now time.Time) (total int, keys []KeyInfo) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	keys = []KeyInfo{}
+	if c.lru == nil {
+		return 0, keys
+	}
+	total = c.lru.Len()
+	i := 0
+	c.lru.Range(func(key string, value lru.Value, added time.Time) bool {
+		if i >= offset+limit {
+			return false
+		}
+		if i >= offset {
+			v := value.(ByteView)
+			info := KeyInfo{Key: key, Bytes: int64(len(key) + v.Len()), AgeSeconds: now.Sub(added).Seconds()}
+			if !v.expire.IsZero() {
+				expire := v.expire
+				info.Expire = &expire
+			}
+			keys = append(keys, info)
+		}
+		i++
+		return true
+	})
+	return total, keys
+}
+
+/**
+ * @Description: 环上的节点
+ */
+type RingNode struct {
+	Addr         string       `json:"addr"`
+	VirtualNodes int          `json:"virtual_nodes"`
+	Share        float64      `json:"share"` //负责的hash空间的比例
+	Self         bool         `json:"self"`
+	State        BreakerState `json:"state"`
+}
+
+/**
+ * @Description: 返回一致性hash环上的节点,按地址排序
+ * @receiver g
+ * @return []RingNode
+ */
+func (g *GroupHTTP) Ring() []RingNode {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	nodes := []RingNode{}
+	if g.nodes == nil {
+		return nodes
+	}
+	for _, n := range g.nodes.Nodes() {
+		node := RingNode{Addr: n.Name, VirtualNodes: n.VirtualNodes, Share: n.Share, Self: n.Name == g.addr}
+		if c, ok := g.NodeClientMap[n.Name]; ok && !node.Self {
+			node.State = c.health.status(n.Name).State
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+/**
+ * @Description: 返回key所属的节点,以及按顺时针方向的候选节点,owner熔断时依次使用候选节点
+ * @receiver g
+ * @param key
+ * @return []string 第一个为owner
+ */
+func (g *GroupHTTP) Owners(key string) []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.nodes == nil {
+		return nil
+	}
+	return g.nodes.GetN(key, len(g.NodeClientMap))
+}
+
+/**
+ * @Description: 请求是否带有管理接口的token
+ * @receiver g
+ * @param r
+ * @return bool
+ */
+func (g *GroupHTTP) adminAuthorized(r *http.Request) bool {
+	if g.adminToken == "" {
+		return false
+	}
+	//必须是 "Bearer <token>",没有前缀的原始token也拒绝
+	h := r.Header.Get("Authorization")
+	return strings.HasPrefix(h, "Bearer ") && subtle.ConstantTimeCompare([]byte(h[len("Bearer "):]), []byte(g.adminToken)) == 1
+}
+
+/**
+ * @Description: 管理接口的入口,检查token后按路径分发
+ * @receiver g
+ * @param w
+ * @param r
+ */
+func (g *GroupHTTP) serveAdmin(w http.ResponseWriter, r *http.Request) {
+	if g.adminToken == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.URL.Path == defaultAdminPath+"dashboard" {
+		serveDashboard(w, r)
+		return
+	}
+	if !g.adminAuthorized(r) {
+		writeAdminError(w, http.StatusUnauthorized, "invalid admin token")
+		return
+	}
+
+	path := strings.Trim(r.URL.Path[len(defaultAdminPath):], "/")
+	parts := strings.Split(path, "/")
+	switch {
+	case path == "overview":
+		writeJSON(w, http.StatusOK, g.Overview())
+	case path == "ring":
+		writeJSON(w, http.StatusOK, struct {
+			Self     string     `json:"self"`
+			Replicas int        `json:"replicas"`
+			Nodes    []RingNode `json:"nodes"`
+		}{g.addr, g.replicas, g.Ring()})
+	case path == "owner":
+		key := r.URL.Query().Get("key")
+		if key == "" {
+			writeAdminError(w, http.StatusBadRequest, "key is required")
+			return
+		}
+		owners := g.Owners(key)
+		res := struct {
+			Key        string   `json:"key"`
+			Owner      string   `json:"owner"`
+			Self       bool     `json:"self"`
+			Candidates []string `json:"candidates"`
+		}{Key: key, Candidates: []string{}}
+		if len(owners) > 0 {
+			res.Owner, res.Self, res.Candidates = owners[0], owners[0] == g.addr, owners[1:]
+		}
+		writeJSON(w, http.StatusOK, res)
+	case path == "groups":
+		configs := []GroupConfig{}
+		for _, group := range allGroups() {
+			configs = append(configs, group.Config())
+		}
+		writeJSON(w, http.StatusOK, configs)
+	case parts[0] == "groups" && len(parts) <= 3:
+		group := GetGroup(parts[1])
+		if group == nil {
+			writeAdminError(w, http.StatusNotFound, "no such group: "+parts[1])
+			return
+		}
+		action := ""
+		if len(parts) == 3 {
+			action = parts[2]
+		}
+		g.serveAdminGroup(w, r, group, action)
+	default:
+		writeAdminError(w, http.StatusNotFound, "not found")
+	}
+}
+
+/**
+ * @Description: group相关的管理接口
+ * @receiver g
+ * @param w
+ * @param r
+ * @param group
+ * @param action
+ */
+func (g *GroupHTTP) serveAdminGroup(w http.ResponseWriter, r *http.Request, group *Group, action string) {
+	q := r.URL.Query()
+	switch action {
+	case "":
+		writeJSON(w, http.StatusOK, struct {
+			Config GroupConfig `json:"config"`
+			Stats  GroupStats  `json:"stats"`
+		}{group.Config(), group.Stats()})
+	case "keys":
+		c := &group.cache
+		switch q.Get("cache") {
+		case "", "main":
+		case "remote":
+			c = &group.remoteCache
+		default:
+			writeAdminError(w, http.StatusBadRequest, "cache must be main or remote")
+			return
+		}
+		offset, err1 := queryInt(q.Get("offset"), 0)
+		limit, err2 := queryInt(q.Get("limit"), defaultAdminKeyLimit)
+		if err1 != nil || err2 != nil || offset < 0 || limit <= 0 || limit > maxAdminKeyLimit {
+			writeAdminError(w, http.StatusBadRequest, "invalid offset or limit")
+			return
+		}
+		total, keys := c.keys(offset, limit, time.Now())
+		writeJSON(w, http.StatusOK, struct {
+			Total  int       `json:"total"`
+			Offset int       `json:"offset"`
+			Keys   []KeyInfo `json:"keys"`
+		}{total, offset, keys})
+	case "purge":
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeAdminError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		purged := 0
+		if key := q.Get("key"); key != "" {
+			if group.Remove(key) {
+				purged = 1
+			}
+		} else {
+			purged = group.Purge()
+		}
+		g.logger().Info("purge group", "node", g.addr, "group", group.name, "key", q.Get("key"), "purged", purged)
+		writeJSON(w, http.StatusOK, struct {
+			Purged int `json:"purged"`
+		}{purged})
+	default:
+		writeAdminError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func queryInt(s string, def int) (int, error) {
+	if s == "" {
+		return def, nil
+	}
+	return strconv.Atoi(s)
+}
+
+func writeAdminError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, struct {
+		Error string `json:"error"`
+	}{msg})
+}
@@ -0,0 +1,137 @@
+package cache
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func adminDo(h http.Handler, method, target, token string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestAdminAuth(t *testing.T) {
+	if w := adminDo(NewGroupHTTP("http://self"), "GET", "/_admin/groups", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("admin should be disabled without token, got %d", w.Code)
+	}
+	g := NewGroupHTTP("http://self", WithAdmin("secret"))
+	if w := adminDo(g, "GET", "/_admin/groups", "wrong"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", w.Code)
+	}
+	if w := adminDo(g, "GET", "/_admin/groups", "secret"); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	//没有Bearer前缀的token
+	r := httptest.NewRequest("GET", "/_admin/groups", nil)
+	r.Header.Set("Authorization", "secret")
+	w := httptest.NewRecorder()
+	g.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for a token without the Bearer prefix, got %d", w.Code)
+	}
+}
+
+func TestAdminGroups(t *testing.T) {
+	group := NewGroup("admin", 1<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("value-" + key), nil
+	}), WithTTL(time.Minute))
+	g := NewGroupHTTP("http://self", WithAdmin("secret"))
+	for _, k := range []string{"a", "b", "c"} {
+		group.Get(k)
+	}
+	group.Get("a")
+
+	var configs []GroupConfig
+	json.Unmarshal(adminDo(g, "GET", "/_admin/groups", "secret").Body.Bytes(), &configs)
+	found := false
+	for _, c := range configs {
+		if c.Name == "admin" {
+			found = c.MaxBytes == 1<<10 && c.TTL == "1m0s"
+		}
+	}
+	if !found {
+		t.Fatalf("expected admin group config, got %+v", configs)
+	}
+
+	var detail struct {
+		Config GroupConfig
+		Stats  GroupStats
+	}
+	json.Unmarshal(adminDo(g, "GET", "/_admin/groups/admin", "secret").Body.Bytes(), &detail)
+	if detail.Config.Name != "admin" || detail.Stats.Gets != 4 || detail.Stats.CacheHits != 1 {
+		t.Fatalf("unexpected group detail %+v", detail)
+	}
+
+	var page struct {
+		Total int
+		Keys  []KeyInfo
+	}
+	json.Unmarshal(adminDo(g, "GET", "/_admin/groups/admin/keys?offset=1&limit=1", "secret").Body.Bytes(), &page)
+	if page.Total != 3 || len(page.Keys) != 1 || page.Keys[0].Key != "c" || page.Keys[0].Bytes != int64(len("c")+len("value-c")) || page.Keys[0].Expire == nil {
+		t.Fatalf("unexpected keys page %+v", page)
+	}
+	if w := adminDo(g, "GET", "/_admin/groups/admin/keys?limit=0", "secret"); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", w.Code)
+	}
+
+	if w := adminDo(g, "GET", "/_admin/groups/admin/purge", "secret"); w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405, got %d", w.Code)
+	}
+	var purged struct{ Purged int }
+	json.Unmarshal(adminDo(g, "POST", "/_admin/groups/admin/purge?key=a", "secret").Body.Bytes(), &purged)
+	if purged.Purged != 1 {
+		t.Fatalf("expected 1 key purged, got %d", purged.Purged)
+	}
+	json.Unmarshal(adminDo(g, "POST", "/_admin/groups/admin/purge", "secret").Body.Bytes(), &purged)
+	if purged.Purged != 2 || group.Stats().MainCache.Items != 0 {
+		t.Fatalf("expected 2 keys purged, got %d", purged.Purged)
+	}
+	if w := adminDo(g, "GET", "/_admin/groups/nogroup", "secret"); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", w.Code)
+	}
+}
+
+func TestAdminRing(t *testing.T) {
+	g := NewGroupHTTP("http://self", WithAdmin("secret"))
+	g.Set("http://self", "http://peer1", "http://peer2")
+
+	var ring struct {
+		Self  string
+		Nodes []struct {
+			Addr         string
+			VirtualNodes int `json:"virtual_nodes"`
+			Self         bool
+			State        string
+		}
+	}
+	json.Unmarshal(adminDo(g, "GET", "/_admin/ring", "secret").Body.Bytes(), &ring)
+	if ring.Self != "http://self" || len(ring.Nodes) != 3 {
+		t.Fatalf("unexpected ring %+v", ring)
+	}
+	for _, n := range ring.Nodes {
+		if n.VirtualNodes != defaultNodeVirReplicas || n.Self != (n.Addr == "http://self") || (!n.Self && n.State != "closed") {
+			t.Fatalf("unexpected node %+v", n)
+		}
+	}
+
+	var owner struct {
+		Owner      string
+		Self       bool
+		Candidates []string
+	}
+	json.Unmarshal(adminDo(g, "GET", "/_admin/owner?key=Tom", "secret").Body.Bytes(), &owner)
+	if owners := g.Owners("Tom"); owner.Owner != owners[0] || owner.Self != (owners[0] == "http://self") || len(owner.Candidates) != 2 {
+		t.Fatalf("unexpected owner %+v", owner)
+	}
+	if w := adminDo(g, "GET", "/_admin/owner", "secret"); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", w.Code)
+	}
+}
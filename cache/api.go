@@ -0,0 +1,338 @@
+package cache
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+/**
+ * @Description: 对外提供的JSON REST API
+ * GET    <prefix>                  所有group的统计信息
+ * GET    <prefix><group>           group的统计信息
+ * POST   <prefix><group>           批量获取,请求体为 {"keys": [...]}
+ * GET    <prefix><group>/<key>     获取,Accept为application/json或者format=json时返回JSON,否则返回原始数据
+ * PUT    <prefix><group>/<key>     设置,请求体为原始数据,If-Match或If-None-Match: *时比较版本后设置
+ * DELETE <prefix><group>/<key>     删除
+ * GET和PUT返回的ETag为值的版本,GET带If-None-Match时版本没有变化返回304
+ * 没有配置 WithAPIAuth 时PUT和DELETE返回403,除非配置了 WithAPIUnauthenticatedWrites
+ */
+
+const (
+	defaultAPIPrefix   = "/api/"
+	apiMaxBatchKeys    = 1000
+	apiMaxBatchWorkers = 16 //批量获取时同时读取的key数
+	apiMaxValueBytes   = 64 << 20
+)
+
+/**
+ * @Description: REST API的http.Handler
+ */
+type APIHandler struct {
+	prefix       string
+	auth         Authenticator
+	authz        Authorizer
+	unauthWrites bool //没有认证时是否接受PUT和DELETE
+}
+
+/**
+ * @Description: 新建一个REST API处理器
+ * @param prefix 路由前缀,为空时使用 /api/
+ * @param opts
+ * @return *APIHandler
+ */
+func NewAPIHandler(prefix string, opts ...APIOption) *APIHandler {
+	if prefix == "" {
+		prefix = defaultAPIPrefix
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	h := &APIHandler{prefix: prefix}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+/**
+ * @Description: 验证请求,失败时写入401
+ * @receiver h
+ * @param w
+ * @param r
+ * @return principal
+ * @return ok 是否通过
+ */
+func (h *APIHandler) authenticate(w http.ResponseWriter, r *http.Request) (principal string, ok bool) {
+	if h.auth == nil {
+		return "", true
+	}
+	principal, err := h.auth.Verify(r)
+	if err != nil {
+		writeAPIError(w, r, http.StatusUnauthorized, ErrUnauthenticated.Error())
+		return "", false
+	}
+	return principal, true
+}
+
+/**
+ * @Description: principal是否可以访问group
+ * @receiver h
+ * @param principal
+ * @param group
+ * @return bool
+ */
+func (h *APIHandler) allowed(principal, group string) bool {
+	return h.auth == nil || h.authz == nil || h.authz(principal, group)
+}
+
+/**
+ * @Description: 单个key的结果,value不是合法的UTF-8时使用base64编码
+ */
+type apiValue struct {
+	Key     string     `json:"key"`
+	Value   string     `json:"value,omitempty"`
+	Base64  bool       `json:"base64,omitempty"`
+	Expire  *time.Time `json:"expire,omitempty"`
+	Stale   bool       `json:"stale,omitempty"`
+	Version uint64     `json:"version,omitempty"`
+	Status  int        `json:"status,omitempty"` //批量获取时每个key的状态码
+	Error   string     `json:"error,omitempty"`
+}
+
+func newAPIValue(key string, v ByteView) apiValue {
+	res := apiValue{Key: key, Stale: v.stale, Version: v.version}
+	if utf8.Valid(v.value) {
+		res.Value = string(v.value)
+	} else {
+		res.Value, res.Base64 = base64.StdEncoding.EncodeToString(v.value), true
+	}
+	if !v.expire.IsZero() {
+		expire := v.expire
+		res.Expire = &expire
+	}
+	return res
+}
+
+func (h *APIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, h.prefix) {
+		writeAPIError(w, r, http.StatusNotFound, "not found")
+		return
+	}
+	principal, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+	path := r.URL.Path[len(h.prefix):]
+	if path == "" {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, r, http.MethodGet)
+			return
+		}
+		stats := []GroupStats{}
+		for _, g := range allGroups() {
+			if h.allowed(principal, g.name) {
+				stats = append(stats, g.Stats())
+			}
+		}
+		writeJSON(w, http.StatusOK, stats)
+		return
+	}
+
+	parts := strings.SplitN(path, "/", 2)
+	//先授权,没有权限时不暴露group是否存在
+	if !h.allowed(principal, parts[0]) {
+		writeAPIError(w, r, http.StatusForbidden, ErrForbidden.Error())
+		return
+	}
+	g := GetGroup(parts[0])
+	if g == nil {
+		writeAPIError(w, r, http.StatusNotFound, "no such group: "+parts[0])
+		return
+	}
+	if len(parts) == 1 {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, g.Stats())
+		case http.MethodPost:
+			h.batchGet(w, r, g)
+		default:
+			methodNotAllowed(w, r, http.MethodGet, http.MethodPost)
+		}
+		return
+	}
+
+	key := parts[1]
+	if key == "" {
+		writeAPIError(w, r, http.StatusBadRequest, "key is required")
+		return
+	}
+	if (r.Method == http.MethodPut || r.Method == http.MethodDelete) && h.auth == nil && !h.unauthWrites {
+		writeAPIError(w, r, http.StatusForbidden, "writes require WithAPIAuth or WithAPIUnauthenticatedWrites")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		h.get(w, r, g, key)
+	case http.MethodPut:
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, apiMaxValueBytes))
+		if err != nil {
+			writeAPIError(w, r, bodyErrorStatus(err), "read body failed: "+err.Error())
+			return
+		}
+		req, err := writeRequest(r, g.name, key)
+		if err != nil {
+			writeAPIError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		version, err := g.set(r.Context(), req, body)
+		if err != nil {
+			writeAPIError(w, r, statusOf(err), err.Error())
+			return
+		}
+		if version != 0 {
+			w.Header().Set("ETag", formatETag(version))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		if err := g.DeleteContext(r.Context(), key); err != nil {
+			writeAPIError(w, r, statusOf(err), err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		methodNotAllowed(w, r, http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete)
+	}
+}
+
+/**
+ * @Description: 获取单个key,按Accept返回JSON或者原始数据
+ * @receiver h
+ * @param w
+ * @param r
+ * @param g
+ * @param key
+ */
+func (h *APIHandler) get(w http.ResponseWriter, r *http.Request, g *Group, key string) {
+	v, err := g.GetContext(r.Context(), key)
+	if err != nil {
+		writeAPIError(w, r, statusOf(err), err.Error())
+		return
+	}
+	if v.version != 0 {
+		w.Header().Set("ETag", v.ETag())
+		if inm, ok := parseETag(r.Header.Get("If-None-Match")); ok && inm == v.version && !v.stale {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	if wantJSON(r) {
+		writeJSON(w, http.StatusOK, newAPIValue(key, v))
+		return
+	}
+	if !v.expire.IsZero() {
+		w.Header().Set("Expires", v.expire.UTC().Format(http.TimeFormat))
+	}
+	if v.stale {
+		w.Header().Set("Warning", `110 - "Response is Stale"`)
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", fmt.Sprint(v.Len()))
+	if r.Method != http.MethodHead {
+		w.Write(v.value)
+	}
+}
+
+/**
+ * @Description: 并发获取多个key,按请求的顺序返回,每个key有自己的状态码
+ * @receiver h
+ * @param w
+ * @param r
+ * @param g
+ */
+func (h *APIHandler) batchGet(w http.ResponseWriter, r *http.Request, g *Group) {
+	var req struct {
+		Keys []string `json:"keys"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, apiMaxValueBytes)).Decode(&req); err != nil {
+		writeJSON(w, bodyErrorStatus(err), apiValue{Error: "invalid request body: " + err.Error()})
+		return
+	}
+	if len(req.Keys) > apiMaxBatchKeys {
+		writeJSON(w, http.StatusBadRequest, apiValue{Error: fmt.Sprintf("too many keys, at most %d", apiMaxBatchKeys)})
+		return
+	}
+	results := make([]apiValue, len(req.Keys))
+	parallel(apiMaxBatchWorkers, len(req.Keys), func(i int) {
+		key := req.Keys[i]
+		v, err := g.GetContext(r.Context(), key)
+		if err != nil {
+			results[i] = apiValue{Key: key, Status: statusOf(err), Error: err.Error()}
+			return
+		}
+		results[i] = newAPIValue(key, v)
+		results[i].Status = http.StatusOK
+	})
+	writeJSON(w, http.StatusOK, struct {
+		Results []apiValue `json:"results"`
+	}{results})
+}
+
+/**
+ * @Description: 读取请求体失败时的状态码,超过http.MaxBytesReader的限制为413,其他为400
+ * @param err
+ * @return int
+ */
+func bodyErrorStatus(err error) int {
+	//Go 1.19之前MaxBytesReader的错误没有类型,只能按错误信息判断
+	if strings.Contains(err.Error(), "request body too large") {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+/**
+ * @Description: 是否返回JSON,format参数优先于Accept头
+ * @param r
+ * @return bool
+ */
+func wantJSON(r *http.Request) bool {
+	switch r.URL.Query().Get("format") {
+	case "json":
+		return true
+	case "raw":
+		return false
+	}
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+/**
+ * @Description: 按请求的格式返回错误
+ * @param w
+ * @param r
+ * @param status
+ * @param msg
+ */
+func writeAPIError(w http.ResponseWriter, r *http.Request, status int, msg string) {
+	if wantJSON(r) {
+		writeJSON(w, status, apiValue{Status: status, Error: msg})
+		return
+	}
+	http.Error(w, msg, status)
+}
+
+func methodNotAllowed(w http.ResponseWriter, r *http.Request, allow ...string) {
+	w.Header().Set("Allow", strings.Join(allow, ", "))
+	writeAPIError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+}
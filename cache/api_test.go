@@ -0,0 +1,252 @@
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestAPIGroup(name string) *Group {
+	return NewGroup(name, 1<<10, GetterFunc(func(key string) ([]byte, error) {
+		switch key {
+		case "missing":
+			return nil, fmt.Errorf("%s: %w", key, ErrNotFound)
+		case "broken":
+			return nil, errors.New("db is down")
+		case "binary":
+			return []byte{0xff, 0xfe}, nil
+		}
+		return []byte("v-" + key), nil
+	}), WithTTL(time.Minute))
+}
+
+func apiDo(h http.Handler, method, target, body string, header ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestAPIGet(t *testing.T) {
+	newTestAPIGroup("api")
+	h := NewAPIHandler("")
+
+	w := apiDo(h, "GET", "/api/api/a/b", "")
+	if w.Code != http.StatusOK || w.Body.String() != "v-a/b" || w.Header().Get("Expires") == "" {
+		t.Fatalf("unexpected raw response %d %q %v", w.Code, w.Body, w.Header())
+	}
+
+	w = apiDo(h, "GET", "/api/api/a", "", "Accept", "application/json")
+	var v apiValue
+	if err := json.Unmarshal(w.Body.Bytes(), &v); err != nil || v.Value != "v-a" || v.Expire == nil {
+		t.Fatalf("unexpected json response %q: %v", w.Body, err)
+	}
+	w = apiDo(h, "GET", "/api/api/binary?format=json", "")
+	if json.Unmarshal(w.Body.Bytes(), &v); !v.Base64 || v.Value != "//4=" {
+		t.Fatalf("expected base64 value, got %q", w.Body)
+	}
+
+	cases := []struct {
+		target string
+		status int
+	}{
+		{"/api/api/missing", http.StatusNotFound},
+		{"/api/api/broken", http.StatusInternalServerError},
+		{"/api/nogroup/a", http.StatusNotFound},
+		{"/api/api/", http.StatusBadRequest},
+		{"/other", http.StatusNotFound},
+	}
+	for _, c := range cases {
+		if w := apiDo(h, "GET", c.target, ""); w.Code != c.status {
+			t.Fatalf("%s: expected %d, got %d", c.target, c.status, w.Code)
+		}
+	}
+	w = apiDo(h, "GET", "/api/api/missing", "", "Accept", "application/json")
+	if json.Unmarshal(w.Body.Bytes(), &v); v.Status != http.StatusNotFound || !strings.Contains(v.Error, "not found") {
+		t.Fatalf("expected json error, got %q", w.Body)
+	}
+	if w := apiDo(h, "PATCH", "/api/api/a", ""); w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") == "" {
+		t.Fatalf("expected 405, got %d", w.Code)
+	}
+}
+
+/**
+ * @Description: 读取时总是失败的请求体
+ */
+type brokenBody struct{}
+
+func (brokenBody) Read([]byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestAPISetDeleteStats(t *testing.T) {
+	g := newTestAPIGroup("api-write")
+	h := NewAPIHandler("/v1", WithAPIUnauthenticatedWrites())
+
+	if w := apiDo(h, "PUT", "/v1/api-write/k", "hello"); w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", w.Code)
+	}
+	if w := apiDo(h, "GET", "/v1/api-write/k", ""); w.Body.String() != "hello" {
+		t.Fatalf("expected value set by PUT, got %q", w.Body)
+	}
+	//太大返回413,读取请求体失败返回400
+	if w := apiDo(h, "PUT", "/v1/api-write/k", strings.Repeat("x", apiMaxValueBytes+1)); w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413, got %d", w.Code)
+	}
+	r := httptest.NewRequest("PUT", "/v1/api-write/k", brokenBody{})
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a broken body, got %d", w.Code)
+	}
+	if w := apiDo(h, "DELETE", "/v1/api-write/k", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", w.Code)
+	}
+	if w := apiDo(h, "GET", "/v1/api-write/k", ""); w.Body.String() != "v-k" {
+		t.Fatalf("expected value to be reloaded after DELETE, got %q", w.Body)
+	}
+
+	var st GroupStats
+	w = apiDo(h, "GET", "/v1/api-write", "")
+	if err := json.Unmarshal(w.Body.Bytes(), &st); err != nil || st.Name != "api-write" || st.Gets != g.Stats().Gets {
+		t.Fatalf("unexpected stats %q: %v", w.Body, err)
+	}
+	var all []GroupStats
+	if w := apiDo(h, "GET", "/v1/", ""); json.Unmarshal(w.Body.Bytes(), &all) != nil || len(all) == 0 {
+		t.Fatalf("unexpected stats %q", w.Body)
+	}
+}
+
+func TestAPIAuth(t *testing.T) {
+	newTestAPIGroup("api-auth")
+	newTestAPIGroup("api-auth-other")
+
+	//没有认证时只读
+	h := NewAPIHandler("")
+	if w := apiDo(h, "GET", "/api/api-auth/k", ""); w.Code != http.StatusOK {
+		t.Fatalf("expected reads without auth, got %d", w.Code)
+	}
+	for _, method := range []string{"PUT", "DELETE"} {
+		if w := apiDo(h, method, "/api/api-auth/k", "v"); w.Code != http.StatusForbidden {
+			t.Fatalf("expected %s without auth to be rejected, got %d", method, w.Code)
+		}
+	}
+
+	auth := NewBearerAuth("", map[string]string{"secret": "alice"})
+	h = NewAPIHandler("", WithAPIAuth(auth, AllowGroups(map[string][]string{"alice": {"api-auth"}})))
+	if w := apiDo(h, "PUT", "/api/api-auth/k", "v"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without token, got %d", w.Code)
+	}
+	if w := apiDo(h, "PUT", "/api/api-auth/k", "v", "Authorization", "Bearer secret"); w.Code != http.StatusNoContent {
+		t.Fatalf("expected authorized PUT, got %d: %s", w.Code, w.Body)
+	}
+	if w := apiDo(h, "DELETE", "/api/api-auth-other/k", "", "Authorization", "Bearer secret"); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for another group, got %d", w.Code)
+	}
+	//没有权限的group不暴露是否存在
+	if w := apiDo(h, "GET", "/api/api-auth-missing", "", "Authorization", "Bearer secret"); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for an unknown group, got %d", w.Code)
+	}
+	var stats []GroupStats
+	w := apiDo(h, "GET", "/api/", "", "Authorization", "Bearer secret")
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil || len(stats) != 1 || stats[0].Name != "api-auth" {
+		t.Fatalf("expected only authorized groups in stats, got %s", w.Body)
+	}
+}
+
+func TestAPIBatchGet(t *testing.T) {
+	newTestAPIGroup("api-batch")
+	h := NewAPIHandler("")
+
+	w := apiDo(h, "POST", "/api/api-batch", `{"keys":["a","missing","broken","b"]}`)
+	var res struct{ Results []apiValue }
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || len(res.Results) != 4 {
+		t.Fatalf("unexpected response %q: %v", w.Body, err)
+	}
+	expect := []struct {
+		value  string
+		status int
+	}{{"v-a", 200}, {"", 404}, {"", 500}, {"v-b", 200}}
+	for i, e := range expect {
+		if r := res.Results[i]; r.Value != e.value || r.Status != e.status {
+			t.Fatalf("result %d: expected %v, got %+v", i, e, r)
+		}
+	}
+	if w := apiDo(h, "POST", "/api/api-batch", `{"keys":`); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", w.Code)
+	}
+	//和PUT一样,请求体太大返回413
+	if w := apiDo(h, "POST", "/api/api-batch", `{"keys":["`+strings.Repeat("x", apiMaxValueBytes)+`"]}`); w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413, got %d", w.Code)
+	}
+}
+
+func TestAPIBatchGetConcurrency(t *testing.T) {
+	var inflight, peak int32
+	NewGroup("api-batch-pool", 1<<20, GetterFunc(func(key string) ([]byte, error) {
+		n := atomic.AddInt32(&inflight, 1)
+		defer atomic.AddInt32(&inflight, -1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		return []byte(key), nil
+	}))
+	keys := make([]string, apiMaxBatchKeys)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+	body, _ := json.Marshal(map[string][]string{"keys": keys})
+	w := apiDo(NewAPIHandler(""), "POST", "/api/api-batch-pool", string(body))
+	var res struct{ Results []apiValue }
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || len(res.Results) != len(keys) || res.Results[999].Value != "999" {
+		t.Fatalf("unexpected response %d: %v", w.Code, err)
+	}
+	if p := atomic.LoadInt32(&peak); p > apiMaxBatchWorkers {
+		t.Fatalf("expected at most %d concurrent loads, got %d", apiMaxBatchWorkers, p)
+	}
+}
+
+func TestAPIPeerErrors(t *testing.T) {
+	//owner节点返回不存在时,不再本地加载
+	NewGroup("api-owner", 1<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, ErrNotFound
+	}))
+	server := httptest.NewServer(NewGroupHTTP("http://owner"))
+	defer server.Close()
+	self := NewGroupHTTP("http://self")
+	self.Set(server.URL)
+
+	local := 0
+	g := NewGroup("api-peer", 1<<10, GetterFunc(func(key string) ([]byte, error) {
+		local++
+		return nil, errors.New("db is down")
+	}))
+	g.Register(fixedPicker{node: renameNode{group: "api-owner", node: self.NodeClientMap[server.URL]}})
+	h := NewAPIHandler("")
+	if w := apiDo(h, "GET", "/api/api-peer/k", ""); w.Code != http.StatusNotFound || local != 0 {
+		t.Fatalf("expected 404 without local load, got %d (%d local loads)", w.Code, local)
+	}
+
+	//节点失败,本地加载也失败,使用本地加载的错误的状态码
+	p := NewGroup("api-peer-down", 1<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, errors.New("db is down")
+	}))
+	p.Register(fixedPicker{node: failingNode{}})
+	if w := apiDo(h, "GET", "/api/api-peer-down/k", ""); w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d", w.Code)
+	}
+}
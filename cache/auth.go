@@ -0,0 +1,308 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/**
+ * @Description: 节点请求的认证和授权,请求其他节点时由httpClient自动签名,节点服务验证后再按group授权
+ */
+
+const (
+	authNodeHeader      = "X-Cache-Node"
+	authTimestampHeader = "X-Cache-Timestamp"
+	authSignatureHeader = "X-Cache-Signature"
+	authNonceHeader     = "X-Cache-Nonce"
+
+	defaultAuthMaxSkew = 30 * time.Second
+	authMaxBody        = apiMaxValueBytes //签名的请求体的最大长度
+	authSweepMin       = 1024             //清理已使用签名的最小条目数
+)
+
+var (
+	ErrUnauthenticated = errors.New("unauthenticated")
+	ErrForbidden       = errors.New("forbidden")
+)
+
+/**
+ * @Description: 认证接口,Sign在请求发出前添加认证信息,Verify验证请求并返回请求方的身份
+ */
+type Authenticator interface {
+	Sign(r *http.Request) error
+	Verify(r *http.Request) (principal string, err error)
+}
+
+/**
+ * @Description: 授权函数,判断principal是否可以访问group
+ */
+type Authorizer func(principal, group string) bool
+
+/**
+ * @Description: 按规则授权,rules为 principal => 允许访问的group列表,"*"表示任意principal或任意group
+ * @param rules
+ * @return Authorizer
+ */
+func AllowGroups(rules map[string][]string) Authorizer {
+	allowed := make(map[string]map[string]bool, len(rules))
+	for principal, groups := range rules {
+		allowed[principal] = make(map[string]bool, len(groups))
+		for _, group := range groups {
+			allowed[principal][group] = true
+		}
+	}
+	return func(principal, group string) bool {
+		for _, p := range []string{principal, "*"} {
+			if gs, ok := allowed[p]; ok && (gs[group] || gs["*"]) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+/**
+ * @Description: 基于共享密钥的HMAC-SHA256签名,签名内容包括方法,路径,查询参数,时间戳,节点名,随机数和请求体的SHA-256,
+ * 时间戳超过maxSkew的请求会被拒绝,maxSkew内同一个签名只能使用一次,防止重放
+ */
+type HMACAuth struct {
+	id      string            //本节点的名字,签名时使用keys[id]
+	keys    map[string][]byte //节点名 => 密钥,验证时使用请求方节点的密钥
+	maxSkew time.Duration
+
+	mu      sync.Mutex
+	seen    map[string]time.Time //已经使用过的签名 => 时间戳失效的时间
+	sweepAt int                  //seen达到该大小时清理失效的签名
+}
+
+/**
+ * @Description: 新建一个HMACAuth,所有节点使用同一个密钥时,keys可以只有一个"*"
+ * @param id 本节点的名字
+ * @param keys 节点名 => 密钥,"*"表示其他所有节点共用的密钥
+ * @param maxSkew 允许的时钟偏差,<=0时使用默认的30秒
+ * @return *HMACAuth
+ */
+func NewHMACAuth(id string, keys map[string][]byte, maxSkew time.Duration) *HMACAuth {
+	if maxSkew <= 0 {
+		maxSkew = defaultAuthMaxSkew
+	}
+	return &HMACAuth{id: id, keys: keys, maxSkew: maxSkew}
+}
+
+func (a *HMACAuth) key(id string) ([]byte, bool) {
+	if k, ok := a.keys[id]; ok {
+		return k, true
+	}
+	k, ok := a.keys["*"]
+	return k, ok
+}
+
+func (a *HMACAuth) sign(key []byte, r *http.Request, id, ts, nonce, body string) string {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s\n%s\n%s\n%s", r.Method, r.URL.EscapedPath(), r.URL.RawQuery, ts, id, nonce, body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+/**
+ * @Description: 读取请求体并计算SHA-256,读取后把请求体放回r中
+ * @param r
+ * @return string 十六进制的SHA-256
+ * @return error 请求体超过authMaxBody
+ */
+func bodySHA256(r *http.Request) (string, error) {
+	var body []byte
+	if r.Body != nil && r.Body != http.NoBody {
+		b, err := ioutil.ReadAll(io.LimitReader(r.Body, authMaxBody+1))
+		r.Body.Close()
+		if err != nil {
+			return "", err
+		}
+		if len(b) > authMaxBody {
+			return "", fmt.Errorf("request body is larger than %d bytes", authMaxBody)
+		}
+		body = b
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		r.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func (a *HMACAuth) Sign(r *http.Request) error {
+	key, ok := a.key(a.id)
+	if !ok {
+		return fmt.Errorf("no hmac key for node %s", a.id)
+	}
+	body, err := bodySHA256(r)
+	if err != nil {
+		return err
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	nonce := hex.EncodeToString(b)
+	ts := strconv.FormatInt(timeNow().Unix(), 10)
+	r.Header.Set(authNodeHeader, a.id)
+	r.Header.Set(authTimestampHeader, ts)
+	r.Header.Set(authNonceHeader, nonce)
+	r.Header.Set(authSignatureHeader, a.sign(key, r, a.id, ts, nonce, body))
+	return nil
+}
+
+func (a *HMACAuth) Verify(r *http.Request) (string, error) {
+	id := r.Header.Get(authNodeHeader)
+	ts := r.Header.Get(authTimestampHeader)
+	nonce := r.Header.Get(authNonceHeader)
+	sig := r.Header.Get(authSignatureHeader)
+	if id == "" || ts == "" || nonce == "" || sig == "" {
+		return "", ErrUnauthenticated
+	}
+	key, ok := a.key(id)
+	if !ok {
+		return "", fmt.Errorf("%w: unknown node %s", ErrUnauthenticated, id)
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("%w: bad timestamp", ErrUnauthenticated)
+	}
+	if skew := timeNow().Sub(time.Unix(unix, 0)); skew > a.maxSkew || skew < -a.maxSkew {
+		return "", fmt.Errorf("%w: timestamp out of range", ErrUnauthenticated)
+	}
+	body, err := bodySHA256(r)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrUnauthenticated, err)
+	}
+	if !hmac.Equal([]byte(sig), []byte(a.sign(key, r, id, ts, nonce, body))) {
+		return "", fmt.Errorf("%w: bad signature", ErrUnauthenticated)
+	}
+	if !a.firstUse(sig, time.Unix(unix, 0).Add(a.maxSkew)) {
+		return "", fmt.Errorf("%w: replayed request", ErrUnauthenticated)
+	}
+	return id, nil
+}
+
+/**
+ * @Description: 记录使用过的签名,时间戳失效之前同一个签名再次出现时返回false
+ * @receiver a
+ * @param sig
+ * @param until 签名的时间戳失效的时间,之后的请求会被时间戳检查拒绝,不需要再记录
+ * @return bool 是否第一次使用
+ */
+func (a *HMACAuth) firstUse(sig string, until time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := timeNow()
+	if a.seen == nil {
+		a.seen = make(map[string]time.Time)
+	}
+	if t, ok := a.seen[sig]; ok && now.Before(t) {
+		return false
+	}
+	a.seen[sig] = until
+	if len(a.seen) >= a.sweepAt {
+		for s, t := range a.seen {
+			if !now.Before(t) {
+				delete(a.seen, s)
+			}
+		}
+		a.sweepAt = 2 * len(a.seen)
+		if a.sweepAt < authSweepMin {
+			a.sweepAt = authSweepMin
+		}
+	}
+	return true
+}
+
+/**
+ * @Description: 基于Bearer token的认证
+ */
+type BearerAuth struct {
+	token  string            //请求其他节点时使用的token
+	tokens map[string]string //token => principal
+}
+
+/**
+ * @Description: 新建一个BearerAuth
+ * @param token 请求其他节点时使用的token
+ * @param tokens 接受的token => principal
+ * @return *BearerAuth
+ */
+func NewBearerAuth(token string, tokens map[string]string) *BearerAuth {
+	return &BearerAuth{token: token, tokens: tokens}
+}
+
+func (a *BearerAuth) Sign(r *http.Request) error {
+	r.Header.Set("Authorization", "Bearer "+a.token)
+	return nil
+}
+
+func (a *BearerAuth) Verify(r *http.Request) (string, error) {
+	h := r.Header.Get("Authorization")
+	if !strings.HasPrefix(h, "Bearer ") {
+		return "", ErrUnauthenticated
+	}
+	principal, ok := lookupToken(a.tokens, strings.TrimPrefix(h, "Bearer "))
+	if !ok {
+		return "", ErrUnauthenticated
+	}
+	return principal, nil
+}
+
+/**
+ * @Description: 查找token对应的principal,逐个比较所有token,避免时序攻击
+ * @param tokens token => principal
+ * @param token
+ * @return string
+ * @return bool
+ */
+func lookupToken(tokens map[string]string, token string) (string, bool) {
+	principal, ok := "", false
+	for t, p := range tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			principal, ok = p, true
+		}
+	}
+	return principal, ok
+}
+
+/**
+ * @Description: 验证请求并按group授权,失败时写入401或403
+ * @receiver g
+ * @param w
+ * @param r
+ * @param group
+ * @return bool 是否通过
+ */
+func (g *GroupHTTP) authorize(w http.ResponseWriter, r *http.Request, group string) bool {
+	if g.auth == nil {
+		return true
+	}
+	principal, err := g.auth.Verify(r)
+	if err != nil {
+		g.logger().Warn("reject unauthenticated request", "node", g.addr, "path", r.URL.Path, "err", err)
+		http.Error(w, ErrUnauthenticated.Error(), http.StatusUnauthorized)
+		return false
+	}
+	if g.authz != nil && !g.authz(principal, group) {
+		g.logger().Warn("reject unauthorized request", "node", g.addr, "principal", principal, "group", group)
+		http.Error(w, ErrForbidden.Error(), http.StatusForbidden)
+		return false
+	}
+	return true
+}
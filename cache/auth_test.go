@@ -0,0 +1,146 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	pb "cache/cachepb"
+)
+
+func TestHMACAuth(t *testing.T) {
+	advance := fakeClock(t)
+	keys := map[string][]byte{"node-a": []byte("secret-a"), "node-b": []byte("secret-b")}
+	a := NewHMACAuth("node-a", keys, time.Minute)
+	b := NewHMACAuth("node-b", keys, time.Minute)
+
+	r := httptest.NewRequest("GET", "http://peer/cache/g/k", nil)
+	if err := a.Sign(r); err != nil {
+		t.Fatal(err)
+	}
+	if principal, err := b.Verify(r); err != nil || principal != "node-a" {
+		t.Fatalf("expected node-a, got %q %v", principal, err)
+	}
+
+	//同一个签名只能使用一次
+	if _, err := b.Verify(r); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("expected replay to be rejected, got %v", err)
+	}
+
+	//签名不能用于其他路径
+	other := httptest.NewRequest("GET", "http://peer/cache/g/other", nil)
+	other.Header = r.Header.Clone()
+	if _, err := b.Verify(other); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("expected bad signature, got %v", err)
+	}
+
+	//冒充其他节点
+	forged := httptest.NewRequest("GET", "http://peer/cache/g/k", nil)
+	forged.Header = r.Header.Clone()
+	forged.Header.Set(authNodeHeader, "node-b")
+	if _, err := b.Verify(forged); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("expected forged node to be rejected, got %v", err)
+	}
+
+	//请求体和查询参数都在签名内,验证后请求体仍然可以读取
+	put := httptest.NewRequest("PUT", "http://peer/cache/g/k?x=1", strings.NewReader("value"))
+	if err := a.Sign(put); err != nil {
+		t.Fatal(err)
+	}
+	for _, tamper := range []func(r *http.Request){
+		func(r *http.Request) { r.Body = ioutil.NopCloser(strings.NewReader("other")) },
+		func(r *http.Request) { r.URL.RawQuery = "x=2" },
+	} {
+		forged := put.Clone(context.Background())
+		body, _ := put.GetBody()
+		forged.Body = body
+		tamper(forged)
+		if _, err := b.Verify(forged); !errors.Is(err, ErrUnauthenticated) {
+			t.Fatalf("expected tampered request to be rejected, got %v", err)
+		}
+	}
+	if _, err := b.Verify(put); err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := ioutil.ReadAll(put.Body); string(body) != "value" {
+		t.Fatalf("expected the body to be readable after verify, got %q", body)
+	}
+
+	//时间戳过期
+	advance(2 * time.Minute)
+	if _, err := b.Verify(r); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("expected expired timestamp to be rejected, got %v", err)
+	}
+}
+
+func TestBearerAuth(t *testing.T) {
+	a := NewBearerAuth("token-a", map[string]string{"token-a": "node-a"})
+	r := httptest.NewRequest("GET", "http://peer/cache/g/k", nil)
+	if _, err := a.Verify(r); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("expected missing token to be rejected, got %v", err)
+	}
+	a.Sign(r)
+	if principal, err := a.Verify(r); err != nil || principal != "node-a" {
+		t.Fatalf("expected node-a, got %q %v", principal, err)
+	}
+	r.Header.Set("Authorization", "Bearer wrong")
+	if _, err := a.Verify(r); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("expected wrong token to be rejected, got %v", err)
+	}
+}
+
+func TestAllowGroups(t *testing.T) {
+	authz := AllowGroups(map[string][]string{"node-a": {"scores"}, "admin": {"*"}, "*": {"public"}})
+	cases := []struct {
+		principal, group string
+		allow            bool
+	}{
+		{"node-a", "scores", true},
+		{"node-a", "users", false},
+		{"node-a", "public", true},
+		{"admin", "users", true},
+		{"node-b", "scores", false},
+	}
+	for _, c := range cases {
+		if authz(c.principal, c.group) != c.allow {
+			t.Errorf("%s/%s: expected %v", c.principal, c.group, c.allow)
+		}
+	}
+}
+
+func TestAuthenticatedPeer(t *testing.T) {
+	NewGroup("auth-scores", 1<<10, GetterFunc(func(key string) ([]byte, error) { return []byte("v-" + key), nil }))
+	NewGroup("auth-users", 1<<10, GetterFunc(func(key string) ([]byte, error) { return []byte("u-" + key), nil }))
+
+	keys := map[string][]byte{"*": []byte("cluster-secret")}
+	authz := AllowGroups(map[string][]string{"node-a": {"auth-scores"}})
+	peer := NewGroupHTTP("http://peer", WithAuth(NewHMACAuth("peer", keys, 0), authz))
+	server := httptest.NewServer(peer)
+	defer server.Close()
+
+	get := func(g *GroupHTTP, group string) error {
+		g.Set(server.URL)
+		return g.NodeClientMap[server.URL].Get(context.Background(), &pb.Request{Group: group, Key: "k"}, &pb.Response{})
+	}
+
+	signed := NewGroupHTTP("http://self", WithAuth(NewHMACAuth("node-a", keys, 0), nil))
+	if err := get(signed, "auth-scores"); err != nil {
+		t.Fatalf("expected signed request to succeed, got %v", err)
+	}
+	var perr *peerError
+	if err := get(signed, "auth-users"); !errors.As(err, &perr) || perr.status != http.StatusForbidden {
+		t.Fatalf("expected 403, got %v", err)
+	}
+	if err := get(NewGroupHTTP("http://self"), "auth-scores"); !errors.As(err, &perr) || perr.status != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %v", err)
+	}
+	//认证失败不是节点故障,不应该触发熔断
+	if s := signed.NodeClientMap[server.URL].health.status(server.URL); s.State != BreakerClosed {
+		t.Fatalf("expected breaker to stay closed, got %v", s.State)
+	}
+}
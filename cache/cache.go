@@ -1,8 +1,8 @@
 package cache
 
 import (
+	"context"
 	"errors"
-	"log"
 	"math/rand"
 	"sync"
 	"sync/atomic"
@@ -32,6 +32,38 @@ type GetterFunc func(key string) ([]byte, error)
 func (f GetterFunc) Get(key string) ([]byte, error){
 	return f(key)
 }
+
+/**
+ * @Description: 可选的写数据源接口,Getter实现了它时Group.Set会写入数据源,也可以通过WithSetter单独指定
+ */
+type Setter interface {
+	Set(key string, value []byte) error
+}
+
+/**
+ * @Description: 函数形式的Setter
+ */
+type SetterFunc func(key string, value []byte) error
+
+func (f SetterFunc) Set(key string, value []byte) error {
+	return f(key, value)
+}
+
+/**
+ * @Description: 可选的删除数据源接口,Getter实现了它时Group.Delete会从数据源删除,也可以通过WithDeleter单独指定
+ */
+type Deleter interface {
+	Delete(key string) error
+}
+
+/**
+ * @Description: 函数形式的Deleter
+ */
+type DeleterFunc func(key string) error
+
+func (f DeleterFunc) Delete(key string) error {
+	return f(key)
+}
 /**
  * @Description: 原子加
  * @param l
@@ -55,6 +87,8 @@ type keyStatus struct {
  * 主要是提供和外部进行交付的方法：Get
  */
 type Group struct {
+	stats Stats //统计信息,原子操作,放在首位保证64位对齐
+
 	name   string
 	/**
      * @Description: getter是一个Getter接口,必须实现Get方法
@@ -71,6 +105,7 @@ type Group struct {
      * @Description: 使用singleflight来防止缓存击穿
      */
 	loader *singleflight.Group
+	loadWait *histogram //等待singleflight的耗时
 
 	/**
      * @Description: 热点互备功能
@@ -78,10 +113,77 @@ type Group struct {
 	remoteCache cache //随机缓存远程调用的结果
 	//hotCache cache //热点缓存
 	keyStatusMap map[string]*keyStatus
+
+	/**
+     * @Description: 过期与刷新策略,见 WithTTL,WithStaleWhileRevalidate,WithEarlyRefresh
+     */
+	ttl        time.Duration //缓存值的存活时间,0表示永不过期
+	staleGrace time.Duration //过期后仍可返回旧值的宽限期,期间在后台刷新
+	earlyBeta  float64       //XFetch提前刷新系数,0表示关闭
+	refreshMu  sync.Mutex
+	refreshing map[string]struct{} //正在后台刷新的key
+
+	/**
+     * @Description: 加载失败时返回旧值,见 WithServeStaleOnError
+     */
+	stale *staleBuffer
+
+	/**
+     * @Description: 压缩,见 WithCompression
+     */
+	codec             Codec
+	compressThreshold int  //小于该值的value不压缩
+	compressInMemory  bool //是否压缩后保存在内存中
+	keyring           *Keyring //不为nil时加密后保存在内存中,见 WithEncryption
+
+	hot *hotKeys //热点key统计,为nil时不统计,见 WithHotKeys
+
+	/**
+     * @Description: 写数据源,见 WithSetter,WithDeleter,WithWriteBehind
+     */
+	setter      Setter
+	deleter     Deleter
+	writeBehind *writeQueue //不为nil时异步批量写入数据源
+	writeLocks  [writeLockStripes]sync.Mutex
+
+	/**
+     * @Description: 版本,见 version.go
+     */
+	versionMu   sync.Mutex
+	lastVersion uint64 //最后分配的版本
+
+	/**
+     * @Description: 租约,见 lease.go 和 WithLeaseStale
+     */
+	leases     leaseTable
+	leaseStale time.Duration //租约失效后旧值可以返回的时间,0表示等待加载
+
+	log Logger //为nil时使用全局Logger
+}
+
+/**
+ * @Description: 返回Group使用的Logger
+ * @receiver g
+ * @return Logger
+ */
+func (g *Group) logger() Logger {
+	if g.log != nil {
+		return g.log
+	}
+	return GetLogger()
 }
 
 
 
+/**
+ * @Description: 返回Group的名字
+ * @receiver g
+ * @return string
+ */
+func (g *Group) Name() string {
+	return g.name
+}
+
 /**
  * @Description: 注册节点选择器
  * @receiver g
@@ -104,25 +206,236 @@ func (g *Group) Register(nodePicker NodePicker)  {
  * @return error
  */
 func (g *Group) Get(key string) (ByteView, error)  {
+	return g.GetContext(context.Background(), key)
+}
+
+/**
+ * @Description: 同Get,ctx用于传递追踪上下文以及控制到其他节点请求的超时和取消
+ * @receiver g
+ * @param ctx
+ * @param key
+ * @return value
+ * @return err
+ */
+func (g *Group) GetContext(ctx context.Context, key string) (value ByteView, err error) {
+	ctx, span := startSpan(ctx, "cache.Get", "group", g.name, "key", key)
+	defer func() { span.End(err) }()
+
+	g.stats.Gets.Add(1)
 	if key == "" {
 		return ByteView{},errors.New("key is required")
 	}
+	if g.hot != nil {
+		g.hot.touch(key)
+	}
+	if v, ok := g.lookup(ctx, key); ok {
+		return v, nil
+	}
+	//不存在就load缓存值
+	return g.load(ctx, key)
+}
+
+/**
+ * @Description: 依次从cache和remoteCache中查找缓存
+ * @receiver g
+ * @param ctx
+ * @param key
+ * @return ByteView
+ * @return bool
+ */
+func (g *Group) lookup(ctx context.Context, key string) (ByteView, bool) {
+	_, span := startSpan(ctx, "cache.lookup")
+	defer span.End(nil)
+
 	//从cache中查找缓存，存在则返回缓存值
 	if v,ok :=g.cache.get(key);ok{
-		return v,nil
+		if v,ok = g.checkExpire(&g.cache,key,v);ok && g.unpackHit(&g.cache,key,&v){
+			g.stats.CacheHits.Add(1)
+			span.SetAttributes("hit", "main")
+			return v,true
+		}
 	}
 	//从remoteCache中查找数据,存在则返回缓存值
 	if v,ok:=g.remoteCache.get(key);ok{
-		return v,nil
+		if v,ok = g.checkExpire(&g.remoteCache,key,v);ok && g.unpackHit(&g.remoteCache,key,&v){
+			g.stats.RemoteCacheHits.Add(1)
+			span.SetAttributes("hit", "remote")
+			return v,true
+		}
 	}
 	//从hotCache中查找数据,存在则返回缓存值
 	//if v,ok:=g.hotCache.get(key);ok{
 	//	return v,nil
 	//}
-	//不存在就load缓存值
-	return g.load(key)
+	span.SetAttributes("hit", "none")
+	return ByteView{}, false
+}
+
+/**
+ * @Description: 解密并解压命中的缓存值,失败时从c中删除,当作没有命中
+ * @receiver g
+ * @param c
+ * @param key
+ * @param v
+ * @return bool
+ */
+func (g *Group) unpackHit(c *cache, key string, v *ByteView) bool {
+	u, err := g.unpack(key, *v)
+	if err != nil {
+		g.logger().Warn("failed to unpack cached value", "group", g.name, "key", key, "err", err)
+		c.remove(key)
+		return false
+	}
+	*v = u
+	return true
+}
+
+
+/**
+ * @Description: 设置key的值,过期时间按WithTTL计算,旧值不再保留
+ * 没有配置Setter时只影响本节点,其他节点上的副本会在过期或淘汰后消失;
+ * 配置了Setter时转发给owner节点,由owner写入数据源(同步或者WithWriteBehind异步)后更新自己的缓存,
+ * 写入数据源失败时缓存不变
+ * @receiver g
+ * @param key
+ * @param value
+ * @return error
+ */
+func (g *Group) Set(key string, value []byte) error {
+	return g.SetContext(context.Background(), key, value)
+}
+
+/**
+ * @Description: 同Set,ctx用于控制转发给owner节点的请求
+ * @receiver g
+ * @param ctx
+ * @param key
+ * @param value
+ * @return error
+ */
+func (g *Group) SetContext(ctx context.Context, key string, value []byte) error {
+	_, err := g.set(ctx, &pb.Request{Group: g.name, Key: key}, value)
+	return err
+}
+
+/**
+ * @Description: 设置本节点缓存中key的值,不写数据源
+ * @receiver g
+ * @param key
+ * @param value
+ * @return uint64 新的版本
+ */
+func (g *Group) setLocally(key string, value []byte) uint64 {
+	v := ByteView{value: cloneBytes(value), version: g.nextVersion()}
+	if g.ttl > 0 {
+		v.expire = timeNow().Add(g.ttl)
+	}
+	//正在进行的加载不能覆盖新写入的值,之后的Get也不再等待它
+	g.leases.invalidate(key, ByteView{}, false, 0)
+	g.loader.Forget(key)
+	g.remoteCache.remove(key)
+	if g.stale != nil {
+		g.stale.remove(key)
+	}
+	g.store(&g.cache, key, v)
+	return v.version
 }
 
+/**
+ * @Description: 删除key,没有配置Deleter时同Remove,只影响本节点;
+ * 配置了Deleter时转发给owner节点,由owner从数据源删除后删除自己的缓存
+ * @receiver g
+ * @param key
+ * @return error
+ */
+func (g *Group) Delete(key string) error {
+	return g.DeleteContext(context.Background(), key)
+}
+
+/**
+ * @Description: 同Delete,ctx用于控制转发给owner节点的请求
+ * @receiver g
+ * @param ctx
+ * @param key
+ * @return error
+ */
+func (g *Group) DeleteContext(ctx context.Context, key string) error {
+	if key == "" {
+		return errors.New("key is required")
+	}
+	if g.deleter == nil {
+		g.Remove(key)
+		return nil
+	}
+	if forwarded, _, err := g.forwardWrite(ctx, &pb.Request{Group: g.name, Key: key}, nil, true); forwarded {
+		return err
+	}
+	return g.ownerDelete(key)
+}
+
+/**
+ * @Description: 只在本节点的缓存中查找,不加载,不刷新,不计入统计,已过期的值当作不存在
+ * @receiver g
+ * @param key
+ * @return ByteView
+ * @return bool
+ */
+func (g *Group) peek(key string) (ByteView, bool) {
+	now := timeNow()
+	for _, c := range []*cache{&g.cache, &g.remoteCache} {
+		if v, ok := c.peek(key); ok && !v.expired(now) {
+			if v, err := g.unpack(key, v); err == nil {
+				return v, true
+			}
+		}
+	}
+	return ByteView{}, false
+}
+
+/**
+ * @Description: 从本节点的cache和remoteCache中删除key,旧值也不再保留,下次Get时重新加载
+ * 只影响本节点,其他节点上的副本会在过期或淘汰后消失
+ * @receiver g
+ * @param key
+ * @return bool key是否存在
+ */
+func (g *Group) Remove(key string) bool {
+	g.invalidate(key)
+	inCache := g.cache.remove(key)
+	inRemote := g.remoteCache.remove(key)
+	if g.stale != nil {
+		g.stale.remove(key)
+	}
+	return inCache || inRemote
+}
+
+/**
+ * @Description: 清空本节点上该group的所有缓存,包括旧值
+ * @receiver g
+ * @return int 清空的条目数
+ */
+func (g *Group) Purge() int {
+	g.leases.invalidateAll()
+	g.loader.ForgetAll()
+	n := g.cache.clear() + g.remoteCache.clear()
+	if g.stale != nil {
+		g.stale.clear()
+	}
+	return n
+}
+
+/**
+ * @Description: 修改本节点缓存中key的过期时间,零值表示永不过期
+ * @receiver g
+ * @param key
+ * @param expire
+ * @return bool key是否存在
+ */
+func (g *Group) touch(key string, expire time.Time) bool {
+	inCache := g.cache.touch(key, expire)
+	inRemote := g.remoteCache.touch(key, expire)
+	return inCache || inRemote
+}
 
 /**
  * @Description: 缓存失效时调用,单机场景下调用getLocally，远程场景调用getFromPeer从其他节点获取数据
@@ -131,52 +444,146 @@ func (g *Group) Get(key string) (ByteView, error)  {
  * @return value
  * @return error
  */
-func (g *Group) load(key string) (value ByteView,err error) {
+func (g *Group) load(ctx context.Context, key string) (value ByteView,err error) {
+	ctx, span := startSpan(ctx, "cache.load")
+	defer func() { span.End(err) }()
+
+	g.stats.Loads.Add(1)
+	//其他协程持有租约正在加载时,先返回失效前的旧值
+	if v, ok := g.leaseStaleValue(key); ok {
+		g.stats.LeaseStaleServes.Add(1)
+		span.SetAttributes("lease.stale", true)
+		return v, nil
+	}
+	start := timeNow()
+	executed := false
 	view,err :=g.loader.Do(key, func() (interface{}, error) {
+		executed = true
+		g.stats.LoadsDeduped.Add(1)
 
 		//remote调用
+		var peerErr error
 		if g.nodePicker !=nil {
 			if nodeClient,ok := g.nodePicker.PickNode(key);ok{
-				if value,err=g.getRemote(nodeClient,key);err == nil{
+				if value,err=g.getRemote(ctx,nodeClient,key);err == nil{
+					g.stats.PeerLoads.Add(1)
 					return value,nil
 				}
-				log.Println("[Cache] Faild to get remote from nodeClient",err)
+				//owner节点明确返回不存在,不需要本地加载
+				if errors.Is(err, ErrNotFound) {
+					g.stats.PeerLoads.Add(1)
+					return nil, err
+				}
+				g.stats.PeerErrors.Add(1)
+				g.logger().Warn("failed to get from peer, fallback to local", "group", g.name, "key", key, "err", err)
+				peerErr = err
 			}
 		}
 		//单机场景
-		return g.getLocally(key)
+		value,err = g.getLocally(ctx,key)
+		if err != nil {
+			g.stats.LocalLoadErrs.Add(1)
+			if peerErr != nil {
+				return nil, &fallbackError{peer: peerErr, local: err}
+			}
+			return nil, err
+		}
+		g.stats.LocalLoads.Add(1)
+		return value, nil
 	})
+	//没有执行fn,说明等待了其他协程的结果
+	span.SetAttributes("singleflight.waited", !executed)
+	if !executed {
+		g.stats.LoadWaits.Add(1)
+		g.loadWait.observe(timeNow().Sub(start))
+	}
 	if err == nil {
 		return view.(ByteView),nil
 	}
+	//加载失败,尝试返回最近过期或被淘汰的旧值,key不存在时不返回
+	if g.stale != nil && !errors.Is(err, ErrNotFound) {
+		if v, ok := g.stale.get(key, timeNow()); ok {
+			if v, uerr := g.unpack(key, v); uerr == nil {
+				g.stats.StaleServes.Add(1)
+				g.logger().Warn("serve stale value after load error", "group", g.name, "key", key, "err", err)
+				return v, nil
+			}
+		}
+	}
 	return
 }
 
+
 /**
  * @Description: 通过nodeClient,能够根据group的名字和具体的key,查询到具体的缓存数据
  * @receiver g
+ * @param ctx
  * @param nodeClient
  * @param key
  * @return ByteView
  * @return error
  */
-func (g *Group) getRemote(nodeClient NodeClient,key string)(ByteView,error)  {
+func (g *Group) getRemote(ctx context.Context,nodeClient NodeClient,key string)(_ ByteView,err error)  {
+	ctx, span := startSpan(ctx, "cache.peer")
+	defer func() { span.End(err) }()
+
 	req:=&pb.Request{
 		Group: g.name,
 		Key: key,
 	}
 	res:=&pb.Response{}
+	//领取租约,加载期间key失效时不写入remoteCache
+	token := g.leases.acquire(key)
+	defer g.leases.release(key, token)
 
+	//remoteCache中有带版本的副本(可能已经过期)时向owner重新验证
+	var old ByteView
+	if v, ok := g.remoteCache.peek(key); ok && v.version != 0 {
+		if u, err := g.unpack(key, v); err == nil {
+			old, req.Version = u, v.version
+		}
+	}
 
 	//bytes,err :=nodeClient.Get(g.name,key) //http 方式
-	if err:=nodeClient.Get(req,res);err != nil {
+	if err=nodeClient.Get(ctx,req,res);err != nil {
 		return ByteView{},err
 	}
 
-	//将远程获取到的数据添加在remoteCache中
-	value := ByteView{value: cloneBytes(res.Value)}
-	if rand.Intn(10) == 0{
-		g.remoteCache.add(key,value)
+	//版本没有变化,只更新过期时间
+	if res.NotModified && req.Version != 0 && res.Version == req.Version {
+		g.stats.PeerRevalidations.Add(1)
+		old.expire = time.Time{}
+		if res.Expire != 0 {
+			old.expire = time.Unix(0, res.Expire)
+		}
+		//副本已经被淘汰时重新写入
+		touched := false
+		if g.fill(key, token, func() { touched = g.remoteCache.touch(key, old.expire) }) && !touched {
+			g.fillValue(&g.remoteCache, key, token, old)
+		}
+		return old, nil
+	}
+
+	//将远程获取到的数据添加在remoteCache中,过期时间以owner节点为准
+	//按owner节点返回的压缩格式解压
+	b, err := decodeValue(res.Encoding, res.Value, maxDecompressedBytes)
+	if err != nil {
+		return ByteView{}, err
+	}
+	if res.Encoding == "" {
+		b = cloneBytes(b)
+	}
+	value := ByteView{value: b, stale: res.Stale, version: res.Version}
+	if res.Expire != 0 {
+		value.expire = time.Unix(0, res.Expire)
+	}
+	//owner节点返回的旧值不缓存
+	if value.stale {
+		return value, nil
+	}
+	//已经在remoteCache中的值(如后台刷新)直接更新,避免一直返回旧值
+	if _, ok := g.remoteCache.get(key); ok || rand.Intn(10) == 0 {
+		g.fillValue(&g.remoteCache, key, token, value)
 	}
 	return value,nil
 }
@@ -185,19 +592,35 @@ func (g *Group) getRemote(nodeClient NodeClient,key string)(ByteView,error)  {
 /**
  * @Description: 单机场景下的获取源数据的方法
  * @receiver g
+ * @param ctx
  * @param key
  * @return ByteView
  * @return error
  */
-func (g *Group) getLocally(key string) (ByteView, error) {
+func (g *Group) getLocally(ctx context.Context, key string) (_ ByteView, err error) {
+	_, span := startSpan(ctx, "cache.getter")
+	defer func() { span.End(err) }()
+
+	//领取租约,加载期间key失效时不写入cache
+	token := g.leases.acquire(key)
+	defer g.leases.release(key, token)
+
 	//调用用户回调函数 g.getter.Get(key)，获取源数据
-	bytes,err := g.getter.Get(key)
+	start := timeNow()
+	bytes,err := g.getSource(key)
 	if err != nil{
 		return ByteView{},err
 	}
 	//将源数据包装为ByteView类型，然后保存
-	value := ByteView{value: cloneBytes(bytes)}
-	g.cache.add(key,value)
+	value := ByteView{value: cloneBytes(bytes), delta: timeNow().Sub(start), version: g.loadVersion(key, bytes)}
+	if g.ttl > 0 {
+		value.expire = start.Add(g.ttl)
+	}
+	//有了新值,旧值就不再需要了
+	if g.stale != nil {
+		g.stale.remove(key)
+	}
+	g.fillValue(&g.cache, key, token, value)
 	return value,nil
 }
 
@@ -212,6 +635,10 @@ type cache struct {
 	mutex sync.Mutex //互斥锁
 	lru *lru.LRU
 	maxBytes int64
+	onEvicted func(key string, value ByteView) //值被淘汰或过期删除时的回调
+
+	nget, nhit, nevict int64 //统计信息,由mutex保护
+	removing           bool  //正在主动删除,不计入淘汰,也不调用onEvicted
 }
 /**
  * @Description: 包装了lru的Add()
@@ -224,11 +651,28 @@ func (c *cache) add(key string, value ByteView){
 	defer c.mutex.Unlock()
 	//延迟初始化
 	if c.lru == nil{
-		c.lru = lru.New(c.maxBytes,nil)
+		c.lru = lru.New(c.maxBytes,c.onDelete)
 	}
 	c.lru.Add(key,value)
 }
 
+/**
+ * @Description: lru的删除回调,转换为ByteView
+ * @receiver c
+ * @param key
+ * @param value
+ */
+func (c *cache) onDelete(key string, value lru.Value) {
+	//主动删除的值(Remove,解密失败等)不再有效,不交给onEvicted
+	if c.removing {
+		return
+	}
+	c.nevict++
+	if c.onEvicted != nil {
+		c.onEvicted(key, value.(ByteView))
+	}
+}
+
 /**
  * @Description: 包装了lru的Get()
  * @receiver c
@@ -239,12 +683,120 @@ func (c *cache) add(key string, value ByteView){
 func (c *cache)get(key string)(value ByteView,ok bool) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
+	c.nget++
 	if c.lru == nil{
 		return
 	}
 	if v,ok := c.lru.Get(key);ok{
+		c.nhit++
 		return v.(ByteView),ok
 	}
 	return
 }
 
+/**
+ * @Description: 同get,但是不计入统计
+ * @receiver c
+ * @param key
+ * @return value
+ * @return ok
+ */
+func (c *cache) peek(key string) (value ByteView, ok bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.lru == nil {
+		return
+	}
+	if v, ok := c.lru.Get(key); ok {
+		return v.(ByteView), true
+	}
+	return
+}
+
+/**
+ * @Description: 清空缓存,不调用onEvicted,也不计入淘汰
+ * @receiver c
+ * @return int 清空的条目数
+ */
+func (c *cache) clear() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.lru == nil {
+		return 0
+	}
+	n := c.lru.Len()
+	c.lru = nil
+	return n
+}
+
+/**
+ * @Description: 修改key的过期时间
+ * @receiver c
+ * @param key
+ * @param expire
+ * @return bool key是否存在
+ */
+func (c *cache) touch(key string, expire time.Time) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.lru == nil {
+		return false
+	}
+	v, ok := c.lru.Get(key)
+	if !ok {
+		return false
+	}
+	view := v.(ByteView)
+	view.expire = expire
+	c.lru.Add(key, view)
+	return true
+}
+
+/**
+ * @Description: 删除过期的值,c中的值已经被替换(过期时间或版本不同)时不删除
+ * @receiver c
+ * @param key
+ * @param expired 检查时读到的过期值
+ * @return bool 是否删除
+ */
+func (c *cache) removeExpired(key string, expired ByteView) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.lru == nil {
+		return false
+	}
+	v, ok := c.lru.Get(key)
+	if !ok {
+		return false
+	}
+	if cur := v.(ByteView); !cur.expire.Equal(expired.expire) || cur.version != expired.version {
+		return false
+	}
+	c.removing = true
+	c.lru.Delete(key)
+	c.removing = false
+	//过期的值仍然可以作为旧值
+	if c.onEvicted != nil {
+		c.onEvicted(key, v.(ByteView))
+	}
+	return true
+}
+
+/**
+ * @Description: 包装了lru的Delete()
+ * @receiver c
+ * @param key
+ * @return bool key是否存在
+ */
+func (c *cache) remove(key string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.lru == nil {
+		return false
+	}
+	c.removing = true
+	ok := c.lru.Delete(key)
+	c.removing = false
+	return ok
+}
+
@@ -0,0 +1,287 @@
+package cache
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+)
+
+/**
+ * @Description: 缓存值的压缩,节点之间传输时按协商结果压缩,也可以压缩后保存在内存中
+ */
+
+const (
+	acceptEncodingHeader = "X-Cache-Accept-Encoding"
+	maxDecompressedBytes = apiMaxValueBytes //解压后的值的默认上限
+)
+
+/**
+ * @Description: 解压后的值超过上限
+ */
+var ErrValueTooLarge = errors.New("value too large")
+
+/**
+ * @Description: 压缩算法,Name用于节点之间协商以及标识内存中值的压缩格式
+ */
+type Codec interface {
+	Name() string
+	Compress(src []byte) ([]byte, error)
+	Decompress(src []byte) ([]byte, error)
+}
+
+/**
+ * @Description: 可选的接口,解压时限制解压后的大小,防止压缩炸弹,gzip和deflate实现了它
+ * 没有实现的Codec解压完成后再检查大小
+ */
+type LimitedDecompressor interface {
+	DecompressLimit(src []byte, limit int64) ([]byte, error)
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{}
+)
+
+func init() {
+	RegisterCodec(NewGzipCodec(gzip.DefaultCompression))
+	RegisterCodec(NewFlateCodec(flate.DefaultCompression))
+}
+
+/**
+ * @Description: 注册压缩算法,节点只接受已注册算法压缩的响应,gzip和deflate默认已注册
+ * 其他算法(如snappy,zstd)可以包装对应的库后注册,同名算法会被替换
+ * @param c
+ */
+func RegisterCodec(c Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[c.Name()] = c
+}
+
+/**
+ * @Description: 按名字获取已注册的压缩算法
+ * @param name
+ * @return Codec
+ * @return bool
+ */
+func getCodec(name string) (Codec, bool) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	c, ok := codecs[name]
+	return c, ok
+}
+
+/**
+ * @Description: 本节点可以解压的算法,请求其他节点时通过acceptEncodingHeader发送
+ * @return string
+ */
+func acceptEncodings() string {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	names := make([]string, 0, len(codecs))
+	for name := range codecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+/**
+ * @Description: 按encoding解压数据,encoding为空表示未压缩
+ * @param encoding
+ * @param b
+ * @param limit 解压后的最大长度
+ * @return []byte
+ * @return error 超过limit时为ErrValueTooLarge
+ */
+func decodeValue(encoding string, b []byte, limit int64) ([]byte, error) {
+	if encoding == "" {
+		return b, nil
+	}
+	c, ok := getCodec(encoding)
+	if !ok {
+		return nil, fmt.Errorf("unknown encoding: %s", encoding)
+	}
+	if l, ok := c.(LimitedDecompressor); ok {
+		return l.DecompressLimit(b, limit)
+	}
+	v, err := c.Decompress(b)
+	if err == nil && int64(len(v)) > limit {
+		return nil, tooLarge(limit)
+	}
+	return v, err
+}
+
+func tooLarge(limit int64) error {
+	return fmt.Errorf("decompressed value exceeds %d bytes: %w", limit, ErrValueTooLarge)
+}
+
+/**
+ * @Description: 最多读取limit字节,超过时返回ErrValueTooLarge
+ * @param r
+ * @param limit
+ * @return []byte
+ * @return error
+ */
+func readLimited(r io.Reader, limit int64) ([]byte, error) {
+	b, err := ioutil.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > limit {
+		return nil, tooLarge(limit)
+	}
+	return b, nil
+}
+
+/**
+ * @Description: gzip压缩,writer通过sync.Pool复用
+ */
+type gzipCodec struct {
+	level int
+	pool  sync.Pool
+}
+
+/**
+ * @Description: 新建一个gzip压缩算法
+ * @param level 压缩级别,见 compress/gzip
+ * @return Codec
+ */
+func NewGzipCodec(level int) Codec {
+	return &gzipCodec{level: level}
+}
+
+func (c *gzipCodec) Name() string { return "gzip" }
+
+func (c *gzipCodec) Compress(src []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, _ := c.pool.Get().(*gzip.Writer)
+	if w == nil {
+		var err error
+		if w, err = gzip.NewWriterLevel(&buf, c.level); err != nil {
+			return nil, err
+		}
+	} else {
+		w.Reset(&buf)
+	}
+	defer c.pool.Put(w)
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *gzipCodec) Decompress(src []byte) ([]byte, error) {
+	return c.DecompressLimit(src, maxDecompressedBytes)
+}
+
+func (c *gzipCodec) DecompressLimit(src []byte, limit int64) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return readLimited(r, limit)
+}
+
+/**
+ * @Description: deflate压缩,没有gzip的头部和校验,适合较小的值
+ */
+type flateCodec struct {
+	level int
+	pool  sync.Pool
+}
+
+/**
+ * @Description: 新建一个deflate压缩算法
+ * @param level 压缩级别,见 compress/flate
+ * @return Codec
+ */
+func NewFlateCodec(level int) Codec {
+	return &flateCodec{level: level}
+}
+
+func (c *flateCodec) Name() string { return "deflate" }
+
+func (c *flateCodec) Compress(src []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, _ := c.pool.Get().(*flate.Writer)
+	if w == nil {
+		var err error
+		if w, err = flate.NewWriter(&buf, c.level); err != nil {
+			return nil, err
+		}
+	} else {
+		w.Reset(&buf)
+	}
+	defer c.pool.Put(w)
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *flateCodec) Decompress(src []byte) ([]byte, error) {
+	return c.DecompressLimit(src, maxDecompressedBytes)
+}
+
+func (c *flateCodec) DecompressLimit(src []byte, limit int64) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(src))
+	defer r.Close()
+	return readLimited(r, limit)
+}
+
+/**
+ * @Description: 压缩value,值小于阈值或者压缩后没有变小时返回原值
+ * @receiver g
+ * @param b
+ * @return []byte
+ * @return string 压缩格式,空表示未压缩
+ */
+func (g *Group) compress(b []byte) ([]byte, string) {
+	if g.codec == nil || len(b) < g.compressThreshold {
+		return b, ""
+	}
+	compressed, err := g.codec.Compress(b)
+	if err != nil {
+		g.logger().Warn("failed to compress value", "group", g.name, "codec", g.codec.Name(), "err", err)
+		return b, ""
+	}
+	if len(compressed) >= len(b) {
+		return b, ""
+	}
+	return compressed, g.codec.Name()
+}
+
+/**
+ * @Description: 压缩后发送给其他节点,accept为请求方可以解压的算法
+ * @receiver g
+ * @param b
+ * @param accept
+ * @return []byte
+ * @return string
+ */
+func (g *Group) encodeForPeer(b []byte, accept string) ([]byte, string) {
+	if g.codec == nil {
+		return b, ""
+	}
+	for _, name := range strings.Split(accept, ",") {
+		if strings.TrimSpace(name) == g.codec.Name() {
+			return g.compress(b)
+		}
+	}
+	return b, ""
+}
@@ -0,0 +1,136 @@
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	pb "cache/cachepb"
+	"google.golang.org/protobuf/proto"
+)
+
+var jsonBlob = []byte(strings.Repeat(`{"name":"Tom","score":630,"tags":["a","b","c"]},`, 50))
+
+func TestCodecs(t *testing.T) {
+	for _, name := range []string{"gzip", "deflate"} {
+		c, ok := getCodec(name)
+		if !ok {
+			t.Fatalf("%s should be registered by default", name)
+		}
+		for i := 0; i < 3; i++ { //复用pool中的writer
+			compressed, err := c.Compress(jsonBlob)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(compressed) >= len(jsonBlob)/5 {
+				t.Fatalf("%s: expected at least 5x compression, got %d => %d", name, len(jsonBlob), len(compressed))
+			}
+			b, err := c.Decompress(compressed)
+			if err != nil || !bytes.Equal(b, jsonBlob) {
+				t.Fatalf("%s: round trip failed: %v", name, err)
+			}
+		}
+	}
+	//解压后超过上限时返回错误,不会读出整个值
+	for _, name := range []string{"gzip", "deflate"} {
+		c, _ := getCodec(name)
+		bomb, _ := c.Compress(make([]byte, 1<<20))
+		if _, err := decodeValue(name, bomb, 1<<10); !errors.Is(err, ErrValueTooLarge) {
+			t.Fatalf("%s: expected ErrValueTooLarge, got %v", name, err)
+		}
+		if b, err := decodeValue(name, bomb, 1<<20); err != nil || len(b) != 1<<20 {
+			t.Fatalf("%s: expected a value at the limit to decode, got %d bytes err=%v", name, len(b), err)
+		}
+	}
+	if _, err := decodeValue("zstd", jsonBlob, maxDecompressedBytes); err == nil {
+		t.Fatal("expected unknown encoding to fail")
+	}
+}
+
+func TestCompressInMemory(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		if key == "small" {
+			return []byte("tiny"), nil
+		}
+		return jsonBlob, nil
+	})
+	plain := NewGroup("compress-plain", 1<<20, getter)
+	packed := NewGroup("compress-packed", 1<<20, getter, WithCompression(NewGzipCodec(gzip.BestSpeed), 64, true))
+
+	for _, g := range []*Group{plain, packed} {
+		for i := 0; i < 2; i++ { //第二次从缓存中读取
+			v, err := g.Get("blob")
+			if err != nil || !bytes.Equal(v.Copy(), jsonBlob) {
+				t.Fatalf("%s: unexpected value: %v", g.name, err)
+			}
+		}
+	}
+	if s := packed.Stats(); s.CacheHits != 1 || s.MainCache.Bytes*5 >= plain.Stats().MainCache.Bytes {
+		t.Fatalf("expected compressed bytes to be counted, got %d vs %d", s.MainCache.Bytes, plain.Stats().MainCache.Bytes)
+	}
+
+	//小于阈值的值不压缩
+	packed.Get("small")
+	if v, _ := packed.cache.get("small"); v.encoding != "" || v.String() != "tiny" {
+		t.Fatalf("small value should be stored raw, got %q", v.encoding)
+	}
+}
+
+func TestCompressLargerThanCache(t *testing.T) {
+	//压缩后能放进cache的值,解压后可以超过group的maxBytes
+	g := NewGroup("compress-large", int64(len(jsonBlob)/2), GetterFunc(func(key string) ([]byte, error) {
+		return jsonBlob, nil
+	}), WithCompression(NewGzipCodec(gzip.BestSpeed), 64, true))
+	for i := 0; i < 2; i++ {
+		v, err := g.Get("blob")
+		if err != nil || !bytes.Equal(v.Copy(), jsonBlob) {
+			t.Fatalf("unexpected value: %v", err)
+		}
+	}
+	if s := g.Stats(); s.CacheHits != 1 {
+		t.Fatalf("expected the compressed value to be cached, got %+v", s)
+	}
+}
+
+func TestCompressNegotiation(t *testing.T) {
+	NewGroup("compress-owner", 1<<20, GetterFunc(func(key string) ([]byte, error) {
+		return jsonBlob, nil
+	}), WithCompression(NewFlateCodec(gzip.BestSpeed), 64, false))
+	server := httptest.NewServer(NewGroupHTTP("http://peer"))
+	defer server.Close()
+
+	//httpClient声明支持所有已注册的算法
+	res := &pb.Response{}
+	self := NewGroupHTTP("http://self")
+	self.Set(server.URL)
+	c := self.NodeClientMap[server.URL]
+	if err := c.Get(context.Background(), &pb.Request{Group: "compress-owner", Key: "k"}, res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Encoding != "deflate" || len(res.Value) >= len(jsonBlob) {
+		t.Fatalf("expected deflate response, got %q with %d bytes", res.Encoding, len(res.Value))
+	}
+
+	//请求方不支持时返回原值
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/cache/compress-owner/k", nil)
+	r.Header.Set(acceptEncodingHeader, "zstd")
+	NewGroupHTTP("http://peer").ServeHTTP(w, r)
+	raw := &pb.Response{}
+	if err := proto.Unmarshal(w.Body.Bytes(), raw); err != nil || raw.Encoding != "" || !bytes.Equal(raw.Value, jsonBlob) {
+		t.Fatalf("expected raw response, got %q: %v", raw.Encoding, err)
+	}
+
+	//getRemote解压后保存原值
+	client := NewGroup("compress-client", 1<<20, GetterFunc(func(key string) ([]byte, error) {
+		return nil, nil
+	}))
+	v, err := client.getRemote(context.Background(), renameNode{"compress-owner", c}, "k")
+	if err != nil || !bytes.Equal(v.Copy(), jsonBlob) {
+		t.Fatalf("expected decompressed value: %v", err)
+	}
+}
@@ -0,0 +1,531 @@
+package cache
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"cache/gossip"
+	"gopkg.in/yaml.v3"
+)
+
+/**
+ * @Description: 节点的配置文件,支持YAML和JSON(JSON是YAML的子集)
+ * addr: http://localhost:8001          # 本节点地址,也是其他节点访问本节点的地址
+ * listen: :8001                        # 监听地址,默认为addr中的host
+ * api: localhost:9999                  # REST API的监听地址,为空时不开启
+ * prefix: /cache/                      # 节点之间请求的路径前缀
+ * replicas: 50                         # 一致性hash上每个节点的虚拟节点数
+ * peers:                               # 集群中的所有节点,不包含addr时会自动加上
+ *   - http://localhost:8002
+ * groups:
+ *   - name: scores
+ *     maxBytes: 64MB                   # 0表示不限制
+ *     eviction: lru
+ *     ttl: 10m
+ * gossip:                              # 可选,使用gossip自动发现节点,此时peers只是初始的节点
+ *   bind: 0.0.0.0:7946                 # gossip的UDP监听地址
+ *   advertise: 10.0.0.1:7946           # 其他节点访问本节点的gossip地址,默认为bind
+ *   seeds: [10.0.0.2:7946]             # 加入集群时联系的节点
+ *   key: secret                        # 签名gossip报文的密钥
+ * discovery:                           # 可选,和gossip二选一,file/dns/registry只能配置一个,此时peers只是初始的节点
+ *   file: /etc/cache/peers             # 每行一个节点地址的文件
+ *   dns: _cache._tcp.example.com       # port为0时查询SRV记录,否则查询A/AAAA记录
+ *   port: 8001
+ *   scheme: http                       # DNS记录对应的节点地址的scheme
+ *   registry: http://registry:7000/    # HTTP注册中心,会定期注册addr,见 Registry
+ *   interval: 10s                      # 轮询间隔
+ *   debounce: 1s                       # 节点变化后等待debounce没有新的变化才重建一致性hash
+ */
+
+const defaultEviction = "lru"
+
+/**
+ * @Description: 支持的淘汰策略
+ */
+var evictionPolicies = []string{defaultEviction}
+
+/**
+ * @Description: 配置文件中的一个group
+ */
+type GroupSpec struct {
+	Name     string        `yaml:"name"`
+	MaxBytes ByteSize      `yaml:"maxBytes"`
+	Eviction string        `yaml:"eviction"`
+	TTL      time.Duration `yaml:"ttl"`
+}
+
+/**
+ * @Description: 配置文件中的gossip配置
+ */
+type GossipSpec struct {
+	Bind      string   `yaml:"bind"`
+	Advertise string   `yaml:"advertise"`
+	Seeds     []string `yaml:"seeds"`
+	Key       string   `yaml:"key"`
+}
+
+/**
+ * @Description: 配置文件中的节点发现配置
+ */
+type DiscoverySpec struct {
+	File     string        `yaml:"file"`
+	DNS      string        `yaml:"dns"`
+	Port     int           `yaml:"port"`
+	Scheme   string        `yaml:"scheme"`
+	Registry string        `yaml:"registry"`
+	Interval time.Duration `yaml:"interval"`
+	Debounce time.Duration `yaml:"debounce"`
+}
+
+/**
+ * @Description: 节点的配置
+ */
+type Config struct {
+	Addr      string         `yaml:"addr"`
+	Listen    string         `yaml:"listen"`
+	API       string         `yaml:"api"`
+	Prefix    string         `yaml:"prefix"`
+	Replicas  int            `yaml:"replicas"`
+	Peers     []string       `yaml:"peers"`
+	Groups    []GroupSpec    `yaml:"groups"`
+	Gossip    *GossipSpec    `yaml:"gossip"`
+	Discovery *DiscoverySpec `yaml:"discovery"`
+
+	file string     //配置文件路径,用于错误信息
+	root *yaml.Node //解析后的文档,用于在错误信息中给出行号
+}
+
+/**
+ * @Description: 字节数,可以写成整数或者带单位的字符串,如 2048, 2KB, 64MB, 1GiB
+ */
+type ByteSize int64
+
+var byteUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"KIB", 1 << 10}, {"MIB", 1 << 20}, {"GIB", 1 << 30},
+	{"KB", 1 << 10}, {"MB", 1 << 20}, {"GB", 1 << 30},
+	{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30},
+	{"B", 1},
+}
+
+/**
+ * @Description: 解析带单位的字节数
+ * @param s
+ * @return ByteSize
+ * @return error
+ */
+func ParseByteSize(s string) (ByteSize, error) {
+	str := strings.ToUpper(strings.TrimSpace(s))
+	mul := int64(1)
+	for _, u := range byteUnits {
+		if strings.HasSuffix(str, u.suffix) {
+			str, mul = strings.TrimSpace(str[:len(str)-len(u.suffix)]), u.size
+			break
+		}
+	}
+	n, err := strconv.ParseInt(str, 10, 64)
+	//乘以单位后溢出的也是无效的
+	if err != nil || n > math.MaxInt64/mul || n < math.MinInt64/mul {
+		return 0, fmt.Errorf("invalid byte size %q", s)
+	}
+	return ByteSize(n * mul), nil
+}
+
+func (b *ByteSize) UnmarshalYAML(value *yaml.Node) error {
+	n, err := ParseByteSize(value.Value)
+	if err != nil || value.Kind != yaml.ScalarNode {
+		//返回TypeError,yaml会继续解析并收集其他字段的错误
+		return &yaml.TypeError{Errors: []string{fmt.Sprintf("line %d: invalid byte size %q", value.Line, value.Value)}}
+	}
+	*b = n
+	return nil
+}
+
+/**
+ * @Description: 配置中一个字段的错误
+ */
+type ConfigError struct {
+	File  string
+	Line  int    //为0时表示没有对应的行,比如缺少的字段
+	Field string //字段路径,如 groups[1].ttl
+	Msg   string
+}
+
+func (e *ConfigError) Error() string {
+	var b strings.Builder
+	if e.File != "" {
+		b.WriteString(e.File)
+		b.WriteString(":")
+	}
+	if e.Line > 0 {
+		fmt.Fprintf(&b, "%d:", e.Line)
+	}
+	if b.Len() > 0 {
+		b.WriteString(" ")
+	}
+	if e.Field != "" {
+		b.WriteString(e.Field)
+		b.WriteString(": ")
+	}
+	b.WriteString(e.Msg)
+	return b.String()
+}
+
+/**
+ * @Description: 配置中所有的错误
+ */
+type ConfigErrors []*ConfigError
+
+func (es ConfigErrors) Error() string {
+	msgs := make([]string, len(es))
+	for i, e := range es {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+/**
+ * @Description: 读取并校验配置文件
+ * @param path
+ * @return *Config
+ * @return error 校验失败时为ConfigErrors
+ */
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c, err := parseConfig(path, data)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+/**
+ * @Description: 解析并校验配置
+ * @param data YAML或者JSON
+ * @return *Config
+ * @return error
+ */
+func ParseConfig(data []byte) (*Config, error) {
+	return parseConfig("", data)
+}
+
+func parseConfig(file string, data []byte) (*Config, error) {
+	c := &Config{file: file, root: &yaml.Node{}}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil {
+		return nil, c.decodeError(err)
+	}
+	if err := yaml.Unmarshal(data, c.root); err != nil {
+		return nil, c.decodeError(err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+/**
+ * @Description: 把yaml的错误转换为ConfigErrors,yaml的错误信息形如 "line 3: field foo not found in type cache.Config"
+ * @receiver c
+ * @param err
+ * @return error
+ */
+func (c *Config) decodeError(err error) error {
+	var msgs []string
+	if te, ok := err.(*yaml.TypeError); ok {
+		msgs = te.Errors
+	} else {
+		msgs = []string{strings.TrimPrefix(err.Error(), "yaml: ")}
+	}
+	errs := make(ConfigErrors, 0, len(msgs))
+	for _, msg := range msgs {
+		e := &ConfigError{File: c.file, Msg: msg}
+		if n, _ := fmt.Sscanf(msg, "line %d:", &e.Line); n == 1 {
+			e.Msg = strings.TrimSpace(msg[strings.Index(msg, ":")+1:])
+		}
+		errs = append(errs, e)
+	}
+	return errs
+}
+
+/**
+ * @Description: 校验配置,返回所有错误,没有错误时返回nil
+ * @receiver c
+ * @return error
+ */
+func (c *Config) Validate() error {
+	var errs ConfigErrors
+	fail := func(field string, format string, args ...interface{}) {
+		errs = append(errs, &ConfigError{File: c.file, Line: c.line(field), Field: field, Msg: fmt.Sprintf(format, args...)})
+	}
+
+	if c.Addr == "" {
+		fail("addr", "is required")
+	} else if err := validateNodeAddr(c.Addr); err != nil {
+		fail("addr", "%v", err)
+	}
+	if c.Prefix != "" && (!strings.HasPrefix(c.Prefix, "/") || !strings.HasSuffix(c.Prefix, "/")) {
+		fail("prefix", "must start and end with /, got %q", c.Prefix)
+	}
+	if c.Replicas < 0 {
+		fail("replicas", "must not be negative, got %d", c.Replicas)
+	}
+	seen := make(map[string]bool)
+	for i, peer := range c.Peers {
+		field := fmt.Sprintf("peers[%d]", i)
+		if err := validateNodeAddr(peer); err != nil {
+			fail(field, "%v", err)
+		} else if seen[peer] {
+			fail(field, "duplicate peer %s", peer)
+		}
+		seen[peer] = true
+	}
+
+	if len(c.Groups) == 0 {
+		fail("groups", "at least one group is required")
+	}
+	names := make(map[string]bool)
+	for i, spec := range c.Groups {
+		field := fmt.Sprintf("groups[%d]", i)
+		switch {
+		case spec.Name == "":
+			fail(field+".name", "is required")
+		case strings.ContainsAny(spec.Name, "/:"):
+			fail(field+".name", "must not contain / or :, got %q", spec.Name)
+		case names[spec.Name]:
+			fail(field+".name", "duplicate group %s", spec.Name)
+		}
+		names[spec.Name] = true
+		if spec.MaxBytes < 0 {
+			fail(field+".maxBytes", "must not be negative, got %d", spec.MaxBytes)
+		}
+		if spec.Eviction != "" && !contains(evictionPolicies, spec.Eviction) {
+			fail(field+".eviction", "unsupported eviction policy %q, supported: %s", spec.Eviction, strings.Join(evictionPolicies, ", "))
+		}
+		if spec.TTL < 0 {
+			fail(field+".ttl", "must not be negative, got %s", spec.TTL)
+		}
+	}
+	if c.Gossip != nil {
+		if c.Gossip.Bind == "" {
+			fail("gossip.bind", "is required")
+		} else if _, _, err := net.SplitHostPort(c.Gossip.Bind); err != nil {
+			fail("gossip.bind", "must be host:port, got %q", c.Gossip.Bind)
+		}
+		if _, _, err := net.SplitHostPort(c.Gossip.Advertise); c.Gossip.Advertise != "" && err != nil {
+			fail("gossip.advertise", "must be host:port, got %q", c.Gossip.Advertise)
+		}
+		for i, seed := range c.Gossip.Seeds {
+			if _, _, err := net.SplitHostPort(seed); err != nil {
+				fail(fmt.Sprintf("gossip.seeds[%d]", i), "must be host:port, got %q", seed)
+			}
+		}
+	}
+	if d := c.Discovery; d != nil {
+		sources := 0
+		for _, source := range []string{d.File, d.DNS, d.Registry} {
+			if source != "" {
+				sources++
+			}
+		}
+		if sources != 1 {
+			fail("discovery", "exactly one of file, dns and registry is required")
+		}
+		if c.Gossip != nil {
+			fail("discovery", "can not be used together with gossip")
+		}
+		if d.Port < 0 || d.Port > 65535 {
+			fail("discovery.port", "must be between 0 and 65535, got %d", d.Port)
+		}
+		if d.Scheme != "" && d.Scheme != "http" && d.Scheme != "https" {
+			fail("discovery.scheme", "must be http or https, got %q", d.Scheme)
+		}
+		if u, err := url.Parse(d.Registry); d.Registry != "" && (err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https")) {
+			fail("discovery.registry", "must be an http(s) URL, got %q", d.Registry)
+		}
+		if d.Interval < 0 {
+			fail("discovery.interval", "must not be negative, got %s", d.Interval)
+		}
+		if d.Debounce < 0 {
+			fail("discovery.debounce", "must not be negative, got %s", d.Debounce)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+/**
+ * @Description: 节点地址必须是 http(s)://host:port 的形式,不能带路径
+ * @param addr
+ * @return error
+ */
+func validateNodeAddr(addr string) error {
+	u, err := url.Parse(addr)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("must be an http(s) URL like http://host:port, got %q", addr)
+	}
+	if u.Path != "" && u.Path != "/" || u.RawQuery != "" {
+		return fmt.Errorf("must not contain a path or query, got %q", addr)
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * @Description: 找到字段在配置文件中的行号,字段不存在时返回其所在对象的行号
+ * @receiver c
+ * @param field 如 groups[1].ttl
+ * @return int
+ */
+func (c *Config) line(field string) int {
+	if c.root == nil || len(c.root.Content) == 0 {
+		return 0
+	}
+	node := c.root.Content[0]
+	for _, part := range strings.Split(field, ".") {
+		index := -1
+		if i := strings.Index(part, "["); i >= 0 {
+			index, _ = strconv.Atoi(strings.TrimSuffix(part[i+1:], "]"))
+			part = part[:i]
+		}
+		next := mappingValue(node, part)
+		if next == nil {
+			return node.Line
+		}
+		node = next
+		if index >= 0 {
+			if node.Kind != yaml.SequenceNode || index >= len(node.Content) {
+				return node.Line
+			}
+			node = node.Content[index]
+		}
+	}
+	return node.Line
+}
+
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+/**
+ * @Description: 监听地址,没有配置listen时使用addr中的host
+ * @receiver c
+ * @return string
+ */
+func (c *Config) ListenAddr() string {
+	if c.Listen != "" {
+		return c.Listen
+	}
+	u, _ := url.Parse(c.Addr)
+	return u.Host
+}
+
+/**
+ * @Description: 一致性hash上的所有节点,包括本节点
+ * @receiver c
+ * @return []string
+ */
+func (c *Config) Nodes() []string {
+	nodes := append([]string{}, c.Peers...)
+	if !contains(nodes, c.Addr) {
+		nodes = append(nodes, c.Addr)
+	}
+	return nodes
+}
+
+/**
+ * @Description: 配置对应的GroupHTTP选项
+ * @receiver c
+ * @return []HTTPOption
+ */
+func (c *Config) HTTPOptions() []HTTPOption {
+	var opts []HTTPOption
+	if c.Prefix != "" {
+		opts = append(opts, WithBasePath(c.Prefix))
+	}
+	if c.Replicas > 0 {
+		opts = append(opts, WithReplicas(c.Replicas))
+	}
+	return opts
+}
+
+/**
+ * @Description: 按配置创建group
+ * @receiver s
+ * @param getter
+ * @param opts 额外的选项,在配置的选项之后应用
+ * @return *Group
+ */
+func (s GroupSpec) NewGroup(getter Getter, opts ...GroupOption) *Group {
+	if s.TTL > 0 {
+		opts = append([]GroupOption{WithTTL(s.TTL)}, opts...)
+	}
+	return NewGroup(s.Name, int64(s.MaxBytes), getter, opts...)
+}
+
+/**
+ * @Description: 对应的gossip配置,其他参数使用默认值,见 JoinGossip
+ * @receiver s
+ * @return gossip.Config
+ */
+func (s *GossipSpec) Config() gossip.Config {
+	cfg := gossip.Config{BindAddr: s.Bind, AdvertiseAddr: s.Advertise}
+	if s.Key != "" {
+		cfg.Key = []byte(s.Key)
+	}
+	return cfg
+}
+
+/**
+ * @Description: 对应的节点发现
+ * @receiver s
+ * @param self 本节点地址,使用注册中心时注册该地址
+ * @return Discovery
+ */
+func (s *DiscoverySpec) Discovery(self string) Discovery {
+	switch {
+	case s.File != "":
+		return NewFileDiscovery(s.File, s.Interval)
+	case s.DNS != "":
+		d := NewDNSDiscovery(s.DNS, s.Port)
+		if s.Scheme != "" {
+			d.Scheme = s.Scheme
+		}
+		if s.Interval > 0 {
+			d.Interval = s.Interval
+		}
+		return d
+	}
+	return NewHTTPDiscovery(s.Registry, self, s.Interval)
+}
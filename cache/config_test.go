@@ -0,0 +1,173 @@
+package cache
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseConfig(t *testing.T) {
+	yamlConfig := `
+addr: http://localhost:8001
+prefix: /_cache/
+replicas: 100
+peers:
+  - http://localhost:8002
+  - http://localhost:8003
+groups:
+  - name: scores
+    maxBytes: 64MB
+    eviction: lru
+    ttl: 10m
+  - name: users
+    maxBytes: 2048
+gossip:
+  bind: 127.0.0.1:7946
+  seeds: [127.0.0.1:7947]
+`
+	jsonConfig := `{
+  "addr": "http://localhost:8001",
+  "prefix": "/_cache/",
+  "replicas": 100,
+  "peers": ["http://localhost:8002", "http://localhost:8003"],
+  "groups": [
+    {"name": "scores", "maxBytes": "64MB", "eviction": "lru", "ttl": "10m"},
+    {"name": "users", "maxBytes": 2048}
+  ],
+  "gossip": {"bind": "127.0.0.1:7946", "seeds": ["127.0.0.1:7947"]}
+}`
+	for _, data := range []string{yamlConfig, jsonConfig} {
+		c, err := ParseConfig([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.ListenAddr() != "localhost:8001" || c.Replicas != 100 || len(c.Nodes()) != 3 {
+			t.Fatalf("unexpected config %+v", c)
+		}
+		want := []GroupSpec{
+			{Name: "scores", MaxBytes: 64 << 20, Eviction: "lru", TTL: 10 * time.Minute},
+			{Name: "users", MaxBytes: 2048},
+		}
+		if len(c.Groups) != 2 || c.Groups[0] != want[0] || c.Groups[1] != want[1] {
+			t.Fatalf("expected groups %+v, got %+v", want, c.Groups)
+		}
+		if c.Gossip == nil || c.Gossip.Config().BindAddr != "127.0.0.1:7946" || len(c.Gossip.Seeds) != 1 {
+			t.Fatalf("unexpected gossip config %+v", c.Gossip)
+		}
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	cases := []struct {
+		in   string
+		want ByteSize
+		ok   bool
+	}{
+		{"1024", 1024, true},
+		{"64kb", 64 << 10, true},
+		{" 2 MB ", 2 << 20, true},
+		{"8589934591GB", 8589934591 << 30, true},
+		{"8589934592GB", 0, false},
+		{"9223372036854775807", 1<<63 - 1, true},
+		{"9223372036854775808", 0, false},
+		{"lots", 0, false},
+	}
+	for _, c := range cases {
+		got, err := ParseByteSize(c.in)
+		if (err == nil) != c.ok || got != c.want {
+			t.Fatalf("%q: expected %d ok=%v, got %d err=%v", c.in, c.want, c.ok, got, err)
+		}
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	cases := []struct {
+		data string
+		want []string
+	}{
+		{`
+addr: localhost:8001
+peers: [http://localhost:8002, http://localhost:8002]
+groups:
+  - name: scores
+    eviction: lfu
+    ttl: -1s
+`, []string{
+			`2: addr: must be an http(s) URL`,
+			`3: peers[1]: duplicate peer`,
+			`6: groups[0].eviction: unsupported eviction policy "lfu"`,
+			`7: groups[0].ttl: must not be negative`,
+		}},
+		{`
+addr: http://localhost:8001
+groups:
+  - name: scores
+    maxBytes: lots
+    ttl: forever
+  - name: scores
+    colour: red
+`, []string{
+			`5: invalid byte size "lots"`,
+			`6: cannot unmarshal !!str ` + "`forever`",
+			`8: field colour not found`,
+		}},
+		{`
+prefix: cache
+groups:
+  - name: a/b
+  - name: ok
+  - name: ok
+gossip:
+  seeds: [nohost]
+`, []string{
+			`addr: is required`,
+			`2: prefix: must start and end with /`,
+			`4: groups[0].name: must not contain / or :`,
+			`6: groups[2].name: duplicate group ok`,
+			`8: gossip.bind: is required`,
+			`8: gossip.seeds[0]: must be host:port`,
+		}},
+		{`
+addr: http://localhost:8001
+groups:
+  - name: scores
+discovery:
+  dns: cache.example.com
+  registry: registry:7000
+  scheme: ftp
+  debounce: -1s
+`, []string{
+			`6: discovery: exactly one of file, dns and registry is required`,
+			`8: discovery.scheme: must be http or https`,
+			`7: discovery.registry: must be an http(s) URL`,
+			`9: discovery.debounce: must not be negative`,
+		}},
+		{`addr: [`, []string{`1: did not find expected node content`}},
+	}
+	for i, c := range cases {
+		_, err := ParseConfig([]byte(c.data))
+		errs, ok := err.(ConfigErrors)
+		if !ok {
+			t.Fatalf("case %d: expected ConfigErrors, got %v", i, err)
+		}
+		if len(errs) != len(c.want) {
+			t.Fatalf("case %d: expected %d errors, got:\n%v", i, len(c.want), err)
+		}
+		for j, want := range c.want {
+			if !strings.Contains(errs[j].Error(), want) {
+				t.Errorf("case %d: expected error %q, got %q", i, want, errs[j].Error())
+			}
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.yaml")
+	ioutil.WriteFile(path, []byte("addr: http://localhost:8001\ngroups:\n  - name: scores\n    maxBytes: -1\n"), 0600)
+	_, err := LoadConfig(path)
+	if err == nil || err.Error() != path+":4: groups[0].maxBytes: must not be negative, got -1" {
+		t.Fatalf("expected error with file and line, got %v", err)
+	}
+}
@@ -72,3 +72,72 @@ func (c *ConsistentHash)Get(key string) string{
 	return c.virNodeMap[c.keys[indexOfKeys%len(c.keys)]]
 }
 
+/**
+ * @Description: 从key在环上的位置开始顺时针查找,返回最多n个不同的真实节点,第一个就是Get返回的节点
+ * @receiver c
+ * @param key
+ * @param n
+ * @return []string
+ */
+func (c *ConsistentHash) GetN(key string, n int) []string {
+	if len(c.keys) == 0 || n <= 0 {
+		return nil
+	}
+	keyHashCode := int(c.hash([]byte(key)))
+	indexOfKeys := sort.Search(len(c.keys), func(i int) bool {
+		return c.keys[i] >= keyHashCode
+	})
+	nodes := make([]string, 0, n)
+	seen := make(map[string]bool, n)
+	for i := 0; i < len(c.keys) && len(nodes) < n; i++ {
+		nodeName := c.virNodeMap[c.keys[(indexOfKeys+i)%len(c.keys)]]
+		if !seen[nodeName] {
+			seen[nodeName] = true
+			nodes = append(nodes, nodeName)
+		}
+	}
+	return nodes
+}
+
+/**
+ * @Description: 环上的真实节点
+ */
+type Node struct {
+	Name         string
+	VirtualNodes int     //虚拟节点的个数
+	Share        float64 //负责的hash空间的比例
+}
+
+/**
+ * @Description: 返回环上所有的真实节点以及负责的hash空间的比例,按名字排序
+ * @receiver c
+ * @return []Node
+ */
+func (c *ConsistentHash) Nodes() []Node {
+	if len(c.keys) == 0 {
+		return nil
+	}
+	const space = float64(1 << 32)
+	index := make(map[string]int)
+	var nodes []Node
+	for i, k := range c.keys {
+		nodeName := c.virNodeMap[k]
+		j, ok := index[nodeName]
+		if !ok {
+			j = len(nodes)
+			index[nodeName] = j
+			nodes = append(nodes, Node{Name: nodeName})
+		}
+		//(上一个虚拟节点, 当前虚拟节点] 之间的key属于当前虚拟节点,第一个虚拟节点还负责环的末尾
+		prev := 0
+		if i > 0 {
+			prev = c.keys[i-1]
+		} else {
+			prev = c.keys[len(c.keys)-1] - (1 << 32)
+		}
+		nodes[j].VirtualNodes++
+		nodes[j].Share += float64(k-prev) / space
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+	return nodes
+}
@@ -1,6 +1,7 @@
 package consistenthash
 
 import (
+	"reflect"
 	"strconv"
 	"testing"
 )
@@ -39,3 +40,53 @@ func TestConsistenthash(t *testing.T) {
 	}
 
 }
+
+func TestGetN(t *testing.T) {
+	chash := New(3, func(key []byte) uint32 {
+		i, _ := strconv.Atoi(string(key))
+		return uint32(i)
+	})
+	chash.Add("6", "4", "2")
+
+	//环: 2 4 6 12 14 16 22 24 26
+	testCases := map[string][]string{
+		"11": {"2", "4", "6"},
+		"23": {"4", "6", "2"},
+		"27": {"2", "4", "6"},
+	}
+	for k, v := range testCases {
+		if got := chash.GetN(k, 3); !reflect.DeepEqual(got, v) {
+			t.Errorf("Asking for %s, should have yielded %v, got %v", k, v, got)
+		}
+		if got := chash.GetN(k, 1); got[0] != chash.Get(k) {
+			t.Errorf("GetN(%s, 1) should be the same as Get, got %v", k, got)
+		}
+	}
+	if got := chash.GetN("11", 10); len(got) != 3 {
+		t.Errorf("GetN should return at most all nodes, got %v", got)
+	}
+}
+
+func TestNodes(t *testing.T) {
+	chash := New(3, func(key []byte) uint32 {
+		i, _ := strconv.Atoi(string(key))
+		return uint32(i)
+	})
+	chash.Add("6", "4", "2")
+
+	//环: 2 4 6 12 14 16 22 24 26,节点2还负责(26, 2^32+2]
+	nodes := chash.Nodes()
+	if len(nodes) != 3 || nodes[0].Name != "2" || nodes[0].VirtualNodes != 3 {
+		t.Fatalf("unexpected nodes %+v", nodes)
+	}
+	if share := nodes[1].Share * (1 << 32); share != 6 {
+		t.Errorf("node 4 should own 6 hashes, got %v", share)
+	}
+	total := 0.0
+	for _, n := range nodes {
+		total += n.Share
+	}
+	if total < 0.999999 || total > 1.000001 {
+		t.Errorf("shares should add up to 1, got %v", total)
+	}
+}
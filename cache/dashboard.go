@@ -0,0 +1,68 @@
+package cache
+
+import (
+	_ "embed"
+	"net/http"
+	"time"
+)
+
+/**
+ * @Description: 内嵌的监控页面,页面本身不需要token,页面中输入admin token后轮询 /_admin/overview
+ */
+
+//go:embed dashboard/index.html
+var dashboardHTML []byte
+
+const defaultHotKeysShown = 10
+
+/**
+ * @Description: 监控页面中一个group的信息
+ */
+type GroupOverview struct {
+	Config  GroupConfig `json:"config"`
+	Stats   GroupStats  `json:"stats"`
+	HotKeys []HotKey    `json:"hot_keys"`
+}
+
+/**
+ * @Description: 监控页面需要的所有信息,一次请求返回
+ */
+type Overview struct {
+	Self      string          `json:"self"`
+	Time      time.Time       `json:"time"`
+	Groups    []GroupOverview `json:"groups"`
+	Ring      []RingNode      `json:"ring"`
+	Peers     []PeerStatus    `json:"peers"`
+	Hedges    int64           `json:"hedges"`
+	HedgeWins int64           `json:"hedge_wins"`
+}
+
+/**
+ * @Description: 返回本节点的概况
+ * @receiver g
+ * @return Overview
+ */
+func (g *GroupHTTP) Overview() Overview {
+	o := Overview{
+		Self:   g.addr,
+		Time:   time.Now(),
+		Groups: []GroupOverview{},
+		Ring:   g.Ring(),
+		Peers:  g.PeersHealth(),
+	}
+	o.Hedges, o.HedgeWins = g.HedgeStats()
+	for _, group := range allGroups() {
+		o.Groups = append(o.Groups, GroupOverview{
+			Config:  group.Config(),
+			Stats:   group.Stats(),
+			HotKeys: group.HotKeys(defaultHotKeysShown),
+		})
+	}
+	return o
+}
+
+func serveDashboard(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(dashboardHTML)
+}
@@ -0,0 +1,94 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestHotKeys(t *testing.T) {
+	h := newHotKeys(4, 1000)
+	for i := 0; i < 20; i++ {
+		h.touch("hot")
+		h.touch("hot")
+		h.touch("warm")
+		h.touch(fmt.Sprint("cold-", i))
+	}
+	top := h.top(2)
+	if len(top) != 2 || top[0].Key != "hot" || top[1].Key != "warm" {
+		t.Fatalf("expected hot and warm on top, got %+v", top)
+	}
+	if len(h.counts) > 4 {
+		t.Fatalf("expected at most 4 counters, got %d", len(h.counts))
+	}
+
+	//每decayEvery次访问计数减半
+	h = newHotKeys(3, 4)
+	for i := 0; i < 4; i++ {
+		h.touch("a")
+	}
+	if top := h.top(1); top[0].Count != 2 {
+		t.Fatalf("expected count halved to 2, got %+v", top)
+	}
+}
+
+func TestDashboard(t *testing.T) {
+	g := NewGroupHTTP("http://self", WithAdmin("secret"))
+	w := adminDo(g, "GET", "/_admin/dashboard", "")
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("expected dashboard without token, got %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(w.Body.String(), "overview") {
+		t.Fatal("dashboard should poll the overview endpoint")
+	}
+	if w := adminDo(NewGroupHTTP("http://self"), "GET", "/_admin/dashboard", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("dashboard should be disabled without admin token, got %d", w.Code)
+	}
+}
+
+func TestOverview(t *testing.T) {
+	group := NewGroup("overview", 1<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}), WithHotKeys(0))
+	for i := 0; i < 3; i++ {
+		group.Get("hot")
+	}
+	group.Get("cold")
+	g := NewGroupHTTP("http://self", WithAdmin("secret"))
+	g.Set("http://self", "http://peer")
+
+	if w := adminDo(g, "GET", "/_admin/overview", ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", w.Code)
+	}
+	w := adminDo(g, "GET", "/_admin/overview", "secret")
+	var o Overview
+	if err := json.Unmarshal(w.Body.Bytes(), &o); err != nil {
+		t.Fatal(err)
+	}
+	if o.Self != "http://self" || len(o.Ring) != 2 || len(o.Peers) != 2 {
+		t.Fatalf("unexpected overview %+v", o)
+	}
+	var found *GroupOverview
+	for i := range o.Groups {
+		if o.Groups[i].Config.Name == "overview" {
+			found = &o.Groups[i]
+		}
+	}
+	if found == nil {
+		t.Fatalf("expected overview group in %+v", o.Groups)
+	}
+	if found.Stats.Gets != 4 || len(found.HotKeys) != 2 || found.HotKeys[0] != (HotKey{"hot", 3}) {
+		t.Fatalf("unexpected group overview %+v", found)
+	}
+
+	//没有开启时不统计
+	off := NewGroup("overview-off", 1<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	off.Get("hot")
+	if keys := off.HotKeys(10); keys == nil || len(keys) != 0 {
+		t.Fatalf("expected no hot keys without WithHotKeys, got %v", keys)
+	}
+}
@@ -0,0 +1,453 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/**
+ * @Description: 节点发现,GroupHTTP订阅节点集合的变化并更新一致性hash,见 WithDiscovery
+ * 提供了静态列表,本地文件,DNS SRV/A记录和HTTP注册中心四种实现,
+ * 所有实现的变化都经过去抖,quiet时间内没有新的变化才会重建一致性hash
+ */
+
+const (
+	defaultDiscoveryInterval = 10 * time.Second
+	defaultDiscoveryDebounce = time.Second
+	defaultRegistryTTL       = 30 * time.Second
+)
+
+/**
+ * @Description: 节点发现
+ */
+type Discovery interface {
+	//Watch 阻塞到ctx结束,先用当前的节点调用一次update,之后每次获取到节点时调用update
+	//获取失败时不调用update,订阅方保留之前的节点
+	Watch(ctx context.Context, update func(peers []string)) error
+}
+
+/**
+ * @Description: 订阅节点发现,变化经过debounce去抖后调用Set,第一次获取到的节点立即生效
+ * 节点集合为空时忽略,自己总是在一致性hash上
+ * @receiver g
+ * @param d
+ * @param debounce <=0时使用默认值
+ * @return stop 取消订阅
+ */
+func (g *GroupHTTP) Subscribe(d Discovery, debounce time.Duration) (stop func()) {
+	if debounce <= 0 {
+		debounce = defaultDiscoveryDebounce
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-g.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	updates := make(chan []string, 1)
+	go func() {
+		if err := d.Watch(ctx, func(peers []string) {
+			//只保留最新的一次
+			select {
+			case <-updates:
+			default:
+			}
+			updates <- peers
+		}); err != nil && ctx.Err() == nil {
+			g.logger().Error("peer discovery stopped", "node", g.addr, "err", err)
+		}
+	}()
+	go g.applyDiscovered(ctx, updates, debounce)
+	return cancel
+}
+
+func (g *GroupHTTP) applyDiscovered(ctx context.Context, updates <-chan []string, debounce time.Duration) {
+	var (
+		applied string //上次Set的节点
+		pending []string
+		timer   *time.Timer
+		fire    <-chan time.Time
+	)
+	apply := func(peers []string) {
+		peers = normalizePeers(append(peers, g.addr))
+		if key := strings.Join(peers, "\n"); key != applied {
+			applied = key
+			g.logger().Info("discovered peers changed", "node", g.addr, "peers", peers)
+			g.Set(peers...)
+		}
+	}
+	first := true
+	for {
+		select {
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		case peers := <-updates:
+			if len(peers) == 0 {
+				g.logger().Warn("discovery returned no peers, keep the current ring", "node", g.addr)
+				continue
+			}
+			if first {
+				first = false
+				apply(peers)
+				continue
+			}
+			pending = peers
+			if timer == nil {
+				timer = time.NewTimer(debounce)
+			} else {
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+				timer.Reset(debounce)
+			}
+			fire = timer.C
+		case <-fire:
+			fire = nil
+			apply(pending)
+		}
+	}
+}
+
+/**
+ * @Description: 排序并去重
+ * @param peers
+ * @return []string
+ */
+func normalizePeers(peers []string) []string {
+	sort.Strings(peers)
+	out := peers[:0]
+	for i, p := range peers {
+		if p != "" && (i == 0 || p != peers[i-1]) {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+/**
+ * @Description: 轮询获取节点,获取失败时记录日志并等待下一次轮询
+ * @param ctx
+ * @param interval
+ * @param name 用于日志
+ * @param fetch
+ * @param update
+ * @return error ctx结束时返回nil
+ */
+func pollPeers(ctx context.Context, interval time.Duration, name string, fetch func(ctx context.Context) ([]string, error), update func([]string)) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if peers, err := fetch(ctx); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			GetLogger().Warn("discover peers failed", "discovery", name, "err", err)
+		} else {
+			update(peers)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+/**
+ * @Description: 静态的节点列表
+ */
+type StaticDiscovery []string
+
+func (s StaticDiscovery) Watch(ctx context.Context, update func(peers []string)) error {
+	update(append([]string(nil), s...))
+	<-ctx.Done()
+	return nil
+}
+
+/**
+ * @Description: 从本地文件读取节点,每行一个节点地址,#开头的行为注释,每隔interval检查一次文件
+ */
+type FileDiscovery struct {
+	path     string
+	interval time.Duration
+}
+
+/**
+ * @Description: 新建本地文件的节点发现
+ * @param path
+ * @param interval <=0时使用默认值
+ * @return *FileDiscovery
+ */
+func NewFileDiscovery(path string, interval time.Duration) *FileDiscovery {
+	if interval <= 0 {
+		interval = defaultDiscoveryInterval
+	}
+	return &FileDiscovery{path: path, interval: interval}
+}
+
+func (f *FileDiscovery) Watch(ctx context.Context, update func(peers []string)) error {
+	return pollPeers(ctx, f.interval, "file:"+f.path, f.read, update)
+}
+
+func (f *FileDiscovery) read(ctx context.Context) ([]string, error) {
+	b, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return nil, err
+	}
+	var peers []string
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := validateNodeAddr(line); err != nil {
+			return nil, fmt.Errorf("%s: %v", f.path, err)
+		}
+		peers = append(peers, line)
+	}
+	return peers, scanner.Err()
+}
+
+/**
+ * @Description: DNS查询,*net.Resolver实现了该接口,测试时可以替换
+ */
+type Resolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+/**
+ * @Description: 轮询DNS记录发现节点
+ * Port为0时查询Name的SRV记录,使用记录中的端口,否则查询A/AAAA记录,使用Port
+ */
+type DNSDiscovery struct {
+	Name     string
+	Port     int
+	Scheme   string        //默认为http
+	Interval time.Duration //默认为10s
+	Resolver Resolver      //默认为net.DefaultResolver
+}
+
+/**
+ * @Description: 新建DNS节点发现
+ * @param name SRV记录如 _cache._tcp.example.com,A记录如 cache.example.com
+ * @param port 0表示使用SRV记录
+ * @return *DNSDiscovery
+ */
+func NewDNSDiscovery(name string, port int) *DNSDiscovery {
+	return &DNSDiscovery{Name: name, Port: port, Scheme: "http", Interval: defaultDiscoveryInterval, Resolver: net.DefaultResolver}
+}
+
+func (d *DNSDiscovery) Watch(ctx context.Context, update func(peers []string)) error {
+	return pollPeers(ctx, d.Interval, "dns:"+d.Name, d.lookup, update)
+}
+
+func (d *DNSDiscovery) lookup(ctx context.Context) ([]string, error) {
+	var peers []string
+	if d.Port == 0 {
+		_, srvs, err := d.Resolver.LookupSRV(ctx, "", "", d.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, srv := range srvs {
+			host := strings.TrimSuffix(srv.Target, ".")
+			peers = append(peers, d.Scheme+"://"+net.JoinHostPort(host, strconv.Itoa(int(srv.Port))))
+		}
+		return peers, nil
+	}
+	hosts, err := d.Resolver.LookupHost(ctx, d.Name)
+	if err != nil {
+		return nil, err
+	}
+	for _, host := range hosts {
+		peers = append(peers, d.Scheme+"://"+net.JoinHostPort(host, strconv.Itoa(d.Port)))
+	}
+	return peers, nil
+}
+
+/**
+ * @Description: 从HTTP注册中心获取节点,GET url返回 {"peers": [...]},支持ETag
+ * self不为空时每次轮询前先 POST url?node=self 注册自己,停止时注销,见 Registry
+ */
+type HTTPDiscovery struct {
+	url      string
+	self     string
+	interval time.Duration
+	client   *http.Client
+
+	etag  string
+	peers []string
+}
+
+/**
+ * @Description: 新建HTTP注册中心的节点发现
+ * @param registry 注册中心的地址
+ * @param self 注册的本节点地址,为空时不注册
+ * @param interval 轮询和心跳间隔,需要小于注册中心的TTL,<=0时使用默认值
+ * @return *HTTPDiscovery
+ */
+func NewHTTPDiscovery(registry, self string, interval time.Duration) *HTTPDiscovery {
+	if interval <= 0 {
+		interval = defaultDiscoveryInterval
+	}
+	return &HTTPDiscovery{url: registry, self: self, interval: interval, client: &http.Client{Timeout: interval}}
+}
+
+func (h *HTTPDiscovery) Watch(ctx context.Context, update func(peers []string)) error {
+	err := pollPeers(ctx, h.interval, "http:"+h.url, h.fetch, update)
+	if h.self != "" {
+		//停止时注销,不用等待注册中心的TTL
+		req, _ := http.NewRequest(http.MethodDelete, h.url+"?node="+url.QueryEscape(h.self), nil)
+		if res, err := h.client.Do(req); err == nil {
+			res.Body.Close()
+		}
+	}
+	return err
+}
+
+func (h *HTTPDiscovery) fetch(ctx context.Context) ([]string, error) {
+	if h.self != "" {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url+"?node="+url.QueryEscape(h.self), nil)
+		if err != nil {
+			return nil, err
+		}
+		res, err := h.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusNoContent {
+			return nil, fmt.Errorf("register returned:%v", res.Status)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if h.etag != "" {
+		req.Header.Set("If-None-Match", h.etag)
+	}
+	res, err := h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusNotModified:
+		return h.peers, nil
+	case http.StatusOK:
+	default:
+		return nil, fmt.Errorf("registry returned:%v", res.Status)
+	}
+	var body struct {
+		Peers []string `json:"peers"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("decoding registry response: %v", err)
+	}
+	h.etag, h.peers = res.Header.Get("ETag"), body.Peers
+	return body.Peers, nil
+}
+
+/**
+ * @Description: 简单的HTTP注册中心,节点定期 POST ?node=<addr> 续约,超过ttl没有续约的节点被移除
+ * GET 返回 {"peers": [...]},节点集合不变时ETag不变
+ * DELETE ?node=<addr> 注销
+ */
+type Registry struct {
+	ttl   time.Duration
+	mu    sync.Mutex
+	nodes map[string]time.Time //节点的过期时间
+}
+
+/**
+ * @Description: 新建注册中心
+ * @param ttl <=0时使用默认值
+ * @return *Registry
+ */
+func NewRegistry(ttl time.Duration) *Registry {
+	if ttl <= 0 {
+		ttl = defaultRegistryTTL
+	}
+	return &Registry{ttl: ttl, nodes: make(map[string]time.Time)}
+}
+
+/**
+ * @Description: 没有过期的节点,按地址排序
+ * @receiver r
+ * @return []string
+ */
+func (r *Registry) Peers() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := timeNow()
+	peers := []string{}
+	for node, expire := range r.nodes {
+		if now.After(expire) {
+			delete(r.nodes, node)
+			continue
+		}
+		peers = append(peers, node)
+	}
+	sort.Strings(peers)
+	return peers
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		peers := r.Peers()
+		sum := fnv.New64a()
+		sum.Write([]byte(strings.Join(peers, "\n")))
+		etag := fmt.Sprintf(`"%x"`, sum.Sum64())
+		w.Header().Set("ETag", etag)
+		if req.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		writeJSON(w, http.StatusOK, struct {
+			Peers []string `json:"peers"`
+		}{peers})
+	case http.MethodPost, http.MethodDelete:
+		node := req.URL.Query().Get("node")
+		if err := validateNodeAddr(node); err != nil {
+			http.Error(w, "node "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.mu.Lock()
+		if req.Method == http.MethodPost {
+			r.nodes[node] = timeNow().Add(r.ttl)
+		} else {
+			delete(r.nodes, node)
+		}
+		r.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
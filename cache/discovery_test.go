@@ -0,0 +1,222 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 由测试驱动的节点发现
+type chanDiscovery chan []string
+
+func (c chanDiscovery) Watch(ctx context.Context, update func(peers []string)) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case peers := <-c:
+			update(peers)
+		}
+	}
+}
+
+func ringAddrs(g *GroupHTTP) []string {
+	var addrs []string
+	for _, n := range g.Ring() {
+		addrs = append(addrs, n.Addr)
+	}
+	return addrs
+}
+
+func waitRingAddrs(t *testing.T, g *GroupHTTP, want ...string) {
+	deadline := time.Now().Add(3 * time.Second)
+	for !reflect.DeepEqual(ringAddrs(g), want) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected ring %v, got %v", want, ringAddrs(g))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestDiscoveryDebounce(t *testing.T) {
+	log := &recordLogger{}
+	d := make(chanDiscovery)
+	g := NewGroupHTTP("http://a", WithHTTPLogger(log), WithDiscovery(d, 50*time.Millisecond))
+	defer g.Close()
+
+	//第一次立即生效,自己总是在一致性hash上
+	d <- []string{"http://b"}
+	waitRingAddrs(t, g, "http://a", "http://b")
+
+	//连续的变化只重建一次,空集合被忽略
+	d <- []string{"http://b", "http://c"}
+	d <- []string{"http://c", "http://d", "http://c"}
+	d <- []string{}
+	d <- []string{"http://d", "http://c"}
+	waitRingAddrs(t, g, "http://a", "http://c", "http://d")
+	time.Sleep(100 * time.Millisecond)
+
+	changes := 0
+	log.mu.Lock()
+	for _, e := range log.entries {
+		if strings.Contains(e, "discovered peers changed") {
+			changes++
+		}
+	}
+	log.mu.Unlock()
+	if changes != 2 {
+		t.Fatalf("expected 2 ring rebuilds, got %d", changes)
+	}
+}
+
+func TestFileDiscovery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers")
+	write := func(content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("# cache nodes\nhttp://a\n\nhttp://b\n")
+	g := NewGroupHTTP("http://a", WithDiscovery(NewFileDiscovery(path, 10*time.Millisecond), 10*time.Millisecond))
+	defer g.Close()
+	waitRingAddrs(t, g, "http://a", "http://b")
+
+	write("http://a\nhttp://b\nhttp://c\n")
+	waitRingAddrs(t, g, "http://a", "http://b", "http://c")
+
+	//文件错误时保留之前的节点
+	write("http://a\nnot a url\n")
+	time.Sleep(50 * time.Millisecond)
+	waitRingAddrs(t, g, "http://a", "http://b", "http://c")
+	os.Remove(path)
+	time.Sleep(50 * time.Millisecond)
+	waitRingAddrs(t, g, "http://a", "http://b", "http://c")
+}
+
+type stubResolver struct {
+	mu    sync.Mutex
+	srvs  []*net.SRV
+	hosts []string
+	err   error
+}
+
+func (r *stubResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return name, r.srvs, r.err
+}
+
+func (r *stubResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.hosts, r.err
+}
+
+func TestDNSDiscovery(t *testing.T) {
+	resolver := &stubResolver{
+		srvs:  []*net.SRV{{Target: "cache-0.example.com.", Port: 8001}, {Target: "cache-1.example.com.", Port: 8002}},
+		hosts: []string{"10.0.0.1", "fd00::1"},
+	}
+	a := NewDNSDiscovery("cache.example.com", 9000)
+	a.Resolver = resolver
+	peers, err := a.lookup(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"http://10.0.0.1:9000", "http://[fd00::1]:9000"}; !reflect.DeepEqual(peers, want) {
+		t.Fatalf("expected %v, got %v", want, peers)
+	}
+
+	srv := NewDNSDiscovery("_cache._tcp.example.com", 0)
+	srv.Resolver = resolver
+	srv.Interval = 10 * time.Millisecond
+	g := NewGroupHTTP("http://cache-0.example.com:8001", WithDiscovery(srv, 10*time.Millisecond))
+	defer g.Close()
+	waitRingAddrs(t, g, "http://cache-0.example.com:8001", "http://cache-1.example.com:8002")
+
+	//查询失败时保留之前的节点
+	resolver.mu.Lock()
+	resolver.err = errors.New("no such host")
+	resolver.mu.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	waitRingAddrs(t, g, "http://cache-0.example.com:8001", "http://cache-1.example.com:8002")
+
+	resolver.mu.Lock()
+	resolver.err = nil
+	resolver.srvs = resolver.srvs[:1]
+	resolver.mu.Unlock()
+	waitRingAddrs(t, g, "http://cache-0.example.com:8001")
+}
+
+func TestRegistry(t *testing.T) {
+	advance := fakeClock(t)
+	registry := NewRegistry(time.Minute)
+	server := httptest.NewServer(registry)
+	defer server.Close()
+
+	a := NewHTTPDiscovery(server.URL, "http://a", time.Second)
+	b := NewHTTPDiscovery(server.URL, "http://b", time.Second)
+	if _, err := a.fetch(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	peers, err := b.fetch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"http://a", "http://b"}; !reflect.DeepEqual(peers, want) {
+		t.Fatalf("expected %v, got %v", want, peers)
+	}
+
+	//节点集合不变时返回304
+	res, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	req.Header.Set("If-None-Match", res.Header.Get("ETag"))
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotModified {
+		t.Fatalf("expected 304, got %v", res.Status)
+	}
+
+	//a没有续约,过期后被移除
+	advance(40 * time.Second)
+	b.fetch(context.Background())
+	advance(30 * time.Second)
+	if peers, _ := b.fetch(context.Background()); !reflect.DeepEqual(peers, []string{"http://b"}) {
+		t.Fatalf("expected a to expire, got %v", peers)
+	}
+
+	req, _ = http.NewRequest(http.MethodDelete, server.URL+"?node=http://b", nil)
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if peers := registry.Peers(); len(peers) != 0 {
+		t.Fatalf("expected b to deregister, got %v", peers)
+	}
+	res, err = http.Post(server.URL+"?node=bad", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an invalid node, got %v", res.Status)
+	}
+}
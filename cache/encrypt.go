@@ -0,0 +1,230 @@
+package cache
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+/**
+ * @Description: 缓存值的加密,使用AES-GCM,通过密钥ID支持密钥轮换
+ * 密文格式: [密钥ID长度(1字节)][密钥ID][nonce][密文],附加数据为缓存的key,防止把密文换到其他key下
+ */
+
+var errBadCiphertext = errors.New("malformed ciphertext")
+
+/**
+ * @Description: 密钥环,新值使用当前密钥加密,旧值按密文中的密钥ID解密
+ */
+type Keyring struct {
+	mu      sync.RWMutex
+	current string
+	aeads   map[string]cipher.AEAD
+}
+
+/**
+ * @Description: 新建一个密钥环
+ * @param current 当前用于加密的密钥ID
+ * @param keys 密钥ID => 密钥,密钥长度为16,24或32字节
+ * @return *Keyring
+ * @return error
+ */
+func NewKeyring(current string, keys map[string][]byte) (*Keyring, error) {
+	k := &Keyring{aeads: make(map[string]cipher.AEAD, len(keys))}
+	for id, key := range keys {
+		if err := k.add(id, key); err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := k.aeads[current]; !ok {
+		return nil, fmt.Errorf("no key for current key id %q", current)
+	}
+	k.current = current
+	return k, nil
+}
+
+func (k *Keyring) add(id string, key []byte) error {
+	if id == "" || len(id) > 255 {
+		return fmt.Errorf("invalid key id %q", id)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return fmt.Errorf("key %s: %v", id, err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return fmt.Errorf("key %s: %v", id, err)
+	}
+	k.aeads[id] = aead
+	return nil
+}
+
+/**
+ * @Description: 轮换密钥,之后的新值使用该密钥加密,已有的值仍然可以用旧密钥解密
+ * id不能是已有的密钥ID,否则用原来的密钥加密的值将无法解密
+ * @receiver k
+ * @param id
+ * @param key
+ * @return error
+ */
+func (k *Keyring) Rotate(id string, key []byte) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if _, ok := k.aeads[id]; ok {
+		return fmt.Errorf("key id %q already exists", id)
+	}
+	if err := k.add(id, key); err != nil {
+		return err
+	}
+	k.current = id
+	return nil
+}
+
+/**
+ * @Description: 删除旧密钥,用该密钥加密的值无法再解密,读取时当作没有命中,不能删除当前密钥
+ * @receiver k
+ * @param id
+ * @return error
+ */
+func (k *Keyring) Remove(id string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if id == k.current {
+		return fmt.Errorf("cannot remove current key %q", id)
+	}
+	delete(k.aeads, id)
+	return nil
+}
+
+/**
+ * @Description: 返回当前用于加密的密钥ID
+ * @receiver k
+ * @return string
+ */
+func (k *Keyring) Current() string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.current
+}
+
+/**
+ * @Description: 使用当前密钥加密
+ * @receiver k
+ * @param plaintext
+ * @param ad 附加数据
+ * @return []byte
+ * @return error
+ */
+func (k *Keyring) seal(plaintext, ad []byte) ([]byte, error) {
+	k.mu.RLock()
+	id, aead := k.current, k.aeads[k.current]
+	k.mu.RUnlock()
+
+	head := 1 + len(id)
+	out := make([]byte, head+aead.NonceSize(), head+aead.NonceSize()+len(plaintext)+aead.Overhead())
+	out[0] = byte(len(id))
+	copy(out[1:], id)
+	nonce := out[head:]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(out, nonce, plaintext, ad), nil
+}
+
+/**
+ * @Description: 按密文中的密钥ID解密
+ * @receiver k
+ * @param ciphertext
+ * @param ad 附加数据
+ * @return []byte
+ * @return error
+ */
+func (k *Keyring) open(ciphertext, ad []byte) ([]byte, error) {
+	if len(ciphertext) < 1 || len(ciphertext) < 1+int(ciphertext[0]) {
+		return nil, errBadCiphertext
+	}
+	head := 1 + int(ciphertext[0])
+	id := string(ciphertext[1:head])
+	k.mu.RLock()
+	aead, ok := k.aeads[id]
+	k.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", id)
+	}
+	if len(ciphertext) < head+aead.NonceSize() {
+		return nil, errBadCiphertext
+	}
+	nonce := ciphertext[head : head+aead.NonceSize()]
+	return aead.Open(nil, nonce, ciphertext[head+aead.NonceSize():], ad)
+}
+
+/**
+ * @Description: 保存到缓存前,按配置先压缩再加密
+ * @receiver g
+ * @param key 缓存的key,作为加密的附加数据
+ * @param v
+ * @return ByteView
+ * @return error
+ */
+func (g *Group) pack(key string, v ByteView) (ByteView, error) {
+	if g.compressInMemory {
+		v.value, v.encoding = g.compress(v.value)
+	}
+	if g.keyring != nil {
+		sealed, err := g.keyring.seal(v.value, []byte(key))
+		if err != nil {
+			return ByteView{}, err
+		}
+		v.value, v.sealed = sealed, true
+	}
+	return v, nil
+}
+
+/**
+ * @Description: 解密并解压缓存中的值
+ * @receiver g
+ * @param key
+ * @param v
+ * @return ByteView
+ * @return error
+ */
+func (g *Group) unpack(key string, v ByteView) (ByteView, error) {
+	if v.sealed {
+		if g.keyring == nil {
+			return ByteView{}, errors.New("no keyring to decrypt value")
+		}
+		b, err := g.keyring.open(v.value, []byte(key))
+		if err != nil {
+			return ByteView{}, err
+		}
+		v.value, v.sealed = b, false
+	}
+	if v.encoding != "" {
+		b, err := decodeValue(v.encoding, v.value, maxDecompressedBytes)
+		if err != nil {
+			return ByteView{}, err
+		}
+		v.value, v.encoding = b, ""
+	}
+	return v, nil
+}
+
+/**
+ * @Description: 压缩加密后保存到c中,失败时不缓存
+ * @receiver g
+ * @param c
+ * @param key
+ * @param v
+ */
+func (g *Group) store(c *cache, key string, v ByteView) {
+	packed, err := g.pack(key, v)
+	if err != nil {
+		g.logger().Warn("failed to pack value, skip caching", "group", g.name, "key", key, "err", err)
+		return
+	}
+	c.add(key, packed)
+}
@@ -0,0 +1,122 @@
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+var (
+	testKey1 = bytes.Repeat([]byte{1}, 32)
+	testKey2 = bytes.Repeat([]byte{2}, 32)
+)
+
+func TestKeyring(t *testing.T) {
+	if _, err := NewKeyring("k1", map[string][]byte{"k1": []byte("short")}); err == nil {
+		t.Fatal("expected invalid key length to fail")
+	}
+	if _, err := NewKeyring("k2", map[string][]byte{"k1": testKey1}); err == nil {
+		t.Fatal("expected missing current key to fail")
+	}
+	k, err := NewKeyring("k1", map[string][]byte{"k1": testKey1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, _ := k.seal([]byte("secret"), []byte("key"))
+	if bytes.Contains(old, []byte("secret")) {
+		t.Fatal("ciphertext should not contain plaintext")
+	}
+
+	//轮换后旧密文仍然可以解密
+	if err := k.Rotate("k2", testKey2); err != nil || k.Current() != "k2" {
+		t.Fatalf("rotate failed: %v", err)
+	}
+	if b, err := k.open(old, []byte("key")); err != nil || string(b) != "secret" {
+		t.Fatalf("expected old value to decrypt, got %q %v", b, err)
+	}
+	//已有的密钥ID不能被替换
+	if err := k.Rotate("k1", testKey2); err == nil || k.Current() != "k2" {
+		t.Fatalf("expected rotating to an existing id to fail, got %v", err)
+	}
+	if b, err := k.open(old, []byte("key")); err != nil || string(b) != "secret" {
+		t.Fatalf("expected old value to still decrypt, got %q %v", b, err)
+	}
+	//附加数据不匹配
+	if _, err := k.open(old, []byte("other")); err == nil {
+		t.Fatal("expected ciphertext moved to another key to fail")
+	}
+	//篡改
+	tampered := append([]byte(nil), old...)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := k.open(tampered, []byte("key")); err == nil {
+		t.Fatal("expected tampered ciphertext to fail")
+	}
+	if err := k.Remove("k2"); err == nil {
+		t.Fatal("expected removing current key to fail")
+	}
+	k.Remove("k1")
+	if _, err := k.open(old, []byte("key")); err == nil {
+		t.Fatal("expected removed key to fail")
+	}
+	if _, err := k.open([]byte{9, 'x'}, nil); err != errBadCiphertext {
+		t.Fatalf("expected malformed ciphertext, got %v", err)
+	}
+}
+
+func TestEncryptedGroup(t *testing.T) {
+	k, _ := NewKeyring("k1", map[string][]byte{"k1": testKey1})
+	var loads int32
+	g := NewGroup("encrypt-pii", 1<<20, countingGetter(&loads), WithEncryption(k), WithCompression(NewGzipCodec(gzip.BestSpeed), 0, true))
+
+	for i := 0; i < 2; i++ {
+		if v, err := g.Get("alice"); err != nil || v.String() != "alice1" {
+			t.Fatalf("unexpected value %q err=%v", v, err)
+		}
+	}
+	if atomic.LoadInt32(&loads) != 1 {
+		t.Fatalf("expected second Get to hit the cache, got %d loads", loads)
+	}
+	if v, _ := g.cache.get("alice"); !v.sealed || bytes.Contains(v.value, []byte("alice")) {
+		t.Fatal("value should be encrypted in memory")
+	}
+
+	//轮换并删除旧密钥后,旧值当作没有命中,重新加载
+	k.Rotate("k2", testKey2)
+	k.Remove("k1")
+	if v, err := g.Get("alice"); err != nil || v.String() != "alice2" {
+		t.Fatalf("expected value encrypted with removed key to be reloaded, got %q err=%v", v, err)
+	}
+}
+
+func benchmarkGet(b *testing.B, name string, opts ...GroupOption) {
+	value := bytes.Repeat([]byte(`{"name":"Tom","score":630}`), 40)
+	g := NewGroup(name, 64<<20, GetterFunc(func(key string) ([]byte, error) {
+		return value, nil
+	}), opts...)
+	keys := make([]string, 1000)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+		g.Get(keys[i])
+	}
+	b.SetBytes(int64(len(value)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		g.Get(keys[i%len(keys)])
+	}
+}
+
+func BenchmarkGetPlain(b *testing.B) {
+	benchmarkGet(b, "bench-plain")
+}
+
+func BenchmarkGetEncrypted(b *testing.B) {
+	k, _ := NewKeyring("k1", map[string][]byte{"k1": testKey1})
+	benchmarkGet(b, "bench-encrypted", WithEncryption(k))
+}
+
+func BenchmarkGetCompressedEncrypted(b *testing.B) {
+	k, _ := NewKeyring("k1", map[string][]byte{"k1": testKey1})
+	benchmarkGet(b, "bench-compressed-encrypted", WithEncryption(k), WithCompression(NewGzipCodec(gzip.BestSpeed), 0, true))
+}
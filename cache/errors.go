@@ -0,0 +1,62 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+/**
+ * @Description: Group的错误类型,以及到http状态码的转换
+ */
+
+/**
+ * @Description: key不存在,Getter应该返回该错误(或者包装了该错误的错误),
+ * 节点之间以及API都会返回404,其他节点不会再尝试本地加载
+ */
+var ErrNotFound = errors.New("not found")
+
+const errorHeader = "X-Cache-Error" //节点服务返回404时说明原因,区分key不存在和group不存在
+
+/**
+ * @Description: 从owner节点获取失败,本地加载也失败
+ */
+type fallbackError struct {
+	peer  error
+	local error
+}
+
+func (e *fallbackError) Error() string {
+	return fmt.Sprintf("%v (peer: %v)", e.local, e.peer)
+}
+
+func (e *fallbackError) Unwrap() error {
+	return e.local
+}
+
+/**
+ * @Description: 错误对应的http状态码,key不存在为404,版本冲突为412,不是owner为409,owner不可用为503,节点失败为502,加载失败为500,
+ * 节点失败后本地加载也失败时使用本地加载的错误的状态码
+ * @param err
+ * @return int
+ */
+func statusOf(err error) int {
+	var fe *fallbackError
+	var pe *peerError
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrVersionConflict):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, ErrNotOwner):
+		return http.StatusConflict
+	case errors.Is(err, ErrOwnerUnavailable):
+		return http.StatusServiceUnavailable
+	case errors.As(err, &fe):
+		return statusOf(fe.local)
+	case errors.As(err, &pe):
+		return http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -1,8 +1,9 @@
 module cache
 
-go 1.13
+go 1.16
 
 require (
 	github.com/golang/protobuf v1.4.0
 	google.golang.org/protobuf v1.23.0
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,791 @@
+package gossip
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"math"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+/**
+ * @Description: SWIM风格的gossip成员协议,节点之间使用UDP通信
+ * 1. 每个ProbeInterval按轮询顺序ping一个节点,ProbeTimeout内没有ack时,请IndirectProbes个其他节点代为ping(ping-req)
+ * 2. 仍然没有ack时把节点标记为怀疑,并通过gossip传播,节点收到对自己的怀疑后增加Incarnation反驳
+ * 3. 怀疑超过SuspicionTimeout没有被反驳时标记为死亡
+ * 4. 状态的变化附带在ping和ack上传播,每个变化传播 RetransmitMult*ceil(log10(n+1)) 次
+ * 5. 每个SyncInterval和一个随机节点交换全部状态,加入集群时和种子节点交换全部状态
+ */
+
+const (
+	defaultProbeInterval    = time.Second
+	defaultProbeTimeout     = 300 * time.Millisecond
+	defaultIndirectProbes   = 3
+	defaultSuspicionTimeout = 5 * time.Second
+	defaultSyncInterval     = 30 * time.Second
+	defaultRetransmitMult   = 4
+	defaultDeadReap         = time.Minute
+	maxPiggyback            = 16
+	maxPacketSize           = 64 << 10
+)
+
+var (
+	ErrClosed       = errors.New("gossip: memberlist closed")
+	ErrJoinFailed   = errors.New("gossip: no seed responded")
+	errBadSignature = errors.New("gossip: bad packet signature")
+)
+
+/**
+ * @Description: 配置,零值字段使用默认值
+ */
+type Config struct {
+	Name             string        //本节点的名字,集群中唯一
+	BindAddr         string        //UDP监听地址,如 127.0.0.1:0
+	AdvertiseAddr    string        //其他节点访问本节点的地址,为空时使用监听的地址
+	ProbeInterval    time.Duration //探测间隔
+	ProbeTimeout     time.Duration //等待ack的时间,需要小于ProbeInterval
+	IndirectProbes   int           //直接探测失败后请多少个节点代为探测
+	SuspicionTimeout time.Duration //怀疑多久后认为死亡
+	SyncInterval     time.Duration //和随机节点交换全部状态的间隔,<0表示关闭
+	RetransmitMult   int           //每个状态变化的传播次数系数
+	DeadReap         time.Duration //死亡和离开的节点保留多久
+	Key              []byte        //不为空时用HMAC-SHA256签名所有报文,集群中需要一致
+
+	//活跃节点(alive和suspect)的集合改变时调用,按名字排序,调用是串行的
+	OnChange func(members []Member)
+	//日志,为nil时不输出
+	Log func(msg string, kv ...interface{})
+}
+
+type msgType string
+
+const (
+	msgPing    msgType = "ping"
+	msgAck     msgType = "ack"
+	msgPingReq msgType = "ping-req"
+	msgSync    msgType = "sync"     //发送方的全部状态,接收方合并后回复syncAck
+	msgSyncAck msgType = "sync-ack" //接收方的全部状态
+)
+
+type message struct {
+	Type       msgType  `json:"type"`
+	Seq        uint64   `json:"seq,omitempty"`
+	From       string   `json:"from"`
+	To         string   `json:"to,omitempty"`          //ping的目标节点名,节点名不一致时不回复
+	TargetAddr string   `json:"target_addr,omitempty"` //ping-req的目标地址
+	Updates    []Member `json:"updates,omitempty"`     //附带的状态变化,或者sync的全部状态
+}
+
+type broadcast struct {
+	member    Member
+	transmits int //已经传播的次数
+}
+
+/**
+ * @Description: 成员列表,保存所有节点的状态,并运行探测和gossip
+ */
+type Memberlist struct {
+	cfg  Config
+	conn net.PacketConn
+	addr string //本节点的gossip地址
+
+	mu         sync.Mutex
+	self       Member
+	members    map[string]*memberState //不包括自己
+	broadcasts []*broadcast
+	probeOrder []string //本轮探测的顺序
+	leaving    bool
+	seq        uint64
+	acks       map[uint64]chan struct{}
+
+	changed chan struct{} //活跃节点集合改变,由notifyLoop调用OnChange
+	active  string        //上次通知时的活跃节点,用于判断是否改变
+
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+/**
+ * @Description: 创建成员列表并开始监听,此时集群中只有自己,见 Join
+ * @param cfg
+ * @return *Memberlist
+ * @return error
+ */
+func Create(cfg Config) (*Memberlist, error) {
+	if cfg.Name == "" {
+		return nil, errors.New("gossip: name is required")
+	}
+	if cfg.ProbeInterval <= 0 {
+		cfg.ProbeInterval = defaultProbeInterval
+	}
+	if cfg.ProbeTimeout <= 0 {
+		cfg.ProbeTimeout = defaultProbeTimeout
+	}
+	if cfg.IndirectProbes <= 0 {
+		cfg.IndirectProbes = defaultIndirectProbes
+	}
+	if cfg.SuspicionTimeout <= 0 {
+		cfg.SuspicionTimeout = defaultSuspicionTimeout
+	}
+	if cfg.SyncInterval == 0 {
+		cfg.SyncInterval = defaultSyncInterval
+	}
+	if cfg.RetransmitMult <= 0 {
+		cfg.RetransmitMult = defaultRetransmitMult
+	}
+	if cfg.DeadReap <= 0 {
+		cfg.DeadReap = defaultDeadReap
+	}
+	conn, err := net.ListenPacket("udp", cfg.BindAddr)
+	if err != nil {
+		return nil, err
+	}
+	m := &Memberlist{
+		cfg:     cfg,
+		conn:    conn,
+		addr:    cfg.AdvertiseAddr,
+		members: make(map[string]*memberState),
+		acks:    make(map[uint64]chan struct{}),
+		changed: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	if m.addr == "" {
+		m.addr = conn.LocalAddr().String()
+	}
+	m.self = Member{Name: cfg.Name, Addr: m.addr, State: StateAlive}
+	m.active = cfg.Name
+
+	m.wg.Add(3)
+	go m.readLoop()
+	go m.probeLoop()
+	go m.notifyLoop()
+	if cfg.SyncInterval > 0 {
+		m.wg.Add(1)
+		go m.syncLoop()
+	}
+	return m, nil
+}
+
+/**
+ * @Description: 本节点的gossip地址
+ * @receiver m
+ * @return string
+ */
+func (m *Memberlist) Addr() string {
+	return m.addr
+}
+
+/**
+ * @Description: 和种子节点交换全部状态,加入集群
+ * @receiver m
+ * @param seeds 种子节点的gossip地址
+ * @param timeout 等待回复的时间
+ * @return int 回复的种子节点数
+ * @return error 没有种子节点回复时返回ErrJoinFailed,已经Shutdown时返回ErrClosed
+ */
+func (m *Memberlist) Join(seeds []string, timeout time.Duration) (int, error) {
+	if m.isClosed() {
+		return 0, ErrClosed
+	}
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		replied int
+	)
+	for _, seed := range seeds {
+		if seed == m.addr {
+			continue
+		}
+		wg.Add(1)
+		go func(seed string) {
+			defer wg.Done()
+			if m.exchange(seed, timeout) {
+				mu.Lock()
+				replied++
+				mu.Unlock()
+			}
+		}(seed)
+	}
+	wg.Wait()
+	if replied == 0 && len(seeds) > 0 {
+		return 0, ErrJoinFailed
+	}
+	return replied, nil
+}
+
+/**
+ * @Description: 活跃的节点,包括自己,按名字排序
+ * @receiver m
+ * @return []Member
+ */
+func (m *Memberlist) Members() []Member {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.activeMembers()
+}
+
+func (m *Memberlist) activeMembers() []Member {
+	members := []Member{m.self}
+	for _, ms := range m.members {
+		if ms.Active() {
+			members = append(members, ms.Member)
+		}
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Name < members[j].Name })
+	return members
+}
+
+/**
+ * @Description: 所有已知节点的状态,包括已经死亡和离开的,按名字排序
+ * @receiver m
+ * @return []Member
+ */
+func (m *Memberlist) All() []Member {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	all := m.snapshot()
+	sort.Slice(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+	return all
+}
+
+func (m *Memberlist) snapshot() []Member {
+	all := make([]Member, 0, len(m.members)+1)
+	all = append(all, m.self)
+	for _, ms := range m.members {
+		all = append(all, ms.Member)
+	}
+	return all
+}
+
+/**
+ * @Description: 广播本节点离开,等待广播传播出去或者timeout后返回,之后需要调用Shutdown
+ * @receiver m
+ * @param timeout
+ * @return error
+ */
+func (m *Memberlist) Leave(timeout time.Duration) error {
+	m.mu.Lock()
+	if m.isClosed() {
+		m.mu.Unlock()
+		return ErrClosed
+	}
+	m.leaving = true
+	m.self.State = StateLeft
+	m.queue(m.self)
+	peers := m.peersLocked()
+	m.mu.Unlock()
+
+	//直接通知所有节点,不依赖探测的顺序
+	for _, p := range peers {
+		m.sendPing(p, 0)
+	}
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		m.mu.Lock()
+		pending := len(m.broadcasts)
+		m.mu.Unlock()
+		if pending == 0 {
+			return nil
+		}
+		time.Sleep(m.cfg.ProbeInterval / 4)
+	}
+	return nil
+}
+
+/**
+ * @Description: 停止监听和后台任务,不通知其他节点
+ * @receiver m
+ * @return error
+ */
+func (m *Memberlist) Shutdown() error {
+	m.closeOnce.Do(func() {
+		close(m.done)
+		m.conn.Close()
+	})
+	m.wg.Wait()
+	return nil
+}
+
+func (m *Memberlist) isClosed() bool {
+	select {
+	case <-m.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (m *Memberlist) log(msg string, kv ...interface{}) {
+	if m.cfg.Log != nil {
+		m.cfg.Log(msg, append([]interface{}{"node", m.cfg.Name}, kv...)...)
+	}
+}
+
+/**
+ * @Description: 应用一个状态变化,改变时加入广播队列,调用方需要持有mu
+ * @receiver m
+ * @param u
+ * @return bool 是否改变
+ */
+func (m *Memberlist) apply(u Member) bool {
+	if u.Name == m.self.Name {
+		//其他节点认为自己怀疑或者死亡,或者见过更大的Incarnation,增加Incarnation反驳
+		if m.leaving || (u.State == StateAlive && u.Incarnation <= m.self.Incarnation) {
+			return false
+		}
+		if u.Incarnation >= m.self.Incarnation {
+			m.self.Incarnation = u.Incarnation + 1
+			m.queue(m.self)
+			m.log("refute", "state", u.State, "incarnation", m.self.Incarnation)
+		}
+		return false
+	}
+	cur, ok := m.members[u.Name]
+	if ok && !supersedes(cur.Member, u) {
+		return false
+	}
+	if !ok && !u.Active() {
+		//不认识的节点已经死亡或离开,记录下来避免旧的alive消息把它加回来
+		m.members[u.Name] = &memberState{Member: u, changed: time.Now()}
+		return true
+	}
+	if !ok {
+		cur = &memberState{}
+		m.members[u.Name] = cur
+	}
+	prev := cur.State
+	cur.Member, cur.changed = u, time.Now()
+	m.queue(u)
+	if prev != u.State || !ok {
+		m.log("member state", "member", u.Name, "addr", u.Addr, "state", u.State, "incarnation", u.Incarnation)
+	}
+	if u.State == StateSuspect {
+		m.startSuspicion(u)
+	}
+	m.checkChanged()
+	return true
+}
+
+/**
+ * @Description: 怀疑超时后仍未被反驳,标记为死亡
+ * @receiver m
+ * @param u
+ */
+func (m *Memberlist) startSuspicion(u Member) {
+	time.AfterFunc(m.cfg.SuspicionTimeout, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		cur, ok := m.members[u.Name]
+		if !ok || cur.State != StateSuspect || cur.Incarnation != u.Incarnation || m.isClosed() {
+			return
+		}
+		dead := cur.Member
+		dead.State = StateDead
+		m.apply(dead)
+	})
+}
+
+/**
+ * @Description: 活跃节点的集合改变时通知notifyLoop,调用方需要持有mu
+ * @receiver m
+ */
+func (m *Memberlist) checkChanged() {
+	var names []byte
+	for _, member := range m.activeMembers() {
+		names = append(names, member.Name...)
+		names = append(names, 0)
+	}
+	if string(names) == m.active {
+		return
+	}
+	m.active = string(names)
+	select {
+	case m.changed <- struct{}{}:
+	default:
+	}
+}
+
+func (m *Memberlist) notifyLoop() {
+	defer m.wg.Done()
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-m.changed:
+			if m.cfg.OnChange != nil {
+				m.cfg.OnChange(m.Members())
+			}
+		}
+	}
+}
+
+/**
+ * @Description: 加入广播队列,同一个节点只保留最新的状态,调用方需要持有mu
+ * @receiver m
+ * @param u
+ */
+func (m *Memberlist) queue(u Member) {
+	for i, b := range m.broadcasts {
+		if b.member.Name == u.Name {
+			m.broadcasts = append(m.broadcasts[:i], m.broadcasts[i+1:]...)
+			break
+		}
+	}
+	m.broadcasts = append(m.broadcasts, &broadcast{member: u})
+}
+
+/**
+ * @Description: 取出要附带的状态变化,传播次数达到上限的从队列中删除,调用方需要持有mu
+ * @receiver m
+ * @return []Member
+ */
+func (m *Memberlist) piggyback() []Member {
+	limit := m.cfg.RetransmitMult * int(math.Ceil(math.Log10(float64(len(m.members)+2))))
+	var updates []Member
+	kept := m.broadcasts[:0]
+	for _, b := range m.broadcasts {
+		if len(updates) < maxPiggyback {
+			updates = append(updates, b.member)
+			b.transmits++
+		}
+		if b.transmits < limit {
+			kept = append(kept, b)
+		}
+	}
+	m.broadcasts = kept
+	return updates
+}
+
+/**
+ * @Description: 除了自己以外的活跃节点,调用方需要持有mu
+ * @receiver m
+ * @return []Member
+ */
+func (m *Memberlist) peersLocked() []Member {
+	var peers []Member
+	for _, ms := range m.members {
+		if ms.Active() {
+			peers = append(peers, ms.Member)
+		}
+	}
+	return peers
+}
+
+/**
+ * @Description: 按轮询顺序选择下一个探测的节点,一轮结束后重新打乱顺序
+ * @receiver m
+ * @return Member
+ * @return bool
+ */
+func (m *Memberlist) nextProbe() (Member, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for attempts := 0; attempts < 2; attempts++ {
+		for len(m.probeOrder) > 0 {
+			name := m.probeOrder[0]
+			m.probeOrder = m.probeOrder[1:]
+			if ms, ok := m.members[name]; ok && ms.Active() {
+				return ms.Member, true
+			}
+		}
+		for name, ms := range m.members {
+			if ms.Active() {
+				m.probeOrder = append(m.probeOrder, name)
+			}
+		}
+		rand.Shuffle(len(m.probeOrder), func(i, j int) {
+			m.probeOrder[i], m.probeOrder[j] = m.probeOrder[j], m.probeOrder[i]
+		})
+	}
+	return Member{}, false
+}
+
+func (m *Memberlist) probeLoop() {
+	defer m.wg.Done()
+	ticker := time.NewTicker(m.cfg.ProbeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+			m.reap()
+			if target, ok := m.nextProbe(); ok {
+				m.probe(target)
+			}
+		}
+	}
+}
+
+/**
+ * @Description: 探测一个节点,直接探测和间接探测都失败时标记为怀疑
+ * @receiver m
+ * @param target
+ */
+func (m *Memberlist) probe(target Member) {
+	seq, ack := m.expectAck()
+	defer m.forgetAck(seq)
+	m.sendPing(target, seq)
+	select {
+	case <-ack:
+		return
+	case <-m.done:
+		return
+	case <-time.After(m.cfg.ProbeTimeout):
+	}
+
+	//请其他节点代为探测
+	m.mu.Lock()
+	var helpers []Member
+	for _, p := range m.peersLocked() {
+		if p.Name != target.Name && p.State == StateAlive {
+			helpers = append(helpers, p)
+		}
+	}
+	m.mu.Unlock()
+	rand.Shuffle(len(helpers), func(i, j int) { helpers[i], helpers[j] = helpers[j], helpers[i] })
+	if len(helpers) > m.cfg.IndirectProbes {
+		helpers = helpers[:m.cfg.IndirectProbes]
+	}
+	for _, h := range helpers {
+		m.send(h.Addr, &message{Type: msgPingReq, Seq: seq, To: target.Name, TargetAddr: target.Addr})
+	}
+	select {
+	case <-ack:
+		return
+	case <-m.done:
+		return
+	case <-time.After(m.cfg.ProbeInterval - m.cfg.ProbeTimeout):
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if cur, ok := m.members[target.Name]; ok && cur.State == StateAlive && cur.Incarnation == target.Incarnation {
+		m.log("probe failed, suspect member", "member", target.Name, "addr", target.Addr)
+		suspect := cur.Member
+		suspect.State = StateSuspect
+		m.apply(suspect)
+	}
+}
+
+/**
+ * @Description: 清理死亡和离开超过DeadReap的节点
+ * @receiver m
+ */
+func (m *Memberlist) reap() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for name, ms := range m.members {
+		if !ms.Active() && now.Sub(ms.changed) > m.cfg.DeadReap {
+			delete(m.members, name)
+		}
+	}
+}
+
+func (m *Memberlist) syncLoop() {
+	defer m.wg.Done()
+	ticker := time.NewTicker(m.cfg.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+			m.mu.Lock()
+			peers := m.peersLocked()
+			m.mu.Unlock()
+			if len(peers) > 0 {
+				m.exchange(peers[rand.Intn(len(peers))].Addr, m.cfg.ProbeTimeout)
+			}
+		}
+	}
+}
+
+/**
+ * @Description: 和addr交换全部状态
+ * @receiver m
+ * @param addr
+ * @param timeout
+ * @return bool 是否收到回复
+ */
+func (m *Memberlist) exchange(addr string, timeout time.Duration) bool {
+	seq, ack := m.expectAck()
+	defer m.forgetAck(seq)
+	m.mu.Lock()
+	state := m.snapshot()
+	m.mu.Unlock()
+	m.send(addr, &message{Type: msgSync, Seq: seq, Updates: state})
+	select {
+	case <-ack:
+		return true
+	case <-m.done:
+	case <-time.After(timeout):
+	}
+	return false
+}
+
+func (m *Memberlist) expectAck() (uint64, chan struct{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.seq++
+	ch := make(chan struct{}, 1)
+	m.acks[m.seq] = ch
+	return m.seq, ch
+}
+
+func (m *Memberlist) forgetAck(seq uint64) {
+	m.mu.Lock()
+	delete(m.acks, seq)
+	m.mu.Unlock()
+}
+
+func (m *Memberlist) gotAck(seq uint64) {
+	m.mu.Lock()
+	ch, ok := m.acks[seq]
+	m.mu.Unlock()
+	if ok {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+/**
+ * @Description: ping一个节点,目标处于怀疑状态时附带上对它的怀疑,让它尽快反驳
+ * @receiver m
+ * @param target
+ * @param seq
+ */
+func (m *Memberlist) sendPing(target Member, seq uint64) {
+	msg := &message{Type: msgPing, Seq: seq, To: target.Name}
+	if target.State == StateSuspect {
+		msg.Updates = []Member{target}
+	}
+	m.send(target.Addr, msg)
+}
+
+/**
+ * @Description: 发送报文,附带上广播队列中的状态变化
+ * @receiver m
+ * @param addr
+ * @param msg
+ */
+func (m *Memberlist) send(addr string, msg *message) {
+	msg.From = m.cfg.Name
+	if msg.Type != msgSync && msg.Type != msgSyncAck {
+		m.mu.Lock()
+		msg.Updates = append(msg.Updates, m.piggyback()...)
+		m.mu.Unlock()
+	}
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	if len(m.cfg.Key) > 0 {
+		b = append(sign(m.cfg.Key, b), b...)
+	}
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		m.log("resolve address failed", "addr", addr, "err", err)
+		return
+	}
+	if _, err := m.conn.WriteTo(b, udpAddr); err != nil && !m.isClosed() {
+		m.log("send failed", "addr", addr, "err", err)
+	}
+}
+
+func sign(key, b []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(b)
+	return mac.Sum(nil)
+}
+
+func (m *Memberlist) readLoop() {
+	defer m.wg.Done()
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, from, err := m.conn.ReadFrom(buf)
+		if err != nil {
+			if m.isClosed() {
+				return
+			}
+			continue
+		}
+		msg, err := m.decode(buf[:n])
+		if err != nil {
+			m.log("drop packet", "from", from.String(), "err", err)
+			continue
+		}
+		m.handle(msg, from)
+	}
+}
+
+func (m *Memberlist) decode(b []byte) (*message, error) {
+	if len(m.cfg.Key) > 0 {
+		if len(b) < sha256.Size || !hmac.Equal(b[:sha256.Size], sign(m.cfg.Key, b[sha256.Size:])) {
+			return nil, errBadSignature
+		}
+		b = b[sha256.Size:]
+	}
+	msg := &message{}
+	if err := json.Unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (m *Memberlist) handle(msg *message, from net.Addr) {
+	if msg.Type != msgSync && msg.Type != msgSyncAck {
+		m.mu.Lock()
+		for _, u := range msg.Updates {
+			m.apply(u)
+		}
+		m.mu.Unlock()
+	}
+
+	switch msg.Type {
+	case msgPing:
+		//发给之前在该地址上的其他节点,不回复
+		if msg.To != "" && msg.To != m.cfg.Name {
+			return
+		}
+		if msg.Seq != 0 {
+			m.send(from.String(), &message{Type: msgAck, Seq: msg.Seq})
+		}
+	case msgAck:
+		m.gotAck(msg.Seq)
+	case msgPingReq:
+		//代为探测,收到ack后转发给请求方
+		seq, ack := m.expectAck()
+		go func() {
+			defer m.forgetAck(seq)
+			m.sendPing(Member{Name: msg.To, Addr: msg.TargetAddr}, seq)
+			select {
+			case <-ack:
+				m.send(from.String(), &message{Type: msgAck, Seq: msg.Seq})
+			case <-m.done:
+			case <-time.After(m.cfg.ProbeTimeout):
+			}
+		}()
+	case msgSync, msgSyncAck:
+		m.mu.Lock()
+		for _, u := range msg.Updates {
+			m.apply(u)
+		}
+		state := m.snapshot()
+		m.mu.Unlock()
+		if msg.Type == msgSync {
+			m.send(from.String(), &message{Type: msgSyncAck, Seq: msg.Seq, Updates: state})
+		} else {
+			m.gotAck(msg.Seq)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package gossip
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSupersedes(t *testing.T) {
+	alive := func(inc uint64) Member { return Member{Name: "a", State: StateAlive, Incarnation: inc} }
+	suspect := func(inc uint64) Member { return Member{Name: "a", State: StateSuspect, Incarnation: inc} }
+	dead := func(inc uint64) Member { return Member{Name: "a", State: StateDead, Incarnation: inc} }
+	cases := []struct {
+		cur, u Member
+		want   bool
+	}{
+		{alive(1), alive(2), true},
+		{alive(1), alive(1), false},
+		{alive(1), suspect(1), true},
+		{alive(2), suspect(1), false},
+		{suspect(1), alive(1), false},
+		{suspect(1), alive(2), true},
+		{suspect(1), suspect(1), false},
+		{suspect(1), dead(1), true},
+		{dead(1), alive(1), false},
+		{dead(1), alive(2), true},
+		{dead(1), suspect(5), false},
+		{dead(1), dead(1), false},
+	}
+	for _, c := range cases {
+		if got := supersedes(c.cur, c.u); got != c.want {
+			t.Errorf("supersedes(%v %d, %v %d) = %v, want %v", c.cur.State, c.cur.Incarnation, c.u.State, c.u.Incarnation, got, c.want)
+		}
+	}
+}
+
+func testConfig(name string) Config {
+	return Config{
+		Name:             name,
+		BindAddr:         "127.0.0.1:0",
+		ProbeInterval:    20 * time.Millisecond,
+		ProbeTimeout:     10 * time.Millisecond,
+		SuspicionTimeout: 100 * time.Millisecond,
+		SyncInterval:     100 * time.Millisecond,
+	}
+}
+
+func startCluster(t *testing.T, n int, key []byte) []*Memberlist {
+	var nodes []*Memberlist
+	for i := 0; i < n; i++ {
+		cfg := testConfig(fmt.Sprint("node-", i))
+		cfg.Key = key
+		m, err := Create(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { m.Shutdown() })
+		if i > 0 {
+			if _, err := m.Join([]string{nodes[0].Addr()}, time.Second); err != nil {
+				t.Fatal(err)
+			}
+		}
+		nodes = append(nodes, m)
+	}
+	return nodes
+}
+
+func waitMembers(t *testing.T, nodes []*Memberlist, want int) {
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		ok := true
+		for _, m := range nodes {
+			if len(m.Members()) != want {
+				ok = false
+			}
+		}
+		if ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			for _, m := range nodes {
+				t.Logf("%s: %+v", m.cfg.Name, m.All())
+			}
+			t.Fatalf("timeout waiting for %d members", want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestJoin(t *testing.T) {
+	nodes := startCluster(t, 5, nil)
+	waitMembers(t, nodes, 5)
+
+	if _, err := nodes[0].Join([]string{"127.0.0.1:1"}, 50*time.Millisecond); err != ErrJoinFailed {
+		t.Fatalf("expected ErrJoinFailed, got %v", err)
+	}
+}
+
+func TestFailureDetection(t *testing.T) {
+	nodes := startCluster(t, 4, nil)
+	waitMembers(t, nodes, 4)
+
+	//不通知其他节点直接退出
+	nodes[3].Shutdown()
+	waitMembers(t, nodes[:3], 3)
+	for _, member := range nodes[0].All() {
+		if member.Name == "node-3" && member.State != StateDead {
+			t.Fatalf("expected node-3 dead, got %v", member.State)
+		}
+	}
+}
+
+func TestLeave(t *testing.T) {
+	nodes := startCluster(t, 3, nil)
+	waitMembers(t, nodes, 3)
+	start := time.Now()
+	nodes[2].Leave(time.Second)
+	nodes[2].Shutdown()
+	waitMembers(t, nodes[:2], 2)
+	//离开不需要等待怀疑超时
+	if time.Since(start) > time.Second {
+		t.Fatalf("leave took %v", time.Since(start))
+	}
+	for _, member := range nodes[0].All() {
+		if member.Name == "node-2" && member.State != StateLeft {
+			t.Fatalf("expected node-2 left, got %v", member.State)
+		}
+	}
+}
+
+func TestRejoinAfterDeath(t *testing.T) {
+	nodes := startCluster(t, 3, nil)
+	waitMembers(t, nodes, 3)
+	nodes[2].Shutdown()
+	waitMembers(t, nodes[:2], 2)
+
+	//同名节点重启,使用新的地址,需要用更大的Incarnation反驳死亡
+	m, err := Create(testConfig("node-2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Shutdown()
+	if _, err := m.Join([]string{nodes[0].Addr()}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	waitMembers(t, []*Memberlist{nodes[0], nodes[1], m}, 3)
+}
+
+func TestSuspicionRefuted(t *testing.T) {
+	nodes := startCluster(t, 3, nil)
+	waitMembers(t, nodes, 3)
+
+	//node-0错误地怀疑node-1,node-1反驳后仍然存活
+	nodes[0].mu.Lock()
+	cur := nodes[0].members["node-1"].Member
+	cur.State = StateSuspect
+	nodes[0].apply(cur)
+	nodes[0].mu.Unlock()
+
+	time.Sleep(3 * nodes[0].cfg.SuspicionTimeout)
+	waitMembers(t, nodes, 3)
+	for _, member := range nodes[2].All() {
+		if member.Name == "node-1" && (member.State != StateAlive || member.Incarnation == 0) {
+			t.Fatalf("expected node-1 to refute with a higher incarnation, got %+v", member)
+		}
+	}
+}
+
+func TestOnChange(t *testing.T) {
+	var mu sync.Mutex
+	var last []Member
+	cfg := testConfig("watched")
+	cfg.OnChange = func(members []Member) {
+		mu.Lock()
+		last = members
+		mu.Unlock()
+	}
+	m, err := Create(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Shutdown()
+	nodes := startCluster(t, 2, nil)
+	m.Join([]string{nodes[0].Addr()}, time.Second)
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		mu.Lock()
+		n := len(last)
+		mu.Unlock()
+		if n == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected OnChange with 3 members, got %+v", last)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestKey(t *testing.T) {
+	nodes := startCluster(t, 2, []byte("secret"))
+	waitMembers(t, nodes, 2)
+
+	//密钥不同的节点无法加入
+	cfg := testConfig("intruder")
+	cfg.Key = []byte("wrong")
+	m, err := Create(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Shutdown()
+	if _, err := m.Join([]string{nodes[0].Addr()}, 100*time.Millisecond); err != ErrJoinFailed {
+		t.Fatalf("expected join with a wrong key to fail, got %v", err)
+	}
+	if len(nodes[0].Members()) != 2 {
+		t.Fatalf("intruder should not be a member, got %+v", nodes[0].Members())
+	}
+}
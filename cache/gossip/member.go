@@ -0,0 +1,85 @@
+package gossip
+
+import (
+	"fmt"
+	"time"
+)
+
+/**
+ * @Description: 节点的状态
+ */
+type State int
+
+const (
+	StateAlive   State = iota //正常
+	StateSuspect              //探测失败,等待节点自己反驳,超时后认为已死亡
+	StateDead                 //确认死亡
+	StateLeft                 //主动离开
+)
+
+func (s State) String() string {
+	switch s {
+	case StateAlive:
+		return "alive"
+	case StateSuspect:
+		return "suspect"
+	case StateDead:
+		return "dead"
+	case StateLeft:
+		return "left"
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+/**
+ * @Description: 集群中的一个节点
+ * Incarnation只能由节点自己增加,用来反驳其他节点对它的怀疑,相同Incarnation下 left=dead > suspect > alive
+ */
+type Member struct {
+	Name        string `json:"name"` //节点名,一般为缓存节点的地址
+	Addr        string `json:"addr"` //gossip的UDP地址
+	State       State  `json:"state"`
+	Incarnation uint64 `json:"inc"`
+}
+
+/**
+ * @Description: 节点是否还在集群中,怀疑状态的节点仍然在集群中
+ * @receiver m
+ * @return bool
+ */
+func (m Member) Active() bool {
+	return m.State == StateAlive || m.State == StateSuspect
+}
+
+/**
+ * @Description: 本地保存的节点状态
+ */
+type memberState struct {
+	Member
+	changed time.Time //状态改变的时间,用于清理已死亡的节点
+}
+
+/**
+ * @Description: 更新u是否比当前状态cur更新
+ * @param cur
+ * @param u
+ * @return bool
+ */
+func supersedes(cur, u Member) bool {
+	switch u.State {
+	case StateAlive:
+		//只有更大的Incarnation才能推翻怀疑和死亡
+		return u.Incarnation > cur.Incarnation
+	case StateSuspect:
+		if cur.State == StateAlive {
+			return u.Incarnation >= cur.Incarnation
+		}
+		return cur.State == StateSuspect && u.Incarnation > cur.Incarnation
+	case StateDead, StateLeft:
+		if cur.State == StateDead || cur.State == StateLeft {
+			return u.Incarnation > cur.Incarnation
+		}
+		return u.Incarnation >= cur.Incarnation
+	}
+	return false
+}
@@ -1,6 +1,7 @@
 package cache
 
 import (
+	"sort"
 	"sync"
 	"cache/singleflight"
 )
@@ -19,9 +20,10 @@ var(
  * @param name
  * @param maxBytes
  * @param getter
+ * @param opts 可选配置
  * @return *Group
  */
-func NewGroup(name string,maxBytes int64,getter Getter)  *Group {
+func NewGroup(name string,maxBytes int64,getter Getter,opts ...GroupOption)  *Group {
 	if getter == nil{
 		panic("Group Getter cannot be nil")
 	}
@@ -34,6 +36,13 @@ func NewGroup(name string,maxBytes int64,getter Getter)  *Group {
 		cache:  cache{maxBytes: maxBytes},
 		remoteCache: cache{maxBytes: maxBytes},
 		loader: &singleflight.Group{},
+		loadWait: newHistogram(defaultLatencyBuckets),
+	}
+	//Getter同时实现了Setter,Deleter时直接使用
+	g.setter, _ = getter.(Setter)
+	g.deleter, _ = getter.(Deleter)
+	for _, opt := range opts {
+		opt(g)
 	}
 	groups[name]=g
 	return g
@@ -50,4 +59,19 @@ func GetGroup(name string) *Group {
 	defer rwm.RUnlock()
 	g:= groups[name]
 	return g
+}
+
+/**
+ * @Description: 返回所有的group,按名字排序,加了共享锁
+ * @return []*Group
+ */
+func allGroups() []*Group {
+	rwm.RLock()
+	defer rwm.RUnlock()
+	gs := make([]*Group, 0, len(groups))
+	for _, g := range groups {
+		gs = append(gs, g)
+	}
+	sort.Slice(gs, func(i, j int) bool { return gs[i].name < gs[j].name })
+	return gs
 }
\ No newline at end of file
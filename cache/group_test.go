@@ -1,11 +1,15 @@
 package cache
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"reflect"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestGetter(t *testing.T) {
@@ -79,3 +83,262 @@ func TestGetGroup(t *testing.T) {
 		t.Fatalf("expect nil, but %s got", group.name)
 	}
 }
+
+/**
+ * @Description: 替换timeNow,返回一个可以推进时间的函数
+ */
+func fakeClock(t *testing.T) func(d time.Duration) {
+	now := time.Now()
+	var mu sync.Mutex
+	timeNow = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	t.Cleanup(func() { timeNow = time.Now })
+	return func(d time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		now = now.Add(d)
+	}
+}
+
+/**
+ * @Description: 计数的getter,返回的值带上加载次数
+ */
+func countingGetter(loads *int32) Getter {
+	return GetterFunc(func(key string) ([]byte, error) {
+		n := atomic.AddInt32(loads, 1)
+		return []byte(key + strconv.Itoa(int(n))), nil
+	})
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for condition")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestTTL(t *testing.T) {
+	advance := fakeClock(t)
+	var loads int32
+	g := NewGroup("ttl", 2<<10, countingGetter(&loads), WithTTL(time.Minute))
+
+	if v, _ := g.Get("k"); v.String() != "k1" || v.Expire().IsZero() {
+		t.Fatalf("unexpected first value %q", v)
+	}
+	advance(30 * time.Second)
+	if v, _ := g.Get("k"); v.String() != "k1" {
+		t.Fatalf("value should be cached, got %q", v)
+	}
+	advance(time.Minute)
+	if v, _ := g.Get("k"); v.String() != "k2" {
+		t.Fatalf("expired value should be reloaded, got %q", v)
+	}
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	advance := fakeClock(t)
+	var loads int32
+	g := NewGroup("swr", 2<<10, countingGetter(&loads),
+		WithTTL(time.Minute), WithStaleWhileRevalidate(10*time.Second))
+
+	g.Get("k")
+	advance(time.Minute + time.Second)
+	//宽限期内返回旧值,并在后台刷新
+	if v, _ := g.Get("k"); v.String() != "k1" {
+		t.Fatalf("stale value should be served, got %q", v)
+	}
+	waitFor(t, func() bool {
+		v, _ := g.cache.get("k")
+		return v.String() == "k2"
+	})
+	if v, _ := g.Get("k"); v.String() != "k2" {
+		t.Fatalf("refreshed value should be served, got %q", v)
+	}
+
+	//超过宽限期同步加载
+	advance(2 * time.Minute)
+	if v, _ := g.Get("k"); v.String() != "k3" {
+		t.Fatalf("value beyond grace should be reloaded, got %q", v)
+	}
+}
+
+func TestCheckExpireKeepsRefreshedValue(t *testing.T) {
+	advance := fakeClock(t)
+	var loads int32
+	g := NewGroup("expire-race", 2<<10, countingGetter(&loads), WithTTL(time.Minute))
+	g.Get("k")
+	advance(2 * time.Minute)
+	expired, _ := g.cache.peek("k")
+
+	//检查过期之前后台刷新已经写入了新值,新值不能被删除
+	g.store(&g.cache, "k", ByteView{value: []byte("fresh"), expire: timeNow().Add(time.Minute)})
+	if _, ok := g.checkExpire(&g.cache, "k", expired); ok {
+		t.Fatal("expired value should not be served")
+	}
+	if v, ok := g.cache.peek("k"); !ok || v.String() != "fresh" {
+		t.Fatalf("refreshed value should be kept, got %q", v)
+	}
+}
+
+func TestEarlyRefresh(t *testing.T) {
+	advance := fakeClock(t)
+	var loads int32
+	g := NewGroup("xfetch", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		n := atomic.AddInt32(&loads, 1)
+		//模拟耗时的加载
+		advance(time.Second)
+		return []byte(key + strconv.Itoa(int(n))), nil
+	}), WithTTL(time.Minute), WithEarlyRefresh(1000))
+
+	g.Get("k")
+	//delta*beta远大于剩余存活时间,几乎必然提前刷新
+	advance(50 * time.Second)
+	if v, _ := g.Get("k"); v.String() != "k1" {
+		t.Fatalf("value should still be served before expire, got %q", v)
+	}
+	waitFor(t, func() bool {
+		v, _ := g.cache.get("k")
+		return v.String() == "k2"
+	})
+}
+
+func TestServeStaleOnError(t *testing.T) {
+	advance := fakeClock(t)
+	var loads int32
+	var down int32
+	g := NewGroup("stale", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		if atomic.LoadInt32(&down) == 1 {
+			return nil, fmt.Errorf("db is down")
+		}
+		n := atomic.AddInt32(&loads, 1)
+		return []byte(key + strconv.Itoa(int(n))), nil
+	}), WithTTL(time.Minute), WithServeStaleOnError(time.Minute, 2<<10))
+
+	g.Get("k")
+	atomic.StoreInt32(&down, 1)
+	advance(time.Minute + time.Second)
+	v, err := g.Get("k")
+	if err != nil || v.String() != "k1" || !v.Stale() {
+		t.Fatalf("expected stale k1, got %q stale=%v err=%v", v, v.Stale(), err)
+	}
+	if n := g.Stats().StaleServes; n != 1 {
+		t.Fatalf("expected 1 stale serve, got %d", n)
+	}
+
+	//超过window后不再返回旧值
+	advance(time.Minute)
+	if _, err := g.Get("k"); err == nil {
+		t.Fatal("stale value beyond window should not be served")
+	}
+
+	//恢复后返回新值
+	atomic.StoreInt32(&down, 0)
+	if v, err := g.Get("k"); err != nil || v.String() != "k2" || v.Stale() {
+		t.Fatalf("expected fresh k2, got %q err=%v", v, err)
+	}
+}
+
+func TestServeStaleAfterEviction(t *testing.T) {
+	var down int32
+	g := NewGroup("stale-evict", int64(len("k1")+len("k1")), GetterFunc(func(key string) ([]byte, error) {
+		if atomic.LoadInt32(&down) == 1 {
+			return nil, fmt.Errorf("db is down")
+		}
+		return []byte(key), nil
+	}), WithServeStaleOnError(time.Minute, 2<<10))
+
+	g.Get("k1")
+	//k2会把k1淘汰到旧值缓冲区
+	g.Get("k2")
+	atomic.StoreInt32(&down, 1)
+	if v, err := g.Get("k1"); err != nil || v.String() != "k1" || !v.Stale() {
+		t.Fatalf("expected evicted k1 to be served stale, got %q err=%v", v, err)
+	}
+}
+
+func TestRemovedValueNotBuffered(t *testing.T) {
+	g := NewGroup("stale-remove", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}), WithServeStaleOnError(time.Minute, 2<<10))
+
+	//主动删除和解密失败的值不进入旧值缓冲区
+	g.Get("k1")
+	g.cache.remove("k1")
+	g.store(&g.cache, "k2", ByteView{value: []byte("garbage"), sealed: true})
+	if _, ok := g.lookup(context.Background(), "k2"); ok {
+		t.Fatal("value that fails to unpack should be a miss")
+	}
+	for _, key := range []string{"k1", "k2"} {
+		if v, ok := g.stale.get(key, timeNow()); ok {
+			t.Fatalf("%s should not be buffered, got %q", key, v)
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	g := NewGroup("stats", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		if key == "bad" {
+			return nil, fmt.Errorf("%s not exist", key)
+		}
+		return []byte(key), nil
+	}))
+
+	g.Get("k1")
+	g.Get("k1")
+	g.Get("k2")
+	g.Get("bad")
+
+	s := g.Stats()
+	if s.Name != "stats" || s.Gets != 4 || s.CacheHits != 1 || s.Loads != 3 || s.LoadsDeduped != 3 {
+		t.Fatalf("unexpected get stats %+v", s)
+	}
+	if s.LocalLoads != 2 || s.LocalLoadErrs != 1 || s.PeerLoads != 0 || s.PeerErrors != 0 {
+		t.Fatalf("unexpected load stats %+v", s)
+	}
+	if s.MainCache.Items != 2 || s.MainCache.Bytes != int64(len("k1k1k2k2")) || s.MainCache.Hits != 1 {
+		t.Fatalf("unexpected cache stats %+v", s.MainCache)
+	}
+}
+
+func TestStatsEvictions(t *testing.T) {
+	g := NewGroup("stats-evict", int64(len("k1k1")), GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	g.Get("k1")
+	g.Get("k2")
+	g.Get("k3")
+	if s := g.Stats().MainCache; s.Evictions != 2 || s.Items != 1 {
+		t.Fatalf("unexpected cache stats %+v", s)
+	}
+}
+
+func TestStatsLoadsDeduped(t *testing.T) {
+	release := make(chan struct{})
+	g := NewGroup("stats-dedup", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		<-release
+		return []byte(key), nil
+	}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			g.Get("k")
+		}()
+	}
+	waitFor(t, func() bool { return g.Stats().Loads == 10 })
+	//等待所有协程进入singleflight
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if s := g.Stats(); s.LoadsDeduped != 1 || s.LocalLoads != 1 {
+		t.Fatalf("expected singleflight to dedup loads, got %+v", s)
+	}
+}
@@ -0,0 +1,383 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+/**
+ * @Description: 节点的健康状态:熔断器,失败重试以及后台健康检查
+ */
+
+const defaultHealthPath = "/health"
+
+//熔断器的默认配置
+const (
+	defaultFailureThreshold = 5
+	defaultBreakerCooldown  = 10 * time.Second
+)
+
+/**
+ * @Description: 熔断器状态
+ */
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota //正常,请求可以通过
+	BreakerOpen                         //熔断,请求直接跳过该节点
+	BreakerHalfOpen                     //冷却结束,允许一个试探请求
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("BreakerState(%d)", int(s))
+}
+
+/**
+ * @Description: JSON中使用字符串表示
+ * @receiver s
+ * @return []byte
+ * @return error
+ */
+func (s BreakerState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+/**
+ * @Description: 解析MarshalText的结果
+ * @receiver s
+ * @param text
+ * @return error
+ */
+func (s *BreakerState) UnmarshalText(text []byte) error {
+	for _, state := range []BreakerState{BreakerClosed, BreakerOpen, BreakerHalfOpen} {
+		if state.String() == string(text) {
+			*s = state
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown breaker state %q", text)
+}
+
+/**
+ * @Description: 节点健康状态的快照
+ */
+type PeerStatus struct {
+	Addr                string
+	State               BreakerState
+	ConsecutiveFailures int
+	LastError           string
+	LastFailure         time.Time
+}
+
+/**
+ * @Description: 一个节点的熔断器
+ * 连续失败threshold次后打开,cooldown之后进入半开状态,允许一个试探请求,成功则关闭,失败则重新打开
+ */
+type peerHealth struct {
+	mu          sync.Mutex
+	state       BreakerState
+	failures    int //连续失败次数
+	openedAt    time.Time
+	trial       bool      //半开状态下是否已经放过了试探请求
+	trialAt     time.Time //放过试探请求的时间,试探请求被取消时没有结果,超过cooldown后允许新的试探
+	lastErr     error
+	lastFailure time.Time
+	threshold   int
+	cooldown    time.Duration
+}
+
+func newPeerHealth(threshold int, cooldown time.Duration) *peerHealth {
+	return &peerHealth{threshold: threshold, cooldown: cooldown}
+}
+
+/**
+ * @Description: 判断节点是否可以被选中,只读,不改变熔断器的状态,用于选择节点和判断owner
+ * @receiver h
+ * @param now
+ * @return bool
+ */
+func (h *peerHealth) ready(now time.Time) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	switch h.state {
+	case BreakerOpen:
+		return now.Sub(h.openedAt) >= h.cooldown
+	case BreakerHalfOpen:
+		return !h.trial || now.Sub(h.trialAt) >= h.cooldown
+	}
+	return true
+}
+
+/**
+ * @Description: 发送请求前调用,判断是否允许向该节点发送请求,半开状态下占用唯一的试探请求
+ * @receiver h
+ * @param now
+ * @return bool
+ */
+func (h *peerHealth) acquire(now time.Time) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	switch h.state {
+	case BreakerOpen:
+		if now.Sub(h.openedAt) < h.cooldown {
+			return false
+		}
+		h.state = BreakerHalfOpen
+		h.trial = true
+		h.trialAt = now
+		return true
+	case BreakerHalfOpen:
+		if h.trial && now.Sub(h.trialAt) < h.cooldown {
+			return false
+		}
+		h.trial = true
+		h.trialAt = now
+		return true
+	}
+	return true
+}
+
+/**
+ * @Description: 记录一次成功,关闭熔断器
+ * @receiver h
+ */
+func (h *peerHealth) success() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.state = BreakerClosed
+	h.failures = 0
+	h.trial = false
+}
+
+/**
+ * @Description: 记录一次失败,连续失败达到阈值或者半开状态下失败会打开熔断器
+ * @receiver h
+ * @param err
+ * @param now
+ */
+func (h *peerHealth) failure(err error, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.failures++
+	h.lastErr = err
+	h.lastFailure = now
+	if h.state == BreakerHalfOpen || (h.threshold > 0 && h.failures >= h.threshold) {
+		h.state = BreakerOpen
+		h.openedAt = now
+		h.trial = false
+	}
+}
+
+/**
+ * @Description: 健康检查成功,打开状态的熔断器进入半开状态,由下一个真实请求试探
+ * @receiver h
+ */
+func (h *peerHealth) probeSuccess() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.state == BreakerOpen {
+		h.state = BreakerHalfOpen
+		h.trial = false
+	}
+}
+
+func (h *peerHealth) status(addr string) PeerStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := PeerStatus{
+		Addr:                addr,
+		State:               h.state,
+		ConsecutiveFailures: h.failures,
+		LastFailure:         h.lastFailure,
+	}
+	if h.lastErr != nil {
+		s.LastError = h.lastErr.Error()
+	}
+	return s
+}
+
+/**
+ * @Description: 请求其他节点失败的错误,transient表示可以重试,也会计入熔断器
+ */
+type peerError struct {
+	status    int //http状态码,0表示没有收到响应
+	err       error
+	transient bool
+}
+
+func (e *peerError) Error() string {
+	return e.err.Error()
+}
+
+func (e *peerError) Unwrap() error {
+	return e.err
+}
+
+/**
+ * @Description: 判断是否是临时性的错误,网络错误,超时以及502/503/504可以重试
+ * @param err
+ * @return bool
+ */
+func isTransient(err error) bool {
+	var pe *peerError
+	return errors.As(err, &pe) && pe.transient
+}
+
+/**
+ * @Description: 发送请求前占用熔断器,熔断中时返回ErrOwnerUnavailable,不计入失败也不重试
+ * @receiver h
+ * @return error
+ */
+func (h *httpClient) acquire() error {
+	if h.health != nil && !h.health.acquire(time.Now()) {
+		return &peerError{err: fmt.Errorf("%s: %w", h.baseURL, ErrOwnerUnavailable)}
+	}
+	return nil
+}
+
+func transientStatus(code int) bool {
+	return code == http.StatusBadGateway || code == http.StatusServiceUnavailable || code == http.StatusGatewayTimeout
+}
+
+/**
+ * @Description: 第attempt次重试前的退避时间,指数增长并带有随机抖动
+ * @param base
+ * @param attempt 从0开始
+ * @return time.Duration
+ */
+func backoff(base time.Duration, attempt int) time.Duration {
+	d := base << uint(attempt)
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+/**
+ * @Description: 返回所有节点的健康状态,按地址排序
+ * @receiver g
+ * @return []PeerStatus
+ */
+func (g *GroupHTTP) PeersHealth() []PeerStatus {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	statuses := make([]PeerStatus, 0, len(g.NodeClientMap))
+	for addr, c := range g.NodeClientMap {
+		statuses = append(statuses, c.health.status(addr))
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Addr < statuses[j].Addr })
+	return statuses
+}
+
+/**
+ * @Description: 后台健康检查,每隔interval请求一次所有节点的/health
+ * @receiver g
+ * @param interval
+ */
+func (g *GroupHTTP) runHealthCheck(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-g.done:
+			return
+		case <-ticker.C:
+			g.probePeers(interval)
+		}
+	}
+}
+
+/**
+ * @Description: 检查一次所有节点
+ * @receiver g
+ * @param timeout
+ */
+func (g *GroupHTTP) probePeers(timeout time.Duration) {
+	g.mu.Lock()
+	clients := make(map[string]*httpClient, len(g.NodeClientMap))
+	for addr, c := range g.NodeClientMap {
+		if addr != g.addr {
+			clients[addr] = c
+		}
+	}
+	g.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for addr, c := range clients {
+		wg.Add(1)
+		go func(addr string, c *httpClient) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			if err := c.probe(ctx, addr+defaultHealthPath); err != nil {
+				c.health.failure(err, time.Now())
+				g.logger().Warn("peer health check failed", "node", g.addr, "peer", addr, "err", err)
+				return
+			}
+			c.health.probeSuccess()
+		}(addr, c)
+	}
+	wg.Wait()
+}
+
+/**
+ * @Description: 请求节点的健康检查接口
+ * @receiver h
+ * @param ctx
+ * @param u
+ * @return error
+ */
+func (h *httpClient) probe(ctx context.Context, u string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	res, err := h.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("health check returned:%v", res.Status)
+	}
+	return nil
+}
+
+/**
+ * @Description: /health 的http处理函数
+ * @receiver g
+ * @param w
+ * @param r
+ */
+func (g *GroupHTTP) serveHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	//关闭过程中返回503,负载均衡和其他节点的健康检查不再把请求发过来
+	if g.draining.Get() != 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("draining"))
+		return
+	}
+	w.Write([]byte("ok"))
+}
+
+/**
+ * @Description: 停止后台的健康检查
+ * @receiver g
+ */
+func (g *GroupHTTP) Close() {
+	g.closeOnce.Do(func() { close(g.done) })
+}
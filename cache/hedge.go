@@ -0,0 +1,202 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	pb "cache/cachepb"
+	"google.golang.org/protobuf/proto"
+)
+
+/**
+ * @Description: 对冲请求,第一个节点在一定时间内没有响应时,向环上的下一个节点(或者本地)再发一个请求,取先返回的结果
+ */
+
+//计算延迟分位数所需的最少样本数,样本不足时使用最小延迟
+const hedgeMinSamples = 20
+
+/**
+ * @Description: 返回直方图中第q分位的耗时,取所在分桶的上界
+ * @receiver h
+ * @param q 0到1之间
+ * @return time.Duration
+ * @return bool 样本数不足时返回false
+ */
+func (h *histogram) quantile(q float64) (time.Duration, bool) {
+	cumulative, _, count := h.snapshot()
+	if count < hedgeMinSamples {
+		return 0, false
+	}
+	rank := uint64(q * float64(count))
+	for i, c := range cumulative {
+		if c >= rank && i < len(h.bounds) {
+			return time.Duration(h.bounds[i] * float64(time.Second)), true
+		}
+	}
+	return time.Duration(h.bounds[len(h.bounds)-1] * float64(time.Second)), true
+}
+
+/**
+ * @Description: 对冲请求的客户端,由PickNode在开启对冲时返回
+ */
+type hedgedClient struct {
+	g         *GroupHTTP
+	primary   *httpClient
+	secondary NodeClient //环上的下一个节点,或者本地加载
+	delay     time.Duration
+}
+
+/**
+ * @Description: 写请求不对冲,只发给primary
+ * @receiver c
+ * @param ctx
+ * @param in
+ * @param value
+ * @return error
+ */
+func (c *hedgedClient) Set(ctx context.Context, in *pb.Request, value []byte, out *pb.Response) error {
+	return c.primary.Set(ctx, in, value, out)
+}
+
+func (c *hedgedClient) Delete(ctx context.Context, in *pb.Request) error {
+	return c.primary.Delete(ctx, in)
+}
+
+type hedgeResult struct {
+	res   *pb.Response
+	err   error
+	hedge bool
+}
+
+/**
+ * @Description: 先请求primary,delay之后还没有返回(或者已经失败)就请求secondary,返回先成功的结果并取消另一个
+ * @receiver c
+ * @param ctx
+ * @param in
+ * @param out
+ * @return error
+ */
+func (c *hedgedClient) Get(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan hedgeResult, 2)
+	send := func(node NodeClient, hedge bool) {
+		res := &pb.Response{}
+		err := node.Get(ctx, in, res)
+		results <- hedgeResult{res: res, err: err, hedge: hedge}
+	}
+	go send(c.primary, false)
+	inflight := 1
+
+	timer := time.NewTimer(c.delay)
+	defer timer.Stop()
+	hedged := false
+	hedge := func() {
+		hedged = true
+		inflight++
+		c.g.hedges.Add(1)
+		go send(c.secondary, true)
+	}
+
+	var lastErr error
+	for {
+		select {
+		case <-timer.C:
+			if !hedged {
+				c.g.logger().Debug("hedge peer request", "node", c.g.addr, "group", in.GetGroup(), "key", in.GetKey(), "delay", c.delay)
+				hedge()
+			}
+		case r := <-results:
+			inflight--
+			if r.err == nil {
+				if r.hedge {
+					c.g.hedgeWins.Add(1)
+				}
+				proto.Reset(out)
+				proto.Merge(out, r.res)
+				return nil
+			}
+			//owner明确的回答(如key不存在)直接返回,不再对冲,否则下一个节点会再从数据源加载一次
+			if !hedgeable(r.err) {
+				return r.err
+			}
+			lastErr = r.err
+			//第一个请求失败了,不用再等,立刻对冲
+			if !hedged {
+				hedge()
+				continue
+			}
+			if inflight == 0 {
+				return lastErr
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+/**
+ * @Description: 失败的请求是否可以对冲,只有网络错误,超时和5xx可以;
+ * key不存在,版本冲突以及其他4xx是节点明确的回答
+ * @param err
+ * @return bool
+ */
+func hedgeable(err error) bool {
+	if errors.Is(err, ErrNotFound) || errors.Is(err, ErrVersionConflict) {
+		return false
+	}
+	var pe *peerError
+	if errors.As(err, &pe) && pe.status != 0 && pe.status < http.StatusInternalServerError {
+		return false
+	}
+	return true
+}
+
+/**
+ * @Description: 在本地加载的NodeClient,对冲请求的下一个节点是自己时使用
+ */
+type localNode struct{}
+
+func (localNode) Get(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	g := GetGroup(in.GetGroup())
+	if g == nil {
+		return fmt.Errorf("no such group: %s", in.GetGroup())
+	}
+	view, err := g.getLocally(ctx, in.GetKey())
+	if err != nil {
+		return err
+	}
+	out.Value = view.Copy()
+	out.Version = view.version
+	if !view.expire.IsZero() {
+		out.Expire = view.expire.UnixNano()
+	}
+	return nil
+}
+
+/**
+ * @Description: 对冲的等待时间,取primary延迟的hedgePercentile分位,不低于hedgeMinDelay
+ * @receiver g
+ * @param primary
+ * @return time.Duration
+ */
+func (g *GroupHTTP) hedgeDelay(primary *httpClient) time.Duration {
+	if d, ok := primary.latency.quantile(g.hedgePercentile); ok && d > g.hedgeMinDelay {
+		return d
+	}
+	return g.hedgeMinDelay
+}
+
+/**
+ * @Description: 返回发出的对冲请求数,以及对冲请求先返回的次数
+ * @receiver g
+ * @return issued
+ * @return won
+ */
+func (g *GroupHTTP) HedgeStats() (issued, won int64) {
+	return g.hedges.Get(), g.hedgeWins.Get()
+}
@@ -0,0 +1,150 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pb "cache/cachepb"
+	"google.golang.org/protobuf/proto"
+)
+
+/**
+ * @Description: 延迟delay后返回value的节点
+ */
+func slowPeer(delay time.Duration, value string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+		body, _ := proto.Marshal(&pb.Response{Value: []byte(value)})
+		w.Write(body)
+	}))
+}
+
+func TestHistogramQuantile(t *testing.T) {
+	h := newHistogram(defaultLatencyBuckets)
+	if _, ok := h.quantile(0.9); ok {
+		t.Fatal("quantile should need enough samples")
+	}
+	for i := 0; i < 90; i++ {
+		h.observe(time.Millisecond)
+	}
+	for i := 0; i < 10; i++ {
+		h.observe(time.Second)
+	}
+	if d, ok := h.quantile(0.9); !ok || d != time.Millisecond {
+		t.Fatalf("expected p90 of 1ms, got %v", d)
+	}
+	if d, _ := h.quantile(0.99); d != time.Second {
+		t.Fatalf("expected p99 of 1s, got %v", d)
+	}
+}
+
+func TestHedgedRequest(t *testing.T) {
+	slow := slowPeer(time.Second, "slow")
+	defer slow.Close()
+	fast := slowPeer(0, "fast")
+	defer fast.Close()
+
+	g := NewGroupHTTP("http://self", WithHedging(0.95, 10*time.Millisecond))
+	g.Set(slow.URL, fast.URL)
+	c := &hedgedClient{g: g, primary: g.NodeClientMap[slow.URL], secondary: g.NodeClientMap[fast.URL], delay: g.hedgeDelay(g.NodeClientMap[slow.URL])}
+
+	start := time.Now()
+	res := &pb.Response{}
+	if err := c.Get(context.Background(), &pb.Request{Group: "g", Key: "k"}, res); err != nil {
+		t.Fatal(err)
+	}
+	if string(res.Value) != "fast" {
+		t.Fatalf("expected hedged answer, got %q", res.Value)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Fatalf("hedged request should not wait for the slow peer, took %v", d)
+	}
+	if issued, won := g.HedgeStats(); issued != 1 || won != 1 {
+		t.Fatalf("expected 1 hedge issued and won, got %d %d", issued, won)
+	}
+	//被取消的请求不计入熔断器
+	if s := g.NodeClientMap[slow.URL].health.status(slow.URL); s.ConsecutiveFailures != 0 {
+		t.Fatalf("cancelled request should not count as failure, got %+v", s)
+	}
+}
+
+func TestHedgeNotIssuedForFastPeer(t *testing.T) {
+	fast := slowPeer(0, "fast")
+	defer fast.Close()
+
+	g := NewGroupHTTP("http://self", WithHedging(0.95, time.Second))
+	g.Set("http://self", fast.URL)
+	node, ok := g.PickNode(keyOwnedBy(t, g, fast.URL))
+	if !ok {
+		t.Fatal("expected a peer to be picked")
+	}
+	if c, ok := node.(*hedgedClient); !ok || c.secondary != (localNode{}) {
+		t.Fatalf("expected hedging to fall back to local load, got %#v", node)
+	}
+	res := &pb.Response{}
+	if err := node.Get(context.Background(), &pb.Request{Group: "g", Key: "k"}, res); err != nil || string(res.Value) != "fast" {
+		t.Fatalf("unexpected response %q err=%v", res.Value, err)
+	}
+	if issued, _ := g.HedgeStats(); issued != 0 {
+		t.Fatalf("no hedge should be issued for a fast peer, got %d", issued)
+	}
+}
+
+func TestHedgeNotIssuedForAuthoritativeError(t *testing.T) {
+	var secondary int32
+	owner := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(errorHeader, "not_found")
+		http.Error(w, "not found", http.StatusNotFound)
+	}))
+	defer owner.Close()
+	next := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&secondary, 1)
+		body, _ := proto.Marshal(&pb.Response{Value: []byte("loaded")})
+		w.Write(body)
+	}))
+	defer next.Close()
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "busy", http.StatusServiceUnavailable)
+	}))
+	defer broken.Close()
+
+	g := NewGroupHTTP("http://self", WithHedging(0.95, time.Second))
+	g.Set(owner.URL, next.URL, broken.URL)
+
+	//owner明确回答不存在,不再请求下一个节点
+	c := &hedgedClient{g: g, primary: g.NodeClientMap[owner.URL], secondary: g.NodeClientMap[next.URL], delay: time.Second}
+	err := c.Get(context.Background(), &pb.Request{Group: "g", Key: "k"}, &pb.Response{})
+	if !errors.Is(err, ErrNotFound) || atomic.LoadInt32(&secondary) != 0 {
+		t.Fatalf("expected not found without hedging, got %v (%d hedged requests)", err, secondary)
+	}
+
+	//5xx立即对冲
+	c = &hedgedClient{g: g, primary: g.NodeClientMap[broken.URL], secondary: g.NodeClientMap[next.URL], delay: time.Second}
+	res := &pb.Response{}
+	if err := c.Get(context.Background(), &pb.Request{Group: "g", Key: "k"}, res); err != nil || string(res.Value) != "loaded" {
+		t.Fatalf("expected hedged answer after 503, got %q err=%v", res.Value, err)
+	}
+}
+
+/**
+ * @Description: 找到一个owner为peer的key
+ */
+func keyOwnedBy(t *testing.T, g *GroupHTTP, peer string) string {
+	for i := 0; i < 1000; i++ {
+		k := time.Duration(i).String()
+		if g.nodes.Get(k) == peer {
+			return k
+		}
+	}
+	t.Fatalf("no key owned by %s", peer)
+	return ""
+}
@@ -0,0 +1,108 @@
+package cache
+
+import (
+	"sort"
+	"sync"
+)
+
+/**
+ * @Description: 热点key统计,使用Space-Saving算法,只保存capacity个key的计数
+ * 每decayEvery次访问所有计数减半,让最近的热点更突出
+ * 每次Get都要加锁并且可能遍历所有计数,默认不开启,见 WithHotKeys
+ */
+
+const (
+	defaultHotKeysCapacity = 32
+	defaultHotKeysDecay    = 10000
+)
+
+/**
+ * @Description: 热点key以及估计的访问次数
+ */
+type HotKey struct {
+	Key   string `json:"key"`
+	Count int64  `json:"count"`
+}
+
+type hotKeys struct {
+	mu         sync.Mutex
+	capacity   int
+	decayEvery int
+	touches    int
+	counts     map[string]int64
+}
+
+func newHotKeys(capacity, decayEvery int) *hotKeys {
+	return &hotKeys{capacity: capacity, decayEvery: decayEvery, counts: make(map[string]int64, capacity)}
+}
+
+/**
+ * @Description: 记录一次访问,已满时替换计数最小的key,新key继承它的计数
+ * @receiver h
+ * @param key
+ */
+func (h *hotKeys) touch(key string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.touches++
+	if h.touches >= h.decayEvery {
+		h.touches = 0
+		for k, c := range h.counts {
+			if c /= 2; c == 0 {
+				delete(h.counts, k)
+			} else {
+				h.counts[k] = c
+			}
+		}
+	}
+	if _, ok := h.counts[key]; ok || len(h.counts) < h.capacity {
+		h.counts[key]++
+		return
+	}
+	minKey, minCount := "", int64(-1)
+	for k, c := range h.counts {
+		if minCount < 0 || c < minCount {
+			minKey, minCount = k, c
+		}
+	}
+	delete(h.counts, minKey)
+	h.counts[key] = minCount + 1
+}
+
+/**
+ * @Description: 返回访问次数最多的n个key
+ * @receiver h
+ * @param n
+ * @return []HotKey
+ */
+func (h *hotKeys) top(n int) []HotKey {
+	h.mu.Lock()
+	keys := make([]HotKey, 0, len(h.counts))
+	for k, c := range h.counts {
+		keys = append(keys, HotKey{Key: k, Count: c})
+	}
+	h.mu.Unlock()
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Count != keys[j].Count {
+			return keys[i].Count > keys[j].Count
+		}
+		return keys[i].Key < keys[j].Key
+	})
+	if len(keys) > n {
+		keys = keys[:n]
+	}
+	return keys
+}
+
+/**
+ * @Description: 返回本节点上访问次数最多的n个key,次数为估计值,没有开启 WithHotKeys 时为空
+ * @receiver g
+ * @param n
+ * @return []HotKey
+ */
+func (g *Group) HotKeys(n int) []HotKey {
+	if g.hot == nil {
+		return []HotKey{}
+	}
+	return g.hot.top(n)
+}
@@ -1,47 +1,156 @@
 package cache
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	pb "cache/cachepb"
 	"cache/consistenthash"
 	"fmt"
 	"google.golang.org/protobuf/proto"
+	"io"
 	"io/ioutil"
-	"log"
+	"net"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 /**
  * @Description: 基于 http 的缓存服务器
  */
 
 const defaultPrefix ="/cache/"
+
+const expireHeader = "X-Cache-Expire" //304时返回的过期时间,unix纳秒
 const defaultNodeVirReplicas =50
 
+//请求其他节点的默认配置
+const (
+	defaultPeerTimeout         = 5 * time.Second
+	defaultMaxIdleConnsPerPeer = 32
+	defaultKeepAlive           = 30 * time.Second
+	defaultIdleConnTimeout     = 90 * time.Second
+)
+
 
 type GroupHTTP struct {
+	hedges    AtomicInt //发出的对冲请求数,原子操作,放在首位保证64位对齐
+	hedgeWins AtomicInt //对冲请求先返回的次数
+	draining  AtomicInt //本节点正在关闭,不为0时/health返回503,见 server.go
+
 	//GroupHttp属性
 	addr string
 	prefix string
+	replicas int //一致性hash上每个节点的虚拟节点数
 
 	//和分布式有关的
 	mu sync.Mutex
 	nodes *consistenthash.ConsistentHash
 	NodeClientMap map[string]*httpClient
+	members []string        //Set设置的所有节点
+	left    map[string]bool //通知过离开的节点,不在一致性hash上,见 server.go
 	//noneFilter *bloom.BloomFilter
 	//valueFilter *bloom.BloomFilter
+
+	log Logger //为nil时使用全局Logger
+
+	//请求其他节点的http配置,见 WithHTTPClient 等HTTPOption
+	client              *http.Client
+	transport           http.RoundTripper
+	peerTimeout         time.Duration
+	maxIdleConnsPerPeer int
+	keepAlive           time.Duration
+	idleConnTimeout     time.Duration
+
+	//节点故障处理,见 WithCircuitBreaker,WithPeerRetry,WithHealthCheck
+	failureThreshold int
+	breakerCooldown  time.Duration
+	retries          int
+	retryBackoff     time.Duration
+	healthInterval   time.Duration
+	done             chan struct{}
+
+	//对冲请求,见 WithHedging
+	hedging         bool
+	hedgePercentile float64
+	hedgeMinDelay   time.Duration
+
+	//TLS,见 WithTLS
+	certs             *CertReloader
+	requireClientCert bool
+
+	//认证和授权,见 WithAuth
+	auth  Authenticator
+	authz Authorizer
+	unauthWrites bool //没有认证时也接受其他节点转发的写请求,见 WithUnauthenticatedWrites
+	publicMetrics bool //开启认证后/metrics也不需要认证,见 WithPublicMetrics
+
+	adminToken string //为空时不开启管理接口,见 WithAdmin
+
+	//节点发现,见 WithDiscovery
+	discovery         Discovery
+	discoveryDebounce time.Duration
+	closeOnce        sync.Once
 }
 /**
  * @Description: 构造函数
  * @param addr
+ * @param opts 可选配置
  * @return *GroupHTTP
  */
-func NewGroupHTTP(addr string)*GroupHTTP  {
-	return &GroupHTTP{
+func NewGroupHTTP(addr string,opts ...HTTPOption)*GroupHTTP  {
+	g := &GroupHTTP{
 		addr: addr,
 		prefix: defaultPrefix,
+		replicas: defaultNodeVirReplicas,
+		peerTimeout:         defaultPeerTimeout,
+		maxIdleConnsPerPeer: defaultMaxIdleConnsPerPeer,
+		keepAlive:           defaultKeepAlive,
+		idleConnTimeout:     defaultIdleConnTimeout,
+		failureThreshold:    defaultFailureThreshold,
+		breakerCooldown:     defaultBreakerCooldown,
+		done:                make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	if g.client == nil {
+		g.client = &http.Client{Transport: g.newTransport()}
+	}
+	if g.healthInterval > 0 {
+		go g.runHealthCheck(g.healthInterval)
+	}
+	if g.discovery != nil {
+		g.Subscribe(g.discovery, g.discoveryDebounce)
+	}
+	return g
+}
+
+/**
+ * @Description: 构造请求其他节点使用的RoundTripper,所有节点共用,每个节点保留maxIdleConnsPerPeer个空闲连接
+ * @receiver g
+ * @return http.RoundTripper
+ */
+func (g *GroupHTTP) newTransport() http.RoundTripper {
+	if g.transport != nil {
+		return g.transport
 	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DialContext = (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: g.keepAlive,
+	}).DialContext
+	t.MaxIdleConnsPerHost = g.maxIdleConnsPerPeer
+	t.IdleConnTimeout = g.idleConnTimeout
+	//keepAlive<0表示关闭长连接
+	t.DisableKeepAlives = g.keepAlive < 0
+	if g.certs != nil {
+		t.TLSClientConfig = g.certs.ClientConfig()
+	}
+	return t
 }
 
 
@@ -58,20 +167,52 @@ func (g *GroupHTTP) Set(nodeNames ...string)  {
 	//g.noneFilter = bloom.NewBloomFilter(uint(16<<20), 5)
 	//g.valueFilter = bloom.NewBloomFilter(uint(16<<20), 5)
 
+	g.members = append([]string(nil), nodeNames...)
+	g.left = nil
+	g.setRing()
+}
+
+/**
+ * @Description: 用members中没有离开的节点重建一致性hash和节点客户端映射,调用方需要持有mu
+ * @receiver g
+ */
+func (g *GroupHTTP) setRing() {
+	var nodeNames []string
+	for _, nodeName := range g.members {
+		if !g.left[nodeName] {
+			nodeNames = append(nodeNames, nodeName)
+		}
+	}
+
 	//使用默认的hash函数
-	g.nodes = consistenthash.New(defaultNodeVirReplicas,nil)
+	g.nodes = consistenthash.New(g.replicas,nil)
 	g.nodes.Add(nodeNames...)
 
-	//构造出节点客户端映射
+	//构造出节点客户端映射,已经存在的节点保留健康状态和统计信息
+	old := g.NodeClientMap
 	g.NodeClientMap = make(map[string]*httpClient,len(nodeNames))
 	for _, nodeName := range nodeNames {
-		g.NodeClientMap[nodeName] = &httpClient{baseURL: nodeName+g.prefix}
+		if c, ok := old[nodeName]; ok {
+			g.NodeClientMap[nodeName] = c
+			continue
+		}
+		g.NodeClientMap[nodeName] = &httpClient{
+			baseURL: nodeName+g.prefix,
+			client:  g.client,
+			timeout: g.peerTimeout,
+			retries: g.retries,
+			backoff: g.retryBackoff,
+			auth:    g.auth,
+			health:  newPeerHealth(g.failureThreshold, g.breakerCooldown),
+			latency: newHistogram(defaultLatencyBuckets),
+		}
 	}
 }
 
 
 /**
  * @Description: 将GroupHTTP 实现为 NodePicker,GroupHTTP 能够通过一致性hash根据key得到节点客户端
+ * 熔断中的节点会被跳过,沿着环顺时针选择下一个节点,轮到自己时在本地加载
  * @receiver g
  * @param key
  * @return node
@@ -81,13 +222,76 @@ func (g *GroupHTTP ) PickNode(key string)(node NodeClient,ok bool){
 	g.mu.Lock()
 	defer g.mu.Unlock()
 
-	if nodeName:=g.nodes.Get(key);nodeName !="" && nodeName != g.addr{
-		g.Log("Pick node %s",nodeName)
-		return g.NodeClientMap[nodeName],true
+	if g.nodes == nil {
+		return nil, false
+	}
+	now := time.Now()
+	ring := g.nodes.GetN(key, len(g.NodeClientMap))
+	for i, nodeName := range ring {
+		if nodeName == g.addr {
+			return nil, false
+		}
+		c := g.NodeClientMap[nodeName]
+		if c.health.ready(now) {
+			g.logger().Debug("pick node", "node", g.addr, "peer", nodeName, "key", key)
+			if g.hedging {
+				return g.hedgedClient(c, ring[i+1:], now), true
+			}
+			return c, true
+		}
+		g.logger().Debug("skip unhealthy node", "node", g.addr, "peer", nodeName, "key", key)
 	}
 	return nil,false
 }
 
+/**
+ * @Description: 返回key在一致性hash上的owner,不跳过熔断中的节点,用于必须由owner执行的CompareAndSet
+ * @receiver g
+ * @param key
+ * @return node owner的客户端,self为true时为nil
+ * @return self 本节点是否是owner,没有设置节点时为true
+ * @return err owner熔断中时为ErrOwnerUnavailable
+ */
+func (g *GroupHTTP) PickOwner(key string) (node NodeClient, self bool, err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.nodes == nil {
+		return nil, true, nil
+	}
+	owners := g.nodes.GetN(key, 1)
+	if len(owners) == 0 || owners[0] == g.addr {
+		return nil, true, nil
+	}
+	c := g.NodeClientMap[owners[0]]
+	if !c.health.ready(time.Now()) {
+		return nil, false, fmt.Errorf("%s: %w", owners[0], ErrOwnerUnavailable)
+	}
+	return c, false, nil
+}
+
+/**
+ * @Description: 构造对冲请求的客户端,对冲到rest中第一个可用的节点,轮到自己时在本地加载
+ * @receiver g
+ * @param primary
+ * @param rest primary之后顺时针的节点
+ * @param now
+ * @return NodeClient
+ */
+func (g *GroupHTTP) hedgedClient(primary *httpClient, rest []string, now time.Time) NodeClient {
+	var secondary NodeClient = localNode{}
+	for _, nodeName := range rest {
+		if nodeName == g.addr {
+			break
+		}
+		if c := g.NodeClientMap[nodeName]; c.health.ready(now) {
+			secondary = c
+			break
+		}
+	}
+	return &hedgedClient{g: g, primary: primary, secondary: secondary, delay: g.hedgeDelay(primary)}
+}
+
 
 
 /**
@@ -97,7 +301,23 @@ func (g *GroupHTTP ) PickNode(key string)(node NodeClient,ok bool){
  * @param r
  */
 func (g *GroupHTTP) ServeHTTP(w http.ResponseWriter, r *http.Request)  {
-	g.Log("%s %s",r.Method,r.URL.Path)
+	g.logger().Debug("serve request", "node", g.addr, "method", r.Method, "path", r.URL.Path)
+	switch r.URL.Path {
+	case defaultMetricsPath:
+		g.serveMetrics(w, r)
+		return
+	case defaultHealthPath:
+		g.serveHealth(w, r)
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, defaultAdminPath) {
+		g.serveAdmin(w, r)
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, defaultClusterPath) {
+		g.serveCluster(w, r)
+		return
+	}
 	if !strings.HasPrefix(r.URL.Path, g.prefix){
 		http.Error(w,"Bad Request",http.StatusBadRequest)
 		return
@@ -112,6 +332,10 @@ func (g *GroupHTTP) ServeHTTP(w http.ResponseWriter, r *http.Request)  {
 	groupName:=parts[0]
 	key:=parts[1]
 
+	if !g.authorize(w, r, groupName) {
+		return
+	}
+
 	//find group by groupName
 	group := GetGroup(groupName)
 	if group ==nil{
@@ -119,14 +343,71 @@ func (g *GroupHTTP) ServeHTTP(w http.ResponseWriter, r *http.Request)  {
 		return
 	}
 
+	group.stats.ServerRequests.Add(1)
+
+	//加入调用方的追踪上下文
+	ctx := r.Context()
+	if sc, err := ParseTraceparent(r.Header.Get(traceparentHeader)); err == nil {
+		ctx = ContextWithSpanContext(ctx, sc)
+	}
+	ctx, span := startSpan(ctx, "cache.serve", "node", g.addr, "group", groupName, "key", key)
+	if sc := span.SpanContext(); sc.IsValid() {
+		w.Header().Set(traceresponseHeader, sc.Traceparent())
+	}
+
+	//其他节点转发过来的写请求,本节点是owner
+	switch r.Method {
+	case http.MethodPut, http.MethodDelete:
+		//没有认证时任何人都可以写入和删除,需要显式开启
+		if g.auth == nil && !g.unauthWrites {
+			span.End(ErrForbidden)
+			http.Error(w, "writes require authentication, see WithAuth", http.StatusForbidden)
+			return
+		}
+		version, err := group.serveWrite(r, key)
+		span.End(err)
+		if err != nil {
+			http.Error(w, err.Error(), statusOf(err))
+			return
+		}
+		if version != 0 {
+			w.Header().Set("ETag", formatETag(version))
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
 	//get view by key from group
-	view,err:=group.Get(key)
+	view,err:=group.GetContext(ctx,key)
+	span.End(err)
 	if err!=nil{
+		//key不存在时返回404,请求方不会再本地加载
+		if errors.Is(err, ErrNotFound) {
+			w.Header().Set(errorHeader, "not_found")
+			http.Error(w,err.Error(),http.StatusNotFound)
+			return
+		}
 		http.Error(w,err.Error(),http.StatusInternalServerError)
 		return
 	}
 
-	body, err := proto.Marshal(&pb.Response{Value: view.Copy()})
+	//请求方的副本和当前版本相同,只返回新的过期时间
+	if v, ok := parseETag(r.Header.Get("If-None-Match")); ok && v == view.version && !view.stale {
+		w.Header().Set("ETag", view.ETag())
+		if !view.expire.IsZero() {
+			w.Header().Set(expireHeader, strconv.FormatInt(view.expire.UnixNano(), 10))
+		}
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	//按请求方可以解压的算法压缩
+	value, encoding := group.encodeForPeer(view.Copy(), r.Header.Get(acceptEncodingHeader))
+	res := &pb.Response{Value: value, Stale: view.stale, Encoding: encoding, Version: view.version}
+	if !view.expire.IsZero() {
+		res.Expire = view.expire.UnixNano()
+	}
+	body, err := proto.Marshal(res)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -139,13 +420,25 @@ func (g *GroupHTTP) ServeHTTP(w http.ResponseWriter, r *http.Request)  {
 
 
 /**
- * @Description: 日志辅助函数
+ * @Description: 日志辅助函数,以Debug级别输出
  * @receiver g
  * @param format
  * @param args
  */
 func (g *GroupHTTP) Log(format string, args ...interface{}){
-	log.Printf("[GroupHTTP %s] %s", g.addr, fmt.Sprintf(format, args...))
+	g.logger().Debug(fmt.Sprintf(format, args...), "node", g.addr)
+}
+
+/**
+ * @Description: 返回GroupHTTP使用的Logger
+ * @receiver g
+ * @return Logger
+ */
+func (g *GroupHTTP) logger() Logger {
+	if g.log != nil {
+		return g.log
+	}
+	return GetLogger()
 }
 
 
@@ -156,27 +449,214 @@ func (g *GroupHTTP) Log(format string, args ...interface{}){
  */
 type httpClient struct {
 	baseURL string
+	client  *http.Client  //所有节点共用的http客户端
+	timeout time.Duration //单次请求的超时时间,<=0表示不超时
+	retries int           //临时性错误的重试次数
+	backoff time.Duration //第一次重试前的退避时间,之后指数增长
+	health  *peerHealth   //熔断器
+	auth    Authenticator //请求签名
+	latency *histogram //请求耗时
+	errors  AtomicInt  //请求失败次数
 }
 
 /**
  * @Description: 通过HTTP协议访问节点的HTTPServer的节点客户端实现
  * @receiver h
- * @param group
- * @param key
- * @return []byte
+ * @param ctx
+ * @param in
+ * @param out
  * @return error
  */
-func (h *httpClient)Get(in *pb.Request,out *pb.Response)error{
+func (h *httpClient)Get(ctx context.Context,in *pb.Request,out *pb.Response)(err error){
 	//包装Get请求
-	u:=fmt.Sprintf("%v%v/%v",h.baseURL,url.QueryEscape(in.GetGroup()),url.QueryEscape(in.GetKey()))
-	res,err := http.Get(u)
+	u:=fmt.Sprintf("%v%v/%v",h.baseURL,url.PathEscape(in.GetGroup()),url.PathEscape(in.GetKey()))
+	for attempt := 0; ; attempt++ {
+		//选择节点时只检查了熔断器,发送前才占用,重试时熔断器可能已经打开
+		if err = h.acquire(); err != nil {
+			return err
+		}
+		err = h.do(ctx, u, in.GetVersion(), out)
+		//调用方取消了请求,不是节点的问题
+		if ctx.Err() != nil {
+			return err
+		}
+		if h.health != nil {
+			if isTransient(err) {
+				h.health.failure(err, time.Now())
+			} else {
+				h.health.success()
+			}
+		}
+		if !isTransient(err) || attempt >= h.retries {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff(h.backoff, attempt)):
+		}
+	}
+}
+
+/**
+ * @Description: 把写请求转发给节点,由节点写入数据源并更新缓存,不重试
+ * @receiver h
+ * @param ctx
+ * @param in
+ * @param value
+ * @return error
+ */
+func (h *httpClient) Set(ctx context.Context, in *pb.Request, value []byte, out *pb.Response) error {
+	return h.write(ctx, http.MethodPut, in, value, out)
+}
+
+/**
+ * @Description: 把删除请求转发给节点,由节点从数据源删除并删除缓存,不重试
+ * @receiver h
+ * @param ctx
+ * @param in
+ * @return error
+ */
+func (h *httpClient) Delete(ctx context.Context, in *pb.Request) error {
+	return h.write(ctx, http.MethodDelete, in, nil, &pb.Response{})
+}
+
+func (h *httpClient) write(ctx context.Context, method string, in *pb.Request, value []byte, out *pb.Response) (err error) {
+	if err := h.acquire(); err != nil {
+		return err
+	}
+	parent := ctx
+	if h.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
+	defer func() {
+		if err != nil {
+			h.errors.Add(1)
+		}
+		//写请求也要记录结果,否则半开状态下的试探请求一直没有结论
+		if h.health == nil || parent.Err() != nil {
+			return
+		}
+		if isTransient(err) {
+			h.health.failure(err, time.Now())
+		} else {
+			h.health.success()
+		}
+	}()
+	u := fmt.Sprintf("%v%v/%v", h.baseURL, url.PathEscape(in.GetGroup()), url.PathEscape(in.GetKey()))
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(value))
+	if err != nil {
+		return err
+	}
+	if sc, ok := SpanContextFromContext(ctx); ok {
+		req.Header.Set(traceparentHeader, sc.Traceparent())
+	}
+	switch {
+	case in.GetCompare() && in.GetVersion() == 0:
+		req.Header.Set("If-None-Match", "*")
+	case in.GetCompare():
+		req.Header.Set("If-Match", formatETag(in.GetVersion()))
+	}
+	if h.auth != nil {
+		if err := h.auth.Sign(req); err != nil {
+			return err
+		}
+	}
+	res, err := h.httpClient().Do(req)
+	if err != nil {
+		return &peerError{err: err, transient: true}
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusPreconditionFailed {
+		msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+		return &peerError{status: res.StatusCode, err: fmt.Errorf("%s (%w)", strings.TrimSpace(string(msg)), ErrVersionConflict)}
+	}
+	if res.StatusCode != http.StatusNoContent {
+		msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+		return &peerError{
+			status:    res.StatusCode,
+			err:       fmt.Errorf("server returned:%v: %s", res.Status, strings.TrimSpace(string(msg))),
+			transient: transientStatus(res.StatusCode),
+		}
+	}
+	out.Version, _ = parseETag(res.Header.Get("ETag"))
+	return nil
+}
+
+/**
+ * @Description: 返回请求使用的http客户端
+ * @receiver h
+ * @return *http.Client
+ */
+func (h *httpClient) httpClient() *http.Client {
+	if h.client == nil {
+		return http.DefaultClient
+	}
+	return h.client
+}
+
+/**
+ * @Description: 发送一次请求,网络错误,超时以及502/503/504返回可以重试的peerError
+ * version不为0时发送条件请求,owner的版本相同时out.NotModified为true,只有过期时间
+ * @receiver h
+ * @param ctx
+ * @param u
+ * @param version 请求方已有的版本
+ * @param out
+ * @return error
+ */
+func (h *httpClient) do(ctx context.Context, u string, version uint64, out *pb.Response) (err error) {
+	if h.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
+	start := time.Now()
+	defer func() {
+		h.latency.observe(time.Since(start))
+		if err != nil {
+			h.errors.Add(1)
+		}
+	}()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
 	if err != nil {
 		return err
 	}
+	//传递追踪上下文
+	if sc, ok := SpanContextFromContext(ctx); ok {
+		req.Header.Set(traceparentHeader, sc.Traceparent())
+	}
+	req.Header.Set(acceptEncodingHeader, acceptEncodings())
+	if version != 0 {
+		req.Header.Set("If-None-Match", formatETag(version))
+	}
+	if h.auth != nil {
+		if err := h.auth.Sign(req); err != nil {
+			return err
+		}
+	}
+	res,err := h.httpClient().Do(req)
+	if err != nil {
+		return &peerError{err: err, transient: true}
+	}
 	defer res.Body.Close()
 
+	if res.StatusCode == http.StatusNotFound && res.Header.Get(errorHeader) == "not_found" {
+		return &peerError{status: res.StatusCode, err: ErrNotFound}
+	}
+	if res.StatusCode == http.StatusNotModified && version != 0 {
+		out.NotModified, out.Version = true, version
+		out.Expire, _ = strconv.ParseInt(res.Header.Get(expireHeader), 10, 64)
+		return nil
+	}
 	if res.StatusCode!=http.StatusOK{
-		return fmt.Errorf("server returned:%v",res.Status)
+		return &peerError{
+			status:    res.StatusCode,
+			err:       fmt.Errorf("server returned:%v",res.Status),
+			transient: transientStatus(res.StatusCode),
+		}
 	}
 
 	bytes,err := ioutil.ReadAll(res.Body)//这里是空的
@@ -0,0 +1,264 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pb "cache/cachepb"
+	"google.golang.org/protobuf/proto"
+)
+
+/**
+ * @Description: 计数的RoundTripper,返回固定的响应
+ */
+type countingTransport struct {
+	requests int32
+}
+
+func (c *countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&c.requests, 1)
+	body, _ := proto.Marshal(&pb.Response{Value: []byte(r.URL.Path)})
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Status:     "200 OK",
+		Body:       ioutil.NopCloser(bytes.NewReader(body)),
+		Header:     make(http.Header),
+		Request:    r,
+	}, nil
+}
+
+func TestPeerTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	g := NewGroupHTTP("http://self", WithPeerTimeout(20*time.Millisecond))
+	g.Set(server.URL)
+
+	start := time.Now()
+	err := g.NodeClientMap[server.URL].Get(context.Background(), &pb.Request{Group: "g", Key: "k"}, &pb.Response{})
+	if err == nil {
+		t.Fatal("expected timeout error")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("request should time out quickly, took %v", d)
+	}
+}
+
+func TestCustomTransport(t *testing.T) {
+	rt := &countingTransport{}
+	g := NewGroupHTTP("http://self", WithTransport(rt))
+	g.Set("http://node1", "http://node2")
+
+	for name, c := range g.NodeClientMap {
+		res := &pb.Response{}
+		if err := c.Get(context.Background(), &pb.Request{Group: "g", Key: "k"}, res); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if string(res.Value) != "/cache/g/k" {
+			t.Fatalf("%s: unexpected value %q", name, res.Value)
+		}
+	}
+	if n := atomic.LoadInt32(&rt.requests); n != 2 {
+		t.Fatalf("expected every peer to use the transport, got %d requests", n)
+	}
+}
+
+func TestTransportOptions(t *testing.T) {
+	g := NewGroupHTTP("http://self", WithMaxIdleConnsPerPeer(4), WithIdleConnTimeout(time.Minute))
+	g.Set("http://node1", "http://node2")
+
+	tr, ok := g.client.Transport.(*http.Transport)
+	if !ok || tr.MaxIdleConnsPerHost != 4 || tr.IdleConnTimeout != time.Minute || tr.DisableKeepAlives {
+		t.Fatalf("unexpected transport %+v", g.client.Transport)
+	}
+	for name, c := range g.NodeClientMap {
+		if c.client != g.client || c.timeout != defaultPeerTimeout {
+			t.Fatalf("%s: peer client should share the configured http.Client", name)
+		}
+	}
+
+	custom := &http.Client{}
+	if g := NewGroupHTTP("http://self", WithHTTPClient(custom)); g.client != custom {
+		t.Fatal("custom http.Client should be used as is")
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	h := newPeerHealth(2, time.Second)
+	failure := errors.New("down")
+
+	h.failure(failure, now)
+	if !h.acquire(now) {
+		t.Fatal("breaker should stay closed below threshold")
+	}
+	h.failure(failure, now)
+	if h.ready(now) || h.acquire(now) || h.status("p").State != BreakerOpen {
+		t.Fatal("breaker should open after threshold failures")
+	}
+
+	//冷却结束,ready只检查,不会让熔断器进入半开状态,也不占用试探请求
+	now = now.Add(time.Second)
+	if !h.ready(now) || !h.ready(now) || h.status("p").State != BreakerOpen {
+		t.Fatal("ready should not change the breaker state")
+	}
+
+	//冷却结束,只允许一个试探请求
+	if !h.acquire(now) || h.ready(now) || h.acquire(now) || h.status("p").State != BreakerHalfOpen {
+		t.Fatal("half-open breaker should allow exactly one trial")
+	}
+	h.failure(failure, now)
+	if h.acquire(now) || h.status("p").State != BreakerOpen {
+		t.Fatal("failed trial should reopen the breaker")
+	}
+
+	now = now.Add(time.Second)
+	h.acquire(now)
+	h.success()
+	if s := h.status("p"); s.State != BreakerClosed || s.ConsecutiveFailures != 0 || s.LastError != "down" {
+		t.Fatalf("successful trial should close the breaker, got %+v", s)
+	}
+}
+
+func TestPickNodeSkipsOpenPeer(t *testing.T) {
+	g := NewGroupHTTP("http://self", WithCircuitBreaker(1, time.Minute))
+	g.Set("http://self", "http://node1", "http://node2")
+
+	//找到一个owner不是自己,并且下一个节点也不是自己的key
+	var key string
+	var ring []string
+	for i := 0; i < 1000; i++ {
+		k := strconv.Itoa(i)
+		if ring = g.nodes.GetN(k, 3); ring[0] != "http://self" && ring[1] != "http://self" {
+			key = k
+			break
+		}
+	}
+	if key == "" {
+		t.Fatal("no suitable key found")
+	}
+
+	if node, ok := g.PickNode(key); !ok || node != g.NodeClientMap[ring[0]] {
+		t.Fatalf("expected owner %s to be picked", ring[0])
+	}
+	g.NodeClientMap[ring[0]].health.failure(errors.New("down"), time.Now())
+	if node, ok := g.PickNode(key); !ok || node != g.NodeClientMap[ring[1]] {
+		t.Fatalf("expected next node %s to be picked when owner is open", ring[1])
+	}
+	g.NodeClientMap[ring[1]].health.failure(errors.New("down"), time.Now())
+	if _, ok := g.PickNode(key); ok {
+		t.Fatal("expected local load when every peer before self is open")
+	}
+
+	statuses := g.PeersHealth()
+	if len(statuses) != 3 {
+		t.Fatalf("unexpected peers health %+v", statuses)
+	}
+}
+
+func TestPickDoesNotConsumeTrial(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		body, _ := proto.Marshal(&pb.Response{Value: []byte("v")})
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	g := NewGroupHTTP("http://self", WithCircuitBreaker(1, time.Minute))
+	g.Set(server.URL)
+	c := g.NodeClientMap[server.URL]
+	c.health.failure(errors.New("down"), time.Now().Add(-time.Minute))
+
+	//选择节点和判断owner不占用半开状态下的试探请求
+	for i := 0; i < 3; i++ {
+		if _, ok := g.PickNode("k"); !ok {
+			t.Fatal("expected the cooled down peer to be picked")
+		}
+		if _, self, err := g.PickOwner("k"); self || err != nil {
+			t.Fatalf("expected the cooled down peer to own the key, err=%v", err)
+		}
+	}
+	if s := c.health.status(server.URL); s.State != BreakerOpen {
+		t.Fatalf("picking should not change the breaker state, got %v", s.State)
+	}
+
+	//真正发送的请求占用试探,成功后关闭熔断器
+	node, _ := g.PickNode("k")
+	if err := node.Get(context.Background(), &pb.Request{Group: "g", Key: "k"}, &pb.Response{}); err != nil {
+		t.Fatal(err)
+	}
+	if s := c.health.status(server.URL); s.State != BreakerClosed || atomic.LoadInt32(&requests) != 1 {
+		t.Fatalf("expected the trial request to close the breaker, got %v after %d requests", s.State, requests)
+	}
+}
+
+func TestPeerRetry(t *testing.T) {
+	var requests, notFound int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&notFound) == 1 {
+			atomic.AddInt32(&requests, 1)
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		if atomic.AddInt32(&requests, 1) <= 2 {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := proto.Marshal(&pb.Response{Value: []byte("v")})
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	g := NewGroupHTTP("http://self", WithPeerRetry(2, time.Millisecond))
+	g.Set(server.URL)
+	res := &pb.Response{}
+	if err := g.NodeClientMap[server.URL].Get(context.Background(), &pb.Request{Group: "g", Key: "k"}, res); err != nil {
+		t.Fatal(err)
+	}
+	if string(res.Value) != "v" || atomic.LoadInt32(&requests) != 3 {
+		t.Fatalf("expected success after 2 retries, got %q after %d requests", res.Value, requests)
+	}
+	if s := g.PeersHealth()[0]; s.State != BreakerClosed || s.ConsecutiveFailures != 0 {
+		t.Fatalf("successful retry should reset failures, got %+v", s)
+	}
+
+	//非临时性错误不重试
+	atomic.StoreInt32(&requests, 10)
+	atomic.StoreInt32(&notFound, 1)
+	if err := g.NodeClientMap[server.URL].Get(context.Background(), &pb.Request{Group: "g", Key: "k"}, res); err == nil || atomic.LoadInt32(&requests) != 11 {
+		t.Fatalf("non transient error should not be retried, err=%v requests=%d", err, requests)
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	peer := NewGroupHTTP("http://peer")
+	server := httptest.NewServer(peer)
+	defer server.Close()
+
+	g := NewGroupHTTP("http://self", WithCircuitBreaker(1, time.Hour), WithHealthCheck(5*time.Millisecond))
+	defer g.Close()
+	g.Set("http://self", server.URL)
+	g.NodeClientMap[server.URL].health.failure(errors.New("down"), time.Now())
+
+	waitFor(t, func() bool {
+		for _, s := range g.PeersHealth() {
+			if s.Addr == server.URL {
+				return s.State == BreakerHalfOpen
+			}
+		}
+		return false
+	})
+}
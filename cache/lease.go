@@ -0,0 +1,252 @@
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+/**
+ * @Description: memcache风格的租约,防止失效后正在进行的加载把旧值写回缓存
+ * 未命中的加载先领取key的租约令牌,加载完成后只有令牌仍然有效时才写入缓存;
+ * Remove,Delete,Set和Purge使未完成的租约失效,之后这些加载的结果只返回给调用方,不再缓存
+ * 开启 WithLeaseStale 时,失效前的旧值在一小段时间内可以返回给等待租约持有者的其他调用方
+ */
+
+const leaseSweepMin = 64 //清理过期旧值的最小条目数
+
+/**
+ * @Description: 一个key的租约
+ */
+type lease struct {
+	token      uint64   //当前有效的令牌,0表示没有
+	holders    int      //持有当前令牌,还没有完成加载的个数
+	stale      ByteView //失效前的旧值,已经压缩加密
+	staleUntil time.Time
+}
+
+/**
+ * @Description: group中所有key的租约
+ */
+type leaseTable struct {
+	mu      sync.Mutex
+	seq     uint64
+	leases  map[string]*lease
+	sweepAt int //条目数达到该值时清理过期的旧值
+}
+
+/**
+ * @Description: 领取key的租约,已经有有效的令牌时共用同一个令牌
+ * @receiver t
+ * @param key
+ * @return uint64 令牌
+ */
+func (t *leaseTable) acquire(key string) uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.leases == nil {
+		t.leases = make(map[string]*lease)
+	}
+	l := t.leases[key]
+	if l == nil {
+		l = &lease{}
+		t.leases[key] = l
+	}
+	if l.token == 0 {
+		t.seq++
+		l.token = t.seq
+	}
+	l.holders++
+	return l.token
+}
+
+/**
+ * @Description: 加载结束后归还租约,已经失效的令牌直接忽略
+ * @receiver t
+ * @param key
+ * @param token
+ */
+func (t *leaseTable) release(key string, token uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	l := t.leases[key]
+	if l == nil || l.token != token {
+		return
+	}
+	l.holders--
+	if l.holders > 0 {
+		return
+	}
+	l.token = 0
+	//还有旧值时留给sweep清理
+	if l.staleUntil.IsZero() {
+		delete(t.leases, key)
+	}
+}
+
+/**
+ * @Description: 令牌仍然有效时执行fn写入缓存,持有锁执行,不会和invalidate交错
+ * 锁是整个group共用的,fn只做c.add这样很快的操作,压缩加密在调用前完成
+ * @receiver t
+ * @param key
+ * @param token
+ * @param fn
+ * @return bool 令牌是否有效
+ */
+func (t *leaseTable) fill(key string, token uint64, fn func()) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	l := t.leases[key]
+	if l == nil || l.token != token {
+		return false
+	}
+	fn()
+	//已经有了新值
+	l.stale, l.staleUntil = ByteView{}, time.Time{}
+	return true
+}
+
+/**
+ * @Description: 使key未完成的租约失效,staleFor大于0时保留失效前的旧值
+ * @receiver t
+ * @param key
+ * @param old 失效前的旧值,ok为false时没有
+ * @param ok
+ * @param staleFor 旧值可以返回的时间
+ */
+func (t *leaseTable) invalidate(key string, old ByteView, ok bool, staleFor time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := timeNow()
+	if !ok || staleFor <= 0 {
+		delete(t.leases, key)
+		return
+	}
+	if t.leases == nil {
+		t.leases = make(map[string]*lease)
+	}
+	t.leases[key] = &lease{stale: old, staleUntil: now.Add(staleFor)}
+	t.sweep(now)
+}
+
+/**
+ * @Description: 清理没有持有者并且旧值已经过期的条目,条目数翻倍时才执行
+ * @receiver t
+ * @param now
+ */
+func (t *leaseTable) sweep(now time.Time) {
+	if len(t.leases) < t.sweepAt {
+		return
+	}
+	for key, l := range t.leases {
+		if l.holders == 0 && !now.Before(l.staleUntil) {
+			delete(t.leases, key)
+		}
+	}
+	t.sweepAt = 2 * len(t.leases)
+	if t.sweepAt < leaseSweepMin {
+		t.sweepAt = leaseSweepMin
+	}
+}
+
+/**
+ * @Description: 使所有未完成的租约失效,用于Purge
+ * @receiver t
+ */
+func (t *leaseTable) invalidateAll() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.leases = nil
+	t.sweepAt = 0
+}
+
+/**
+ * @Description: 有其他调用方持有租约正在加载时,返回失效前还没有过期的旧值
+ * @receiver t
+ * @param key
+ * @return ByteView
+ * @return bool
+ */
+func (t *leaseTable) staleValue(key string) (ByteView, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	l := t.leases[key]
+	if l == nil || l.holders == 0 || !timeNow().Before(l.staleUntil) {
+		return ByteView{}, false
+	}
+	return l.stale, true
+}
+
+/**
+ * @Description: 使key的租约失效,开启了WithLeaseStale时保留本节点缓存中的旧值
+ * 同时让之后的Get不再等待正在进行的加载,而是重新加载
+ * @receiver g
+ * @param key
+ */
+func (g *Group) invalidate(key string) {
+	var old ByteView
+	ok := false
+	if g.leaseStale > 0 {
+		if old, ok = g.cache.peek(key); !ok {
+			old, ok = g.remoteCache.peek(key)
+		}
+	}
+	g.leases.invalidate(key, old, ok, g.leaseStale)
+	g.loader.Forget(key)
+}
+
+/**
+ * @Description: 令牌仍然有效时执行fn,否则丢弃加载到的值
+ * @receiver g
+ * @param key
+ * @param token
+ * @param fn 持有租约的锁执行,不能做耗时的操作
+ * @return bool 令牌是否有效
+ */
+func (g *Group) fill(key string, token uint64, fn func()) bool {
+	if !g.leases.fill(key, token, fn) {
+		g.stats.LeaseRejects.Add(1)
+		g.logger().Debug("lease invalidated during load, skip caching", "group", g.name, "key", key)
+		return false
+	}
+	return true
+}
+
+/**
+ * @Description: 令牌仍然有效时把v写入c,在持有租约的锁之前压缩加密,见 Group.store
+ * @receiver g
+ * @param c
+ * @param key
+ * @param token
+ * @param v
+ */
+func (g *Group) fillValue(c *cache, key string, token uint64, v ByteView) {
+	packed, err := g.pack(key, v)
+	if err != nil {
+		g.logger().Warn("failed to pack value, skip caching", "group", g.name, "key", key, "err", err)
+		return
+	}
+	g.fill(key, token, func() { c.add(key, packed) })
+}
+
+/**
+ * @Description: 其他调用方正在加载key时返回失效前的旧值,返回的值ByteView.Stale()为true
+ * @receiver g
+ * @param key
+ * @return ByteView
+ * @return bool
+ */
+func (g *Group) leaseStaleValue(key string) (ByteView, bool) {
+	if g.leaseStale <= 0 {
+		return ByteView{}, false
+	}
+	v, ok := g.leases.staleValue(key)
+	if !ok {
+		return ByteView{}, false
+	}
+	v, err := g.unpack(key, v)
+	if err != nil {
+		return ByteView{}, false
+	}
+	v.stale = true
+	return v, true
+}
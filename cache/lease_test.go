@@ -0,0 +1,134 @@
+package cache
+
+import (
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 加载时阻塞,直到测试放行
+type blockingGetter struct {
+	loads   int32
+	started chan struct{}
+	proceed chan struct{}
+}
+
+func newBlockingGetter() *blockingGetter {
+	return &blockingGetter{started: make(chan struct{}, 1), proceed: make(chan struct{})}
+}
+
+func (b *blockingGetter) Get(key string) ([]byte, error) {
+	n := atomic.AddInt32(&b.loads, 1)
+	b.started <- struct{}{}
+	<-b.proceed
+	return []byte(key + strconv.Itoa(int(n))), nil
+}
+
+// 在后台Get,返回结果的chan
+func getAsync(g *Group, key string) chan ByteView {
+	ch := make(chan ByteView, 1)
+	go func() {
+		v, _ := g.Get(key)
+		ch <- v
+	}()
+	return ch
+}
+
+func TestLeaseInvalidatesInflightLoad(t *testing.T) {
+	getter := newBlockingGetter()
+	g := NewGroup("lease-remove", 1<<10, getter)
+
+	//加载期间被删除,加载到的旧值只返回给调用方,不写回缓存
+	res := getAsync(g, "k")
+	<-getter.started
+	g.Remove("k")
+	getter.proceed <- struct{}{}
+	if v := <-res; v.String() != "k1" {
+		t.Fatalf("expected the caller to get k1, got %q", v)
+	}
+	if v, ok := g.peek("k"); ok {
+		t.Fatalf("expected no cached value after remove, got %q", v)
+	}
+	if n := g.Stats().LeaseRejects; n != 1 {
+		t.Fatalf("expected 1 rejected fill, got %d", n)
+	}
+
+	//加载期间Set的新值不会被覆盖
+	res = getAsync(g, "k")
+	<-getter.started
+	if err := g.Set("k", []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	getter.proceed <- struct{}{}
+	<-res
+	if v, _ := g.peek("k"); v.String() != "new" {
+		t.Fatalf("expected the set value to survive the load, got %q", v)
+	}
+
+	//没有失效时正常缓存
+	g.Purge()
+	res = getAsync(g, "k")
+	<-getter.started
+	getter.proceed <- struct{}{}
+	<-res
+	if v, ok := g.peek("k"); !ok || v.String() != "k3" {
+		t.Fatalf("expected k3 to be cached, got %q", v)
+	}
+}
+
+func TestLeaseDetachesInflightLoad(t *testing.T) {
+	getter := newBlockingGetter()
+	g := NewGroup("lease-detach", 1<<10, getter)
+
+	//删除之后的Get不等待删除之前开始的加载,而是重新加载
+	first := getAsync(g, "k")
+	<-getter.started
+	g.Remove("k")
+	second := getAsync(g, "k")
+	select {
+	case <-getter.started:
+	case <-time.After(time.Second):
+		t.Fatal("expected a Get after Remove to start a new load")
+	}
+	getter.proceed <- struct{}{}
+	getter.proceed <- struct{}{}
+	if v := <-first; v.String() != "k1" {
+		t.Fatalf("expected the first caller to get k1, got %q", v)
+	}
+	if v := <-second; v.String() != "k2" {
+		t.Fatalf("expected the caller after Remove to get k2, got %q", v)
+	}
+	if v, _ := g.peek("k"); v.String() != "k2" {
+		t.Fatalf("expected k2 to be cached, got %q", v)
+	}
+	waitFor(t, func() bool { return g.loader.Inflight() == 0 })
+}
+
+func TestLeaseStale(t *testing.T) {
+	getter := newBlockingGetter()
+	g := NewGroup("lease-stale", 1<<10, getter, WithLeaseStale(time.Minute))
+	res := getAsync(g, "k")
+	<-getter.started
+	getter.proceed <- struct{}{}
+	<-res
+
+	//持有租约的调用方重新加载时,其他调用方直接返回失效前的旧值
+	g.Remove("k")
+	res = getAsync(g, "k")
+	<-getter.started
+	v, err := g.Get("k")
+	if err != nil || v.String() != "k1" || !v.Stale() {
+		t.Fatalf("expected stale k1, got %q stale=%v err=%v", v, v.Stale(), err)
+	}
+	getter.proceed <- struct{}{}
+	if v := <-res; v.String() != "k2" {
+		t.Fatalf("expected the lease holder to load k2, got %q", v)
+	}
+	if v, err := g.Get("k"); err != nil || v.String() != "k2" || v.Stale() {
+		t.Fatalf("expected fresh k2, got %q stale=%v err=%v", v, v.Stale(), err)
+	}
+	if n := g.Stats().LeaseStaleServes; n != 1 {
+		t.Fatalf("expected 1 stale serve, got %d", n)
+	}
+}
@@ -0,0 +1,154 @@
+package cache
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+/**
+ * @Description: 可插拔的结构化日志,默认不输出任何日志
+ */
+
+/**
+ * @Description: 日志级别
+ */
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	}
+	return fmt.Sprintf("LEVEL(%d)", int(l))
+}
+
+/**
+ * @Description: 日志接口,keysAndValues是成对的键值,如 "group", "test", "key", "1"
+ */
+type Logger interface {
+	Debug(msg string, keysAndValues ...interface{})
+	Info(msg string, keysAndValues ...interface{})
+	Warn(msg string, keysAndValues ...interface{})
+	Error(msg string, keysAndValues ...interface{})
+}
+
+/**
+ * @Description: 不输出任何日志的Logger,作为库的默认值
+ */
+type NopLogger struct{}
+
+func (NopLogger) Debug(msg string, keysAndValues ...interface{}) {}
+func (NopLogger) Info(msg string, keysAndValues ...interface{})  {}
+func (NopLogger) Warn(msg string, keysAndValues ...interface{})  {}
+func (NopLogger) Error(msg string, keysAndValues ...interface{}) {}
+
+//全局Logger,保存的是loggerHolder,保证atomic.Value中的类型一致
+var globalLogger atomic.Value
+
+type loggerHolder struct {
+	Logger
+}
+
+func init() {
+	globalLogger.Store(loggerHolder{NopLogger{}})
+}
+
+/**
+ * @Description: 设置全局Logger,没有单独设置Logger的Group和GroupHTTP都会使用它,nil表示不输出日志
+ * @param l
+ */
+func SetLogger(l Logger) {
+	if l == nil {
+		l = NopLogger{}
+	}
+	globalLogger.Store(loggerHolder{l})
+}
+
+/**
+ * @Description: 返回全局Logger
+ * @return Logger
+ */
+func GetLogger() Logger {
+	return globalLogger.Load().(loggerHolder).Logger
+}
+
+/**
+ * @Description: 基于标准库log的Logger,低于level的日志会被忽略
+ * 输出格式为: LEVEL msg key=value key=value
+ */
+type StdLogger struct {
+	l     *log.Logger
+	level Level
+}
+
+/**
+ * @Description: 新建一个StdLogger,l为nil时使用log的默认Logger
+ * @param l
+ * @param level
+ * @return *StdLogger
+ */
+func NewStdLogger(l *log.Logger, level Level) *StdLogger {
+	if l == nil {
+		l = log.New(log.Writer(), log.Prefix(), log.Flags())
+	}
+	return &StdLogger{l: l, level: level}
+}
+
+func (s *StdLogger) Debug(msg string, keysAndValues ...interface{}) {
+	s.log(LevelDebug, msg, keysAndValues)
+}
+
+func (s *StdLogger) Info(msg string, keysAndValues ...interface{}) {
+	s.log(LevelInfo, msg, keysAndValues)
+}
+
+func (s *StdLogger) Warn(msg string, keysAndValues ...interface{}) {
+	s.log(LevelWarn, msg, keysAndValues)
+}
+
+func (s *StdLogger) Error(msg string, keysAndValues ...interface{}) {
+	s.log(LevelError, msg, keysAndValues)
+}
+
+func (s *StdLogger) log(level Level, msg string, keysAndValues []interface{}) {
+	if level < s.level {
+		return
+	}
+	var b strings.Builder
+	b.WriteString(level.String())
+	b.WriteByte(' ')
+	b.WriteString(msg)
+	for i := 0; i < len(keysAndValues); i += 2 {
+		b.WriteByte(' ')
+		if i+1 == len(keysAndValues) {
+			//落单的值
+			fmt.Fprintf(&b, "!BADKEY=%s", formatLogValue(keysAndValues[i]))
+			break
+		}
+		fmt.Fprintf(&b, "%v=%s", keysAndValues[i], formatLogValue(keysAndValues[i+1]))
+	}
+	s.l.Output(3, b.String())
+}
+
+func formatLogValue(v interface{}) string {
+	s := fmt.Sprint(v)
+	if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+		return fmt.Sprintf("%q", s)
+	}
+	return s
+}
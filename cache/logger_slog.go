@@ -0,0 +1,41 @@
+//go:build go1.21
+// +build go1.21
+
+package cache
+
+import "log/slog"
+
+/**
+ * @Description: 将log/slog适配为Logger
+ */
+type SlogLogger struct {
+	l *slog.Logger
+}
+
+/**
+ * @Description: 新建一个SlogLogger,l为nil时使用slog.Default()
+ * @param l
+ * @return *SlogLogger
+ */
+func NewSlogLogger(l *slog.Logger) *SlogLogger {
+	if l == nil {
+		l = slog.Default()
+	}
+	return &SlogLogger{l: l}
+}
+
+func (s *SlogLogger) Debug(msg string, keysAndValues ...interface{}) {
+	s.l.Debug(msg, keysAndValues...)
+}
+
+func (s *SlogLogger) Info(msg string, keysAndValues ...interface{}) {
+	s.l.Info(msg, keysAndValues...)
+}
+
+func (s *SlogLogger) Warn(msg string, keysAndValues ...interface{}) {
+	s.l.Warn(msg, keysAndValues...)
+}
+
+func (s *SlogLogger) Error(msg string, keysAndValues ...interface{}) {
+	s.l.Error(msg, keysAndValues...)
+}
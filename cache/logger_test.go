@@ -0,0 +1,91 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+
+	pb "cache/cachepb"
+)
+
+/**
+ * @Description: 记录日志的Logger,用于测试
+ */
+type recordLogger struct {
+	mu      sync.Mutex
+	entries []string
+}
+
+func (r *recordLogger) record(level Level, msg string, kv []interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = append(r.entries, fmt.Sprint(level, " ", msg, " ", kv))
+}
+
+func (r *recordLogger) Debug(msg string, kv ...interface{}) { r.record(LevelDebug, msg, kv) }
+func (r *recordLogger) Info(msg string, kv ...interface{})  { r.record(LevelInfo, msg, kv) }
+func (r *recordLogger) Warn(msg string, kv ...interface{})  { r.record(LevelWarn, msg, kv) }
+func (r *recordLogger) Error(msg string, kv ...interface{}) { r.record(LevelError, msg, kv) }
+
+type failingNode struct{}
+
+func (failingNode) Get(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	return errors.New("peer is down")
+}
+
+type fixedPicker struct{ node NodeClient }
+
+func (p fixedPicker) PickNode(key string) (NodeClient, bool) { return p.node, true }
+
+func TestStdLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewStdLogger(log.New(&buf, "", 0), LevelInfo)
+	l.Debug("hidden", "k", "v")
+	l.Info("load", "group", "test", "err", errors.New("db is down"), "odd")
+
+	expect := "INFO load group=test err=\"db is down\" !BADKEY=odd\n"
+	if buf.String() != expect {
+		t.Fatalf("expected %q, got %q", expect, buf.String())
+	}
+}
+
+func TestGroupLogger(t *testing.T) {
+	rec := &recordLogger{}
+	global := &recordLogger{}
+	SetLogger(global)
+	defer SetLogger(nil)
+
+	g := NewGroup("logger", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}), WithLogger(rec))
+	g.Register(fixedPicker{failingNode{}})
+
+	if v, err := g.Get("k"); err != nil || v.String() != "k" {
+		t.Fatalf("expected local fallback, got %q err=%v", v, err)
+	}
+	if len(rec.entries) != 1 || !strings.HasPrefix(rec.entries[0], "WARN failed to get from peer") {
+		t.Fatalf("unexpected group log entries %v", rec.entries)
+	}
+	if len(global.entries) != 0 {
+		t.Fatalf("global logger should not be used, got %v", global.entries)
+	}
+}
+
+func TestGlobalLogger(t *testing.T) {
+	if _, ok := GetLogger().(NopLogger); !ok {
+		t.Fatalf("default logger should be NopLogger, got %T", GetLogger())
+	}
+	rec := &recordLogger{}
+	SetLogger(rec)
+	defer SetLogger(nil)
+
+	NewGroupHTTP("http://node1").Log("hello %s", "world")
+	if len(rec.entries) != 1 || !strings.HasPrefix(rec.entries[0], "DEBUG hello world") {
+		t.Fatalf("unexpected log entries %v", rec.entries)
+	}
+}
@@ -1,6 +1,9 @@
 package lru
 
-import "container/list"
+import (
+	"container/list"
+	"time"
+)
 
 /**
  * @Description: LRU链表
@@ -26,6 +29,7 @@ type Value interface {
 type entry struct {
 	key   string
 	value Value
+	added time.Time //添加或者更新的时间
 }
 
 func New(maxBytes int64, onDelete func(string, Value)) *LRU {
@@ -54,9 +58,10 @@ func (lru *LRU) Add(key string, value Value) {
 		kValue := element.Value.(*entry)
 		lru.usedbytes += int64(value.Len()) - int64(kValue.value.Len())
 		kValue.value = value
+		kValue.added = time.Now()
 	} else {
 		//不存在就新增加，向队列添加新节点，并且向map添加映射关系，最后更新usedbytes
-		element := lru.doublyLinkedList.PushBack(&entry{key, value})
+		element := lru.doublyLinkedList.PushBack(&entry{key, value, time.Now()})
 		lru.searchMap[key] = element
 		lru.usedbytes += int64(len(key)) + int64(value.Len())
 	}
@@ -70,19 +75,47 @@ func (lru *LRU) Remove() {
 	//删除队首元素
 	element := lru.doublyLinkedList.Front()
 	if element != nil {
-		lru.doublyLinkedList.Remove(element)
-		kValue := element.Value.(*entry)
-		//删除映射关系
-		delete(lru.searchMap, kValue.key)
-		//更新已用内存数
-		lru.usedbytes -= int64(len(kValue.key)) + int64(kValue.value.Len())
-		//调用回调函数
-		if lru.onDelete != nil {
-			lru.onDelete(kValue.key, kValue.value)
-		}
+		lru.removeElement(element)
 	}
 }
 
+/**
+ * @Description: 删除指定的key,同样会调用回调函数
+ * @receiver lru
+ * @param key
+ * @return bool 是否存在该key
+ */
+func (lru *LRU) Delete(key string) bool {
+	element, ok := lru.searchMap[key]
+	if !ok {
+		return false
+	}
+	lru.removeElement(element)
+	return true
+}
+
+func (lru *LRU) removeElement(element *list.Element) {
+	lru.doublyLinkedList.Remove(element)
+	kValue := element.Value.(*entry)
+	//删除映射关系
+	delete(lru.searchMap, kValue.key)
+	//更新已用内存数
+	lru.usedbytes -= int64(len(kValue.key)) + int64(kValue.value.Len())
+	//调用回调函数
+	if lru.onDelete != nil {
+		lru.onDelete(kValue.key, kValue.value)
+	}
+}
+
+/**
+ * @Description: 当前已经使用的内存
+ * @receiver lru
+ * @return int64
+ */
+func (lru *LRU) UsedBytes() int64 {
+	return lru.usedbytes
+}
+
 /**
  * @Description: 双链表的长度
  * @receiver lru
@@ -90,4 +123,18 @@ func (lru *LRU) Remove() {
  */
 func (lru *LRU) Len() int{
 	return lru.doublyLinkedList.Len()
-}
\ No newline at end of file
+}
+
+/**
+ * @Description: 从最近使用到最久未使用遍历所有的键值对,不改变顺序,fn返回false时停止
+ * @receiver lru
+ * @param fn added为键值对添加或者更新的时间
+ */
+func (lru *LRU) Range(fn func(key string, value Value, added time.Time) bool) {
+	for element := lru.doublyLinkedList.Back(); element != nil; element = element.Prev() {
+		kValue := element.Value.(*entry)
+		if !fn(kValue.key, kValue.value, kValue.added) {
+			return
+		}
+	}
+}
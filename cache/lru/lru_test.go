@@ -3,6 +3,7 @@ package lru
 import (
 	"reflect"
 	"testing"
+	"time"
 )
 
 /**
@@ -66,3 +67,45 @@ func TestAdd(t *testing.T) {
 		t.Fatal("expected 6 but got", lru.usedbytes)
 	}
 }
+
+func TestDelete(t *testing.T) {
+	lru := New(int64(0), nil)
+	lru.Add("key1", String("1234"))
+	lru.Add("key2", String("5678"))
+
+	if !lru.Delete("key1") || lru.Len() != 1 {
+		t.Fatalf("delete key1 failed")
+	}
+	if _, ok := lru.Get("key1"); ok {
+		t.Fatalf("key1 should be deleted")
+	}
+	if lru.Delete("key1") {
+		t.Fatalf("delete a missing key should return false")
+	}
+	if lru.usedbytes != int64(len("key2")+len("5678")) {
+		t.Fatal("expected 8 but got", lru.usedbytes)
+	}
+}
+
+func TestRange(t *testing.T) {
+	lru := New(int64(0), nil)
+	lru.Add("key1", String("1"))
+	lru.Add("key2", String("2"))
+	lru.Add("key3", String("3"))
+	lru.Get("key1")
+
+	var keys []string
+	lru.Range(func(key string, value Value, added time.Time) bool {
+		if added.IsZero() {
+			t.Fatalf("%s: added time should be set", key)
+		}
+		keys = append(keys, key)
+		return len(keys) < 2
+	})
+	if !reflect.DeepEqual(keys, []string{"key1", "key3"}) {
+		t.Fatalf("expected most recently used first, got %v", keys)
+	}
+	if lru.doublyLinkedList.Back().Value.(*entry).key != "key1" {
+		t.Fatal("Range should not change the order")
+	}
+}
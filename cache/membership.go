@@ -0,0 +1,85 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"cache/gossip"
+)
+
+/**
+ * @Description: 使用gossip成员协议自动维护一致性hash上的节点,节点加入,离开或者死亡时调用GroupHTTP.Set
+ * gossip节点名为GroupHTTP的地址,见 gossip 包
+ */
+
+const (
+	gossipJoinTimeout  = time.Second
+	gossipJoinRetry    = 5 * time.Second
+	gossipLeaveTimeout = 2 * time.Second
+)
+
+/**
+ * @Description: 创建gossip成员列表并通过种子节点加入集群,活跃节点改变时更新g的一致性hash
+ * 第一次收到成员变化之前保留g中已经Set的节点
+ * 种子节点都没有回复时(比如集群中的节点同时启动)在后台重试,直到加入成功或者Shutdown
+ * @param g
+ * @param cfg cfg.Name为空时使用g的地址,cfg.OnChange在更新一致性hash之后调用
+ * @param seeds 种子节点的gossip地址
+ * @return *gossip.Memberlist
+ * @return error
+ */
+func JoinGossip(g *GroupHTTP, cfg gossip.Config, seeds []string) (*gossip.Memberlist, error) {
+	if cfg.Name == "" {
+		cfg.Name = g.addr
+	}
+	if cfg.Log == nil {
+		cfg.Log = g.logger().Info
+	}
+	onChange := cfg.OnChange
+	cfg.OnChange = func(members []gossip.Member) {
+		names := make([]string, len(members))
+		for i, m := range members {
+			names[i] = m.Name
+		}
+		g.logger().Info("gossip members changed", "node", g.addr, "members", names)
+		g.Set(names...)
+		if onChange != nil {
+			onChange(members)
+		}
+	}
+	m, err := gossip.Create(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if len(seeds) == 0 {
+		return m, nil
+	}
+	if _, err := m.Join(seeds, gossipJoinTimeout); err != nil {
+		g.logger().Warn("join gossip failed, retry in background", "node", g.addr, "seeds", seeds, "err", err)
+		go func() {
+			for {
+				time.Sleep(gossipJoinRetry)
+				if _, err := m.Join(seeds, gossipJoinTimeout); err != gossip.ErrJoinFailed {
+					return
+				}
+			}
+		}()
+	}
+	return m, nil
+}
+
+/**
+ * @Description: 广播离开并停止gossip,最多等待gossipLeaveTimeout或者ctx结束
+ * @param ctx
+ * @param m
+ * @return error
+ */
+func leaveGossip(ctx context.Context, m *gossip.Memberlist) error {
+	timeout := gossipLeaveTimeout
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < timeout {
+		timeout = time.Until(deadline)
+	}
+	err := m.Leave(timeout)
+	m.Shutdown()
+	return err
+}
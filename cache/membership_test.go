@@ -0,0 +1,71 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"cache/gossip"
+)
+
+func fastGossip() gossip.Config {
+	return gossip.Config{
+		BindAddr:         "127.0.0.1:0",
+		ProbeInterval:    20 * time.Millisecond,
+		ProbeTimeout:     10 * time.Millisecond,
+		SuspicionTimeout: 100 * time.Millisecond,
+	}
+}
+
+func waitRing(t *testing.T, nodes []*GroupHTTP, want int) {
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		ok := true
+		for _, n := range nodes {
+			if len(n.Ring()) != want {
+				ok = false
+			}
+		}
+		if ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %d nodes on the ring", want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestGossipDrivesRing(t *testing.T) {
+	var nodes []*GroupHTTP
+	var lists []*gossip.Memberlist
+	for i := 0; i < 3; i++ {
+		g := NewGroupHTTP(fmt.Sprintf("http://node-%d", i))
+		var seeds []string
+		if i > 0 {
+			seeds = []string{lists[0].Addr()}
+		}
+		m, err := JoinGossip(g, fastGossip(), seeds)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer m.Shutdown()
+		nodes, lists = append(nodes, g), append(lists, m)
+	}
+	waitRing(t, nodes, 3)
+	owner := nodes[0].Owners("some-key")[0]
+	for _, n := range nodes[1:] {
+		if n.Owners("some-key")[0] != owner {
+			t.Fatal("all nodes should agree on the owner")
+		}
+	}
+
+	//节点崩溃,怀疑超时后从一致性hash上移除
+	lists[2].Shutdown()
+	waitRing(t, nodes[:2], 2)
+
+	//节点主动离开,不需要等待怀疑超时
+	leaveGossip(context.Background(), lists[1])
+	waitRing(t, nodes[:1], 1)
+}
@@ -0,0 +1,400 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/**
+ * @Description: memcached文本协议前端,key为 "group:key",支持 get gets delete touch stats version quit
+ * get和gets通过Group.GetContext读取,缓存未命中时加载,gets的cas值为值的版本,和 CompareAndSet 使用的相同
+ * 开启 WithFrontendAuth 时和memcached的-Y一样,连接的第一条命令需要是数据块为 "user password" 的set,
+ * 之后访问的每个group都要经过Authorizer授权
+ */
+
+const (
+	memcacheVersion    = "1.6.9-cache"
+	memcacheMaxKeyLen  = 250
+	memcacheBufferSize = 64 << 10
+	//exptime超过30天时表示unix时间戳,否则表示相对时间
+	memcacheRelativeExpireLimit = 60 * 60 * 24 * 30
+)
+
+/**
+ * @Description: memcached协议服务器
+ */
+type MemcacheServer struct {
+	*tcpServer
+	start time.Time
+}
+
+/**
+ * @Description: 新建一个memcached协议服务器,使用 ListenAndServe 或 Serve 开始服务
+ * @param opts
+ * @return *MemcacheServer
+ */
+func NewMemcacheServer(opts ...FrontendOption) *MemcacheServer {
+	s := &MemcacheServer{start: time.Now()}
+	s.tcpServer = newTCPServer("memcache", s.serveConn, opts...)
+	return s
+}
+
+/**
+ * @Description: 处理一个连接,读缓冲区为空时才flush
+ * @receiver s
+ * @param ctx
+ * @param conn
+ */
+func (s *MemcacheServer) serveConn(ctx context.Context, conn net.Conn) {
+	r := bufio.NewReaderSize(conn, memcacheBufferSize)
+	w := bufio.NewWriter(conn)
+	sess := s.newSession()
+	for {
+		line, err := r.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			w.WriteString("CLIENT_ERROR line too long\r\n")
+			w.Flush()
+			return
+		}
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(string(line))
+		if len(fields) == 0 {
+			w.WriteString("ERROR\r\n")
+		} else if quit := s.exec(ctx, r, w, sess, fields); quit {
+			w.Flush()
+			return
+		}
+		if r.Buffered() == 0 && w.Flush() != nil {
+			return
+		}
+	}
+}
+
+/**
+ * @Description: 执行一条命令
+ * @receiver s
+ * @param ctx
+ * @param r 存储命令需要从r中读取数据块
+ * @param w
+ * @param sess 连接的认证状态
+ * @param fields
+ * @return bool 是否关闭连接
+ */
+func (s *MemcacheServer) exec(ctx context.Context, r *bufio.Reader, w *bufio.Writer, sess *frontendSession, fields []string) bool {
+	cmd, args := fields[0], fields[1:]
+	noreply := len(args) > 0 && args[len(args)-1] == "noreply"
+	if noreply {
+		args = args[:len(args)-1]
+	}
+	reply := func(s string) {
+		if !noreply {
+			w.WriteString(s + "\r\n")
+		}
+	}
+	//分割key并检查权限
+	lookup := func(arg string) (*Group, string, error) {
+		g, key, err := memcacheKey(arg)
+		if err != nil {
+			return nil, "", err
+		}
+		if err := sess.allow(g); err != nil {
+			return nil, "", err
+		}
+		return g, key, nil
+	}
+	if !sess.authed && cmd != "set" && cmd != "quit" {
+		if isMemcacheStorage(cmd) && !s.discardData(r, args) {
+			w.WriteString("CLIENT_ERROR bad data chunk\r\n")
+			return true
+		}
+		w.WriteString("CLIENT_ERROR unauthenticated\r\n")
+		return false
+	}
+	switch cmd {
+	case "get", "gets":
+		if len(args) == 0 {
+			w.WriteString("ERROR\r\n")
+			break
+		}
+		if len(args) > s.maxArgs {
+			w.WriteString("CLIENT_ERROR too many keys\r\n")
+			break
+		}
+		groups, keys := make([]*Group, len(args)), make([]string, len(args))
+		var forbidden error
+		for i, arg := range args {
+			g, key, err := lookup(arg)
+			if errors.Is(err, ErrForbidden) {
+				forbidden = err
+				break
+			}
+			groups[i], keys[i] = g, key
+		}
+		if forbidden != nil {
+			w.WriteString("CLIENT_ERROR " + forbidden.Error() + "\r\n")
+			break
+		}
+		s.get(ctx, w, args, groups, keys, cmd == "gets")
+	case "delete":
+		//兼容旧版本的 delete <key> 0
+		if len(args) == 2 && args[1] == "0" {
+			args = args[:1]
+		}
+		if len(args) != 1 {
+			w.WriteString("ERROR\r\n")
+			break
+		}
+		g, key, err := lookup(args[0])
+		if err != nil {
+			reply("CLIENT_ERROR " + err.Error())
+			break
+		}
+		existed, err := frontendDelete(ctx, g, key)
+		switch {
+		case err != nil:
+			reply("SERVER_ERROR " + err.Error())
+		case existed:
+			reply("DELETED")
+		default:
+			reply("NOT_FOUND")
+		}
+	case "touch":
+		if len(args) != 2 {
+			w.WriteString("ERROR\r\n")
+			break
+		}
+		g, key, err := lookup(args[0])
+		if err != nil {
+			reply("CLIENT_ERROR " + err.Error())
+			break
+		}
+		exptime, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			reply("CLIENT_ERROR invalid exptime argument")
+			break
+		}
+		if _, ok := g.peek(key); !ok {
+			reply("NOT_FOUND")
+			break
+		}
+		if exptime < 0 {
+			g.Remove(key)
+		} else {
+			g.touch(key, memcacheExpire(exptime))
+		}
+		reply("TOUCHED")
+	case "set", "add", "replace", "append", "prepend", "cas":
+		if len(args) < 4 {
+			w.WriteString("ERROR\r\n")
+			break
+		}
+		if !sess.authed {
+			//认证的set,数据块为 "user password"
+			data, ok := s.readData(r, args)
+			if !ok {
+				w.WriteString("CLIENT_ERROR bad data chunk\r\n")
+				return true
+			}
+			user, password := "", string(data)
+			if i := strings.IndexByte(password, ' '); i >= 0 {
+				user, password = password[:i], password[i+1:]
+			}
+			if sess.login(user, password) != nil {
+				w.WriteString("CLIENT_ERROR authentication failure\r\n")
+				break
+			}
+			reply("STORED")
+			break
+		}
+		//只读缓存,读掉数据块保持协议同步
+		if !s.discardData(r, args) {
+			w.WriteString("CLIENT_ERROR bad data chunk\r\n")
+			return true
+		}
+		reply("SERVER_ERROR " + cmd + " is not supported by a read-through cache")
+	case "stats":
+		if len(args) > 0 {
+			//不支持stats items,stats slabs等
+			w.WriteString("END\r\n")
+			break
+		}
+		s.stats(w, sess)
+	case "version":
+		w.WriteString("VERSION " + memcacheVersion + "\r\n")
+	case "verbosity":
+		reply("OK")
+	case "quit":
+		return true
+	default:
+		w.WriteString("ERROR\r\n")
+	}
+	return false
+}
+
+/**
+ * @Description: 最多WithFetchConcurrency个key并发读取,按请求的顺序返回,读取失败的key当作未命中
+ * @receiver s
+ * @param ctx
+ * @param w
+ * @param names 请求中的key
+ * @param groups names对应的group,无效的key为nil
+ * @param keys names对应的group中的key
+ * @param cas 是否返回cas值
+ */
+func (s *MemcacheServer) get(ctx context.Context, w *bufio.Writer, names []string, groups []*Group, keys []string, cas bool) {
+	values := make([]ByteView, len(keys))
+	found := make([]bool, len(keys))
+	parallel(s.workers, len(keys), func(i int) {
+		if groups[i] == nil {
+			return
+		}
+		if v, err := groups[i].GetContext(ctx, keys[i]); err == nil {
+			values[i], found[i] = v, true
+		}
+	})
+	for i, v := range values {
+		if !found[i] {
+			continue
+		}
+		if cas {
+			fmt.Fprintf(w, "VALUE %s 0 %d %d\r\n", names[i], v.Len(), v.version)
+		} else {
+			fmt.Fprintf(w, "VALUE %s 0 %d\r\n", names[i], v.Len())
+		}
+		w.Write(v.value)
+		w.WriteString("\r\n")
+	}
+	w.WriteString("END\r\n")
+}
+
+func isMemcacheStorage(cmd string) bool {
+	switch cmd {
+	case "set", "add", "replace", "append", "prepend", "cas":
+		return true
+	}
+	return false
+}
+
+/**
+ * @Description: 读取存储命令的数据块
+ * @receiver s
+ * @param r
+ * @param args 命令的参数,第4个为数据块的长度
+ * @return []byte
+ * @return bool 长度无效或者读取失败时为false,需要关闭连接
+ */
+func (s *MemcacheServer) readData(r *bufio.Reader, args []string) ([]byte, bool) {
+	if len(args) < 4 {
+		return nil, false
+	}
+	n, err := strconv.Atoi(args[3])
+	if err != nil || n < 0 || n > s.maxValue {
+		return nil, false
+	}
+	buf := make([]byte, n+2)
+	if _, err := io.ReadFull(r, buf); err != nil || buf[n] != '\r' || buf[n+1] != '\n' {
+		return nil, false
+	}
+	return buf[:n], true
+}
+
+/**
+ * @Description: 读掉存储命令的数据块保持协议同步
+ * @receiver s
+ * @param r
+ * @param args
+ * @return bool
+ */
+func (s *MemcacheServer) discardData(r *bufio.Reader, args []string) bool {
+	if len(args) < 4 {
+		return true
+	}
+	n, err := strconv.Atoi(args[3])
+	if err != nil || n < 0 || n > s.maxValue {
+		return false
+	}
+	_, err = io.CopyN(ioutil.Discard, r, int64(n)+2)
+	return err == nil
+}
+
+/**
+ * @Description: 连接有权限的所有group的统计信息之和
+ * @receiver s
+ * @param w
+ * @param sess
+ */
+func (s *MemcacheServer) stats(w *bufio.Writer, sess *frontendSession) {
+	s.mu.Lock()
+	conns := len(s.conns)
+	s.mu.Unlock()
+
+	var gets, hits, items, bytes, evictions int64
+	for _, g := range allGroups() {
+		if sess.allow(g) != nil {
+			continue
+		}
+		st := g.Stats()
+		gets += st.Gets
+		hits += st.CacheHits + st.RemoteCacheHits
+		items += st.MainCache.Items + st.RemoteCache.Items
+		bytes += st.MainCache.Bytes + st.RemoteCache.Bytes
+		evictions += st.MainCache.Evictions + st.RemoteCache.Evictions
+	}
+	now := time.Now()
+	stat := func(name string, value interface{}) {
+		fmt.Fprintf(w, "STAT %s %v\r\n", name, value)
+	}
+	stat("pid", os.Getpid())
+	stat("uptime", int64(now.Sub(s.start)/time.Second))
+	stat("time", now.Unix())
+	stat("version", memcacheVersion)
+	stat("curr_connections", conns)
+	stat("cmd_get", gets)
+	stat("get_hits", hits)
+	stat("get_misses", gets-hits)
+	stat("curr_items", items)
+	stat("bytes", bytes)
+	stat("evictions", evictions)
+	w.WriteString("END\r\n")
+}
+
+/**
+ * @Description: 解析并检查memcached的key
+ * @param s
+ * @return *Group
+ * @return string
+ * @return error
+ */
+func memcacheKey(s string) (*Group, string, error) {
+	if len(s) > memcacheMaxKeyLen {
+		return nil, "", fmt.Errorf("key too long")
+	}
+	return splitGroupKey(s)
+}
+
+/**
+ * @Description: 把memcached的exptime转换为过期时间,0表示永不过期,不超过30天表示相对时间,否则为unix时间戳
+ * @param exptime
+ * @return time.Time
+ */
+func memcacheExpire(exptime int64) time.Time {
+	switch {
+	case exptime == 0:
+		return time.Time{}
+	case exptime <= memcacheRelativeExpireLimit:
+		return timeNow().Add(time.Duration(exptime) * time.Second)
+	default:
+		return time.Unix(exptime, 0)
+	}
+}
@@ -0,0 +1,194 @@
+package cache
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func startMemcache(t *testing.T, opts ...FrontendOption) (net.Conn, *bufio.Reader) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewMemcacheServer(opts...)
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return conn, bufio.NewReader(conn)
+}
+
+/**
+ * @Description: 发送一条命令,读取回复直到遇到end中的任意一行
+ */
+func memcacheDo(t *testing.T, conn net.Conn, r *bufio.Reader, cmd string, end ...string) string {
+	t.Helper()
+	fmt.Fprintf(conn, "%s\r\n", cmd)
+	var b strings.Builder
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("%s: %v, got %q", cmd, err, b.String())
+		}
+		b.WriteString(line)
+		for _, e := range end {
+			if strings.HasPrefix(line, e) {
+				return b.String()
+			}
+		}
+	}
+}
+
+func TestMemcacheServer(t *testing.T) {
+	NewGroup("mc", 1<<10, GetterFunc(func(key string) ([]byte, error) {
+		if key == "missing" {
+			return nil, errors.New("not found")
+		}
+		return []byte("v-" + key), nil
+	}))
+	conn, r := startMemcache(t)
+
+	cases := []struct {
+		cmd    string
+		expect string
+	}{
+		{"version", "VERSION " + memcacheVersion + "\r\n"},
+		{"get mc:a", "VALUE mc:a 0 3\r\nv-a\r\nEND\r\n"},
+		{"get mc:a mc:missing nogroup:x mc:b", "VALUE mc:a 0 3\r\nv-a\r\nVALUE mc:b 0 3\r\nv-b\r\nEND\r\n"},
+		{"touch mc:a 100", "TOUCHED\r\n"},
+		{"touch mc:zzz 100", "NOT_FOUND\r\n"},
+		{"delete mc:a", "DELETED\r\n"},
+		{"delete mc:a", "NOT_FOUND\r\n"},
+		{"delete nogroup:a", "CLIENT_ERROR no such group: nogroup\r\n"},
+		{"set mc:a 0 0 3\r\nabc", "SERVER_ERROR set is not supported by a read-through cache\r\n"},
+		{"flush_all", "ERROR\r\n"},
+	}
+	for _, c := range cases {
+		if got := memcacheDo(t, conn, r, c.cmd, "END", "VERSION", "TOUCHED", "NOT_FOUND", "DELETED", "CLIENT_ERROR", "SERVER_ERROR", "ERROR"); got != c.expect {
+			t.Fatalf("%q: expected %q, got %q", c.cmd, c.expect, got)
+		}
+	}
+
+	//noreply不返回任何内容,下一条命令的回复紧接着返回
+	fmt.Fprintf(conn, "delete mc:b noreply\r\n")
+	if got := memcacheDo(t, conn, r, "get mc:b", "END"); got != "VALUE mc:b 0 3\r\nv-b\r\nEND\r\n" {
+		t.Fatalf("unexpected reply after noreply: %q", got)
+	}
+
+	//gets的cas值就是CompareAndSet使用的版本
+	v, _ := GetGroup("mc").peek("b")
+	if got := memcacheDo(t, conn, r, "gets mc:b", "END"); got != fmt.Sprintf("VALUE mc:b 0 3 %d\r\nv-b\r\nEND\r\n", v.Version()) {
+		t.Fatalf("unexpected gets reply: %q", got)
+	}
+	if _, err := GetGroup("mc").CompareAndSet("b", v.Version(), []byte("v-b")); err != nil {
+		t.Fatalf("expected CompareAndSet with the cas value to succeed, got %v", err)
+	}
+
+	stats := memcacheDo(t, conn, r, "stats", "END")
+	for _, s := range []string{"STAT curr_connections 1\r\n", "STAT get_hits ", "STAT curr_items "} {
+		if !strings.Contains(stats, s) {
+			t.Fatalf("expected %q in stats:\n%s", s, stats)
+		}
+	}
+	if n := GetGroup("mc").Stats().MainCache.Items; n != 1 {
+		t.Fatalf("expected 1 item left in mc, got %d", n)
+	}
+
+	fmt.Fprintf(conn, "quit\r\n")
+	if _, err := r.ReadByte(); err == nil {
+		t.Fatal("expected connection to be closed after quit")
+	}
+}
+
+func TestMemcacheExpire(t *testing.T) {
+	advance := fakeClock(t)
+	now := timeNow()
+	NewGroup("mc-ttl", 1<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	conn, r := startMemcache(t)
+
+	memcacheDo(t, conn, r, "get mc-ttl:k", "END")
+	if got := memcacheDo(t, conn, r, "touch mc-ttl:k 10", "TOUCHED", "NOT_FOUND"); got != "TOUCHED\r\n" {
+		t.Fatalf("unexpected reply %q", got)
+	}
+	if v, _ := GetGroup("mc-ttl").peek("k"); !v.expire.Equal(now.Add(10 * time.Second)) {
+		t.Fatalf("expected relative exptime, got %v", v.expire)
+	}
+	if e := memcacheExpire(now.Unix() + 365*24*3600); e.Unix() != now.Unix()+365*24*3600 {
+		t.Fatalf("expected absolute exptime, got %v", e)
+	}
+	advance(11 * time.Second)
+	if got := memcacheDo(t, conn, r, "touch mc-ttl:k 10", "TOUCHED", "NOT_FOUND"); got != "NOT_FOUND\r\n" {
+		t.Fatalf("expected expired key to be gone, got %q", got)
+	}
+}
+
+func TestMemcacheAuth(t *testing.T) {
+	NewGroup("mc-auth", 1<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	secret := NewGroup("mc-secret", 1<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	secret.Get("a")
+	conn, r := startMemcache(t, WithFrontendAuth(map[string]string{"s3cret": "app"},
+		AllowGroups(map[string][]string{"app": {"mc-auth"}})), WithFrontendLimits(2, 0))
+
+	cases := []struct {
+		cmd    string
+		expect string
+	}{
+		{"get mc-auth:a", "CLIENT_ERROR unauthenticated\r\n"},
+		{"delete mc-auth:a", "CLIENT_ERROR unauthenticated\r\n"},
+		{"add x 0 0 2\r\nab", "CLIENT_ERROR unauthenticated\r\n"},
+		{"set x 0 0 10\r\napp wrong!", "CLIENT_ERROR authentication failure\r\n"},
+		{"set x 0 0 10\r\napp s3cret", "STORED\r\n"},
+		{"get mc-auth:a", "VALUE mc-auth:a 0 1\r\na\r\nEND\r\n"},
+		{"get mc-auth:a mc-secret:a", "CLIENT_ERROR forbidden: no permission for group mc-secret\r\n"},
+		{"delete mc-secret:a", "CLIENT_ERROR forbidden: no permission for group mc-secret\r\n"},
+		{"touch mc-secret:a 10", "CLIENT_ERROR forbidden: no permission for group mc-secret\r\n"},
+		{"get mc-auth:a mc-auth:b mc-auth:c", "CLIENT_ERROR too many keys\r\n"},
+		{"set mc-auth:a 0 0 2\r\nab", "SERVER_ERROR set is not supported by a read-through cache\r\n"},
+		{"delete mc-auth:a", "DELETED\r\n"},
+	}
+	for _, c := range cases {
+		if got := memcacheDo(t, conn, r, c.cmd, "END", "STORED", "DELETED", "CLIENT_ERROR", "SERVER_ERROR"); got != c.expect {
+			t.Fatalf("%q: expected %q, got %q", c.cmd, c.expect, got)
+		}
+	}
+	//stats只统计有权限的group
+	if got := memcacheDo(t, conn, r, "stats", "END"); !strings.Contains(got, "STAT curr_items 0\r\n") {
+		t.Fatalf("expected stats of authorized groups only, got %q", got)
+	}
+}
+
+func TestMemcacheDeleteFromSource(t *testing.T) {
+	store := newMemStore()
+	store.Set("a", []byte("v"))
+	NewGroup("mc-delete", 1<<10, store)
+	conn, r := startMemcache(t)
+
+	//配置了Deleter时delete从数据源删除,数据源的删除是幂等的,总是返回DELETED
+	for _, key := range []string{"a", "b"} {
+		if got := memcacheDo(t, conn, r, "delete mc-delete:"+key, "DELETED", "NOT_FOUND", "SERVER_ERROR"); got != "DELETED\r\n" {
+			t.Fatalf("%s: expected DELETED, got %q", key, got)
+		}
+	}
+	if _, err := store.Get("a"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected a to be deleted from the source, got %v", err)
+	}
+	store.failNext(1)
+	if got := memcacheDo(t, conn, r, "delete mc-delete:c", "DELETED", "NOT_FOUND", "SERVER_ERROR"); !strings.HasPrefix(got, "SERVER_ERROR") {
+		t.Fatalf("expected SERVER_ERROR when the source is down, got %q", got)
+	}
+}
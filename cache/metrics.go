@@ -0,0 +1,296 @@
+package cache
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/**
+ * @Description: Prometheus文本格式的指标输出,不依赖prometheus客户端
+ */
+
+const defaultMetricsPath = "/metrics"
+
+//默认的延迟分桶,单位秒
+var defaultLatencyBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+/**
+ * @Description: 直方图,统计延迟分布
+ */
+type histogram struct {
+	mu     sync.Mutex
+	bounds []float64 //每个桶的上界,升序
+	counts []uint64  //每个桶的计数,非累计,最后一个是+Inf
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{
+		bounds: bounds,
+		counts: make([]uint64, len(bounds)+1),
+	}
+}
+
+/**
+ * @Description: 记录一次耗时
+ * @receiver h
+ * @param d
+ */
+func (h *histogram) observe(d time.Duration) {
+	v := d.Seconds()
+	i := sort.SearchFloat64s(h.bounds, v)
+	h.mu.Lock()
+	h.counts[i]++
+	h.sum += v
+	h.count++
+	h.mu.Unlock()
+}
+
+/**
+ * @Description: 返回累计的分桶计数,总和以及总数
+ * @receiver h
+ * @return cumulative
+ * @return sum
+ * @return count
+ */
+func (h *histogram) snapshot() (cumulative []uint64, sum float64, count uint64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	cumulative = make([]uint64, len(h.counts))
+	var acc uint64
+	for i, c := range h.counts {
+		acc += c
+		cumulative[i] = acc
+	}
+	return cumulative, h.sum, h.count
+}
+
+/**
+ * @Description: 指标输出器,同一个指标的HELP和TYPE只输出一次
+ */
+type metricsWriter struct {
+	w       *bufio.Writer
+	written map[string]bool
+}
+
+func newMetricsWriter(w io.Writer) *metricsWriter {
+	return &metricsWriter{w: bufio.NewWriter(w), written: make(map[string]bool)}
+}
+
+func (m *metricsWriter) header(name, typ, help string) {
+	if m.written[name] {
+		return
+	}
+	m.written[name] = true
+	fmt.Fprintf(m.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+/**
+ * @Description: 输出一个counter或gauge
+ * @receiver m
+ * @param name
+ * @param typ
+ * @param help
+ * @param value
+ * @param labels 成对的标签名和标签值
+ */
+func (m *metricsWriter) sample(name, typ, help string, value int64, labels ...string) {
+	m.header(name, typ, help)
+	fmt.Fprintf(m.w, "%s%s %d\n", name, formatLabels(labels), value)
+}
+
+/**
+ * @Description: 输出一个直方图
+ * @receiver m
+ * @param name
+ * @param help
+ * @param h
+ * @param labels
+ */
+func (m *metricsWriter) histogram(name, help string, h *histogram, labels ...string) {
+	m.header(name, "histogram", help)
+	cumulative, sum, count := h.snapshot()
+	for i, c := range cumulative {
+		le := "+Inf"
+		if i < len(h.bounds) {
+			le = strconv.FormatFloat(h.bounds[i], 'g', -1, 64)
+		}
+		fmt.Fprintf(m.w, "%s_bucket%s %d\n", name, formatLabels(append(labels[:len(labels):len(labels)], "le", le)), c)
+	}
+	fmt.Fprintf(m.w, "%s_sum%s %s\n", name, formatLabels(labels), strconv.FormatFloat(sum, 'g', -1, 64))
+	fmt.Fprintf(m.w, "%s_count%s %d\n", name, formatLabels(labels), count)
+}
+
+func (m *metricsWriter) flush() error {
+	return m.w.Flush()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, labels[i], labelEscaper.Replace(labels[i+1]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+//group的counter指标
+var groupCounters = []struct {
+	name, help string
+	value      func(s GroupStats) int64
+}{
+	{"cache_gets_total", "Get requests, including requests from peers.", func(s GroupStats) int64 { return s.Gets }},
+	{"cache_hits_total", "Hits in the main cache.", func(s GroupStats) int64 { return s.CacheHits }},
+	{"cache_remote_hits_total", "Hits in the remote cache.", func(s GroupStats) int64 { return s.RemoteCacheHits }},
+	{"cache_loads_total", "Cache misses that required a load.", func(s GroupStats) int64 { return s.Loads }},
+	{"cache_loads_deduped_total", "Loads actually executed after singleflight.", func(s GroupStats) int64 { return s.LoadsDeduped }},
+	{"cache_singleflight_waits_total", "Loads that waited on an in-flight singleflight call.", func(s GroupStats) int64 { return s.LoadWaits }},
+	{"cache_local_loads_total", "Successful loads from the Getter.", func(s GroupStats) int64 { return s.LocalLoads }},
+	{"cache_local_load_errors_total", "Failed loads from the Getter.", func(s GroupStats) int64 { return s.LocalLoadErrs }},
+	{"cache_peer_loads_total", "Successful loads from peers.", func(s GroupStats) int64 { return s.PeerLoads }},
+	{"cache_peer_errors_total", "Failed loads from peers.", func(s GroupStats) int64 { return s.PeerErrors }},
+	{"cache_server_requests_total", "Requests served for peers.", func(s GroupStats) int64 { return s.ServerRequests }},
+	{"cache_stale_serves_total", "Stale values served after load errors.", func(s GroupStats) int64 { return s.StaleServes }},
+	{"cache_source_writes_total", "Successful writes and deletes to the data source.", func(s GroupStats) int64 { return s.SourceWrites }},
+	{"cache_source_write_errors_total", "Failed or dropped writes to the data source.", func(s GroupStats) int64 { return s.SourceWriteErrs }},
+	{"cache_peer_revalidations_total", "Remote copies revalidated with the owner without a transfer.", func(s GroupStats) int64 { return s.PeerRevalidations }},
+	{"cache_lease_rejects_total", "Loaded values not cached because the key was invalidated during the load.", func(s GroupStats) int64 { return s.LeaseRejects }},
+	{"cache_lease_stale_serves_total", "Invalidated values served while another caller held the lease.", func(s GroupStats) int64 { return s.LeaseStaleServes }},
+}
+
+//main和remote两个cache的指标
+var cacheGauges = []struct {
+	name, typ, help string
+	value           func(s CacheStats) int64
+}{
+	{"cache_bytes", "gauge", "Bytes used by the cache.", func(s CacheStats) int64 { return s.Bytes }},
+	{"cache_items", "gauge", "Items in the cache.", func(s CacheStats) int64 { return s.Items }},
+	{"cache_evictions_total", "counter", "Items evicted from the cache.", func(s CacheStats) int64 { return s.Evictions }},
+}
+
+/**
+ * @Description: 输出所有group的指标,按指标再按group输出,同一个指标的样本是连续的
+ * @receiver m
+ */
+func (m *metricsWriter) writeGroups() {
+	groups := allGroups()
+	stats := make([]GroupStats, len(groups))
+	for i, g := range groups {
+		stats[i] = g.Stats()
+	}
+	for _, c := range groupCounters {
+		for _, s := range stats {
+			m.sample(c.name, "counter", c.help, c.value(s), "group", s.Name)
+		}
+	}
+	for _, c := range cacheGauges {
+		for _, s := range stats {
+			m.sample(c.name, c.typ, c.help, c.value(s.MainCache), "group", s.Name, "cache", "main")
+			m.sample(c.name, c.typ, c.help, c.value(s.RemoteCache), "group", s.Name, "cache", "remote")
+		}
+	}
+	for _, s := range stats {
+		m.sample("cache_write_behind_pending", "gauge", "Writes queued for the data source.", s.WriteBehind, "group", s.Name)
+	}
+	for i, g := range groups {
+		m.histogram("cache_singleflight_wait_seconds", "Time spent waiting on in-flight singleflight calls.", g.loadWait, "group", stats[i].Name)
+	}
+}
+
+/**
+ * @Description: 以Prometheus文本格式输出所有group的指标
+ * @param w
+ * @return error
+ */
+func WriteMetrics(w io.Writer) error {
+	m := newMetricsWriter(w)
+	m.writeGroups()
+	return m.flush()
+}
+
+/**
+ * @Description: 以Prometheus文本格式输出所有group以及到其他节点请求的指标
+ * @receiver g
+ * @param w
+ * @return error
+ */
+func (g *GroupHTTP) WriteMetrics(w io.Writer) error {
+	m := newMetricsWriter(w)
+	m.writeGroups()
+
+	g.mu.Lock()
+	peers := make([]string, 0, len(g.NodeClientMap))
+	clients := make(map[string]*httpClient, len(g.NodeClientMap))
+	for name, c := range g.NodeClientMap {
+		peers = append(peers, name)
+		clients[name] = c
+	}
+	g.mu.Unlock()
+	sort.Strings(peers)
+	issued, won := g.HedgeStats()
+	m.sample("cache_peer_hedges_total", "counter", "Hedged requests issued to a second peer.", issued)
+	m.sample("cache_peer_hedge_wins_total", "counter", "Hedged requests that answered first.", won)
+	for _, peer := range peers {
+		m.histogram("cache_peer_request_duration_seconds", "Latency of requests to peers.", clients[peer].latency, "peer", peer)
+	}
+	for _, peer := range peers {
+		m.sample("cache_peer_request_errors_total", "counter", "Failed requests to peers.", clients[peer].errors.Get(), "peer", peer)
+	}
+	return m.flush()
+}
+
+/**
+ * @Description: /metrics 的http处理函数
+ * 配置了 WithAuth 或 WithAdmin 时需要通过节点认证或者带上管理接口的token,除非配置了 WithPublicMetrics
+ * @receiver g
+ * @param w
+ * @param r
+ */
+func (g *GroupHTTP) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	if !g.metricsAuthorized(r) {
+		g.logger().Warn("reject unauthenticated metrics request", "node", g.addr, "remote", r.RemoteAddr)
+		http.Error(w, ErrUnauthenticated.Error(), http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if err := g.WriteMetrics(w); err != nil {
+		g.logger().Warn("write metrics failed", "node", g.addr, "err", err)
+	}
+}
+
+/**
+ * @Description: 是否允许读取/metrics,没有配置认证时和其他接口一样公开
+ * @receiver g
+ * @param r
+ * @return bool
+ */
+func (g *GroupHTTP) metricsAuthorized(r *http.Request) bool {
+	if g.publicMetrics || (g.auth == nil && g.adminToken == "") {
+		return true
+	}
+	if g.adminAuthorized(r) {
+		return true
+	}
+	if g.auth == nil {
+		return false
+	}
+	_, err := g.auth.Verify(r)
+	return err == nil
+}
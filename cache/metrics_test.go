@@ -0,0 +1,143 @@
+package cache
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+/**
+ * @Description: 解析Prometheus文本格式,返回 name{labels} => value 以及 name => type
+ * 同一个指标的HELP,TYPE只能出现一次,并且样本必须连续的紧跟在TYPE之后
+ */
+func parseMetrics(t *testing.T, text string) (map[string]float64, map[string]string) {
+	samples := make(map[string]float64)
+	types := make(map[string]string)
+	helps := make(map[string]bool)
+	family := ""
+	sc := bufio.NewScanner(strings.NewReader(text))
+	for sc.Scan() {
+		line := sc.Text()
+		if strings.HasPrefix(line, "# HELP ") {
+			f := strings.Fields(line)
+			if helps[f[2]] {
+				t.Fatalf("duplicated HELP for %s", f[2])
+			}
+			helps[f[2]] = true
+			continue
+		}
+		if strings.HasPrefix(line, "# TYPE ") {
+			f := strings.Fields(line)
+			if _, ok := types[f[2]]; ok {
+				t.Fatalf("duplicated TYPE for %s", f[2])
+			}
+			types[f[2]] = f[3]
+			family = f[2]
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name := line[:strings.IndexAny(line, "{ ")]
+		if types[family] == "histogram" {
+			for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+				if strings.TrimSuffix(name, suffix) == family {
+					name = family
+				}
+			}
+		}
+		if name != family {
+			t.Fatalf("sample %q is not contiguous with its family, current family is %s", line, family)
+		}
+		i := strings.LastIndexByte(line, ' ')
+		v, err := strconv.ParseFloat(line[i+1:], 64)
+		if err != nil {
+			t.Fatalf("bad sample line %q: %v", line, err)
+		}
+		samples[line[:i]] = v
+	}
+	return samples, types
+}
+
+func TestMetricsHandler(t *testing.T) {
+	g := NewGroup("metrics", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	g.Get("k1")
+	g.Get("k1")
+
+	peers := NewGroupHTTP("http://node1")
+	peers.Set("http://node1", "http://node2")
+	peers.NodeClientMap["http://node2"].latency.observe(3 * time.Millisecond)
+
+	rec := httptest.NewRecorder()
+	peers.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Fatalf("unexpected content type %s", ct)
+	}
+	samples, types := parseMetrics(t, rec.Body.String())
+
+	expect := map[string]float64{
+		`cache_gets_total{group="metrics"}`:                                           2,
+		`cache_hits_total{group="metrics"}`:                                           1,
+		`cache_local_loads_total{group="metrics"}`:                                    1,
+		`cache_items{group="metrics",cache="main"}`:                                   1,
+		`cache_bytes{group="metrics",cache="main"}`:                                   4,
+		`cache_peer_request_duration_seconds_count{peer="http://node2"}`:              1,
+		`cache_peer_request_duration_seconds_bucket{peer="http://node2",le="0.0025"}`: 0,
+		`cache_peer_request_duration_seconds_bucket{peer="http://node2",le="0.005"}`:  1,
+		`cache_peer_request_duration_seconds_bucket{peer="http://node2",le="+Inf"}`:   1,
+		`cache_singleflight_wait_seconds_count{group="metrics"}`:                      0,
+	}
+	for name, v := range expect {
+		if got, ok := samples[name]; !ok || got != v {
+			t.Errorf("%s: expected %v, got %v (present=%v)", name, v, got, ok)
+		}
+	}
+	for name, typ := range map[string]string{
+		"cache_gets_total":                    "counter",
+		"cache_bytes":                         "gauge",
+		"cache_peer_request_duration_seconds": "histogram",
+	} {
+		if types[name] != typ {
+			t.Errorf("%s: expected type %s, got %s", name, typ, types[name])
+		}
+	}
+}
+
+func TestMetricsAuth(t *testing.T) {
+	get := func(g *GroupHTTP, header ...string) int {
+		r := httptest.NewRequest("GET", "/metrics", nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, r)
+		return rec.Code
+	}
+
+	auth := NewBearerAuth("peer-token", map[string]string{"peer-token": "node"})
+	g := NewGroupHTTP("http://node1", WithAuth(auth, nil), WithAdmin("admin-token"))
+	if code := get(g); code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without credentials, got %d", code)
+	}
+	if code := get(g, "Authorization", "Bearer admin-token"); code != http.StatusOK {
+		t.Fatalf("expected the admin token to be accepted, got %d", code)
+	}
+	if code := get(g, "Authorization", "Bearer peer-token"); code != http.StatusOK {
+		t.Fatalf("expected node credentials to be accepted, got %d", code)
+	}
+	if code := get(NewGroupHTTP("http://node1", WithAdmin("admin-token"), WithPublicMetrics())); code != http.StatusOK {
+		t.Fatalf("expected public metrics, got %d", code)
+	}
+}
+
+func TestFormatLabels(t *testing.T) {
+	if s := formatLabels([]string{"group", "a\"b\\c\nd"}); s != `{group="a\"b\\c\nd"}` {
+		t.Fatalf("unexpected labels %s", s)
+	}
+}
@@ -1,14 +1,33 @@
 package cache
 
-import pb "cache/cachepb"
+import (
+	"context"
+
+	pb "cache/cachepb"
+)
 
 //根据传的key选择响应的节点
 type NodePicker interface {
 	PickNode(key string)(node NodeClient,ok bool)
 }
 
+//可以返回key在一致性hash上的owner的NodePicker,GroupHTTP实现了这个接口
+//CompareAndSet只发给owner,owner熔断时返回ErrOwnerUnavailable,不像PickNode那样选择下一个节点
+//self为true时本节点就是owner
+type OwnerPicker interface {
+	PickOwner(key string) (node NodeClient, self bool, err error)
+}
+
 //GroupHTTP就是一个这个接口
 type NodeClient interface {
-	//从对应的group查找缓存
-	Get(in *pb.Request,out *pb.Response)error
+	//从对应的group查找缓存,ctx用于超时控制和传递追踪上下文
+	//ctx参数是有意的不兼容变更,自定义的NodeClient需要改为这个签名,见README的分布式追踪
+	Get(ctx context.Context,in *pb.Request,out *pb.Response)error
+}
+
+//可以写入的节点客户端,Group.Set和Group.Delete通过它转发给owner节点,httpClient实现了这个接口
+//in.Compare为true时只有owner上的版本等于in.Version才写入,out.Version为写入后的版本
+type NodeWriter interface {
+	Set(ctx context.Context, in *pb.Request, value []byte, out *pb.Response) error
+	Delete(ctx context.Context, in *pb.Request) error
 }
@@ -0,0 +1,530 @@
+package cache
+
+import (
+	"net/http"
+	"time"
+
+	"cache/gossip"
+)
+
+/**
+ * @Description: Group的可选配置,在NewGroup时传入
+ */
+type GroupOption func(g *Group)
+
+/**
+ * @Description: 设置Group使用的Logger,不设置时使用全局Logger,见 SetLogger
+ * @param l
+ * @return GroupOption
+ */
+func WithLogger(l Logger) GroupOption {
+	return func(g *Group) {
+		g.log = l
+	}
+}
+
+/**
+ * @Description: 设置缓存值的存活时间,从源数据加载时开始计时,ttl<=0表示永不过期
+ * @param ttl
+ * @return GroupOption
+ */
+func WithTTL(ttl time.Duration) GroupOption {
+	return func(g *Group) {
+		g.ttl = ttl
+	}
+}
+
+/**
+ * @Description: 缓存值过期后的grace时间内,继续返回旧值,同时在后台通过singleflight刷新
+ * @param grace
+ * @return GroupOption
+ */
+func WithStaleWhileRevalidate(grace time.Duration) GroupOption {
+	return func(g *Group) {
+		g.staleGrace = grace
+	}
+}
+
+/**
+ * @Description: 开启XFetch概率提前刷新,beta越大越早刷新,通常取1
+ * @param beta
+ * @return GroupOption
+ */
+func WithEarlyRefresh(beta float64) GroupOption {
+	return func(g *Group) {
+		g.earlyBeta = beta
+	}
+}
+
+/**
+ * @Description: Getter失败时,返回最近过期或被淘汰的旧值,返回的值ByteView.Stale()为true
+ * window为过期或淘汰后旧值仍然可用的时间,maxBytes为旧值缓冲区的大小
+ * @param window
+ * @param maxBytes
+ * @return GroupOption
+ */
+func WithServeStaleOnError(window time.Duration, maxBytes int64) GroupOption {
+	return func(g *Group) {
+		g.stale = &staleBuffer{maxBytes: maxBytes, window: window}
+		onEvicted := func(key string, value ByteView) {
+			g.stale.put(key, value, timeNow())
+		}
+		g.cache.onEvicted = onEvicted
+		g.remoteCache.onEvicted = onEvicted
+	}
+}
+
+/**
+ * @Description: key被Remove或Delete失效后,有调用方持有租约正在重新加载时,
+ * 其他调用方在window内直接返回失效前的旧值(ByteView.Stale()为true),而不是等待加载完成
+ * @param window 旧值可以返回的时间,应该很短
+ * @return GroupOption
+ */
+func WithLeaseStale(window time.Duration) GroupOption {
+	return func(g *Group) {
+		g.leaseStale = window
+	}
+}
+
+/**
+ * @Description: 开启压缩,value不小于threshold字节时,按请求方支持的算法压缩后再发送给其他节点
+ * inMemory为true时压缩后保存在内存中,maxBytes按压缩后的大小计算,每次命中都需要解压
+ * @param codec 压缩算法,如 NewGzipCodec(gzip.BestSpeed),其他节点需要注册同名算法才能解压
+ * @param threshold
+ * @param inMemory
+ * @return GroupOption
+ */
+func WithCompression(codec Codec, threshold int, inMemory bool) GroupOption {
+	return func(g *Group) {
+		g.codec = codec
+		g.compressThreshold = threshold
+		g.compressInMemory = inMemory
+	}
+}
+
+/**
+ * @Description: 开启加密,value使用keyring的当前密钥加密后保存在内存中(包括serve-stale的旧值),对Get透明
+ * 开启压缩时先压缩再加密
+ * @param keyring
+ * @return GroupOption
+ */
+func WithEncryption(keyring *Keyring) GroupOption {
+	return func(g *Group) {
+		g.keyring = keyring
+	}
+}
+
+/**
+ * @Description: Set写入数据源,Getter实现了Setter时不需要设置
+ * @param s
+ * @return GroupOption
+ */
+func WithSetter(s Setter) GroupOption {
+	return func(g *Group) {
+		g.setter = s
+	}
+}
+
+/**
+ * @Description: Delete从数据源删除,Getter实现了Deleter时不需要设置
+ * @param d
+ * @return GroupOption
+ */
+func WithDeleter(d Deleter) GroupOption {
+	return func(g *Group) {
+		g.deleter = d
+	}
+}
+
+/**
+ * @Description: 异步写数据源,Set和Delete更新缓存并放入队列后立即返回,队列按顺序每interval或者攒够batchSize写一次,
+ * 失败时重试retries次,仍然失败的写入被丢弃并计入SourceWriteErrs;关闭时通过Flush写完,见 Server.Shutdown
+ * @param batchSize <=0时使用默认值100
+ * @param interval <=0时使用默认值1s
+ * @param retries <0时使用默认值3
+ * @return GroupOption
+ */
+func WithWriteBehind(batchSize int, interval time.Duration, retries int) GroupOption {
+	return func(g *Group) {
+		g.writeBehind = newWriteQueue(g, batchSize, interval, retries)
+	}
+}
+
+/**
+ * @Description: 开启热点key统计,见 Group.HotKeys 和监控页面,每次Get都会更新计数,有一定的锁竞争
+ * @param capacity 保存计数的key数,<=0时使用默认值32
+ * @return GroupOption
+ */
+func WithHotKeys(capacity int) GroupOption {
+	return func(g *Group) {
+		if capacity <= 0 {
+			capacity = defaultHotKeysCapacity
+		}
+		g.hot = newHotKeys(capacity, defaultHotKeysDecay)
+	}
+}
+
+/**
+ * @Description: GroupHTTP的可选配置,在NewGroupHTTP时传入
+ */
+type HTTPOption func(g *GroupHTTP)
+
+/**
+ * @Description: 设置GroupHTTP使用的Logger,不设置时使用全局Logger,见 SetLogger
+ * @param l
+ * @return HTTPOption
+ */
+func WithHTTPLogger(l Logger) HTTPOption {
+	return func(g *GroupHTTP) {
+		g.log = l
+	}
+}
+
+/**
+ * @Description: 使用自定义的http.Client请求其他节点,设置后WithTransport,WithMaxIdleConnsPerPeer,WithKeepAlive,WithIdleConnTimeout不再生效
+ * @param c
+ * @return HTTPOption
+ */
+func WithHTTPClient(c *http.Client) HTTPOption {
+	return func(g *GroupHTTP) {
+		g.client = c
+	}
+}
+
+/**
+ * @Description: 使用自定义的RoundTripper请求其他节点,设置后WithMaxIdleConnsPerPeer,WithKeepAlive,WithIdleConnTimeout不再生效
+ * @param rt
+ * @return HTTPOption
+ */
+func WithTransport(rt http.RoundTripper) HTTPOption {
+	return func(g *GroupHTTP) {
+		g.transport = rt
+	}
+}
+
+/**
+ * @Description: 单次请求其他节点的超时时间,默认5秒,<=0表示不超时
+ * @param d
+ * @return HTTPOption
+ */
+func WithPeerTimeout(d time.Duration) HTTPOption {
+	return func(g *GroupHTTP) {
+		g.peerTimeout = d
+	}
+}
+
+/**
+ * @Description: 每个节点保留的最大空闲连接数,默认32
+ * @param n
+ * @return HTTPOption
+ */
+func WithMaxIdleConnsPerPeer(n int) HTTPOption {
+	return func(g *GroupHTTP) {
+		g.maxIdleConnsPerPeer = n
+	}
+}
+
+/**
+ * @Description: TCP keep-alive的探测间隔,默认30秒,<0表示关闭http长连接
+ * @param d
+ * @return HTTPOption
+ */
+func WithKeepAlive(d time.Duration) HTTPOption {
+	return func(g *GroupHTTP) {
+		g.keepAlive = d
+	}
+}
+
+/**
+ * @Description: 空闲连接的最长保留时间,默认90秒
+ * @param d
+ * @return HTTPOption
+ */
+func WithIdleConnTimeout(d time.Duration) HTTPOption {
+	return func(g *GroupHTTP) {
+		g.idleConnTimeout = d
+	}
+}
+
+/**
+ * @Description: 配置熔断器,连续失败threshold次后熔断该节点,cooldown之后允许一个试探请求,默认5次,10秒
+ * @param threshold
+ * @param cooldown
+ * @return HTTPOption
+ */
+func WithCircuitBreaker(threshold int, cooldown time.Duration) HTTPOption {
+	return func(g *GroupHTTP) {
+		g.failureThreshold = threshold
+		g.breakerCooldown = cooldown
+	}
+}
+
+/**
+ * @Description: 网络错误,超时以及502/503/504时最多重试retries次,退避时间从backoff开始指数增长,默认不重试
+ * @param retries
+ * @param backoff
+ * @return HTTPOption
+ */
+func WithPeerRetry(retries int, backoff time.Duration) HTTPOption {
+	return func(g *GroupHTTP) {
+		g.retries = retries
+		g.retryBackoff = backoff
+	}
+}
+
+/**
+ * @Description: 每隔interval在后台检查一次所有节点的/health,熔断中的节点检查通过后允许试探请求,通过Close停止
+ * @param interval
+ * @return HTTPOption
+ */
+func WithHealthCheck(interval time.Duration) HTTPOption {
+	return func(g *GroupHTTP) {
+		g.healthInterval = interval
+	}
+}
+
+/**
+ * @Description: 通过d发现节点,代替Set的静态节点列表,之后不应再调用Set,节点变化在debounce内没有新的变化才重建一致性hash,通过Close停止
+ * 需要先用Set设置初始节点时使用 Subscribe
+ * @param d
+ * @param debounce <=0时使用默认值1s
+ * @return HTTPOption
+ */
+func WithDiscovery(d Discovery, debounce time.Duration) HTTPOption {
+	return func(g *GroupHTTP) {
+		g.discovery = d
+		g.discoveryDebounce = debounce
+	}
+}
+
+/**
+ * @Description: 开启对冲请求,第一个节点在其延迟的percentile分位(不低于minDelay)内没有返回时,
+ * 向环上的下一个节点发送第二个请求,下一个节点是自己时在本地加载,取先返回的结果
+ * @param percentile 如0.95
+ * @param minDelay
+ * @return HTTPOption
+ */
+func WithHedging(percentile float64, minDelay time.Duration) HTTPOption {
+	return func(g *GroupHTTP) {
+		g.hedging = true
+		g.hedgePercentile = percentile
+		g.hedgeMinDelay = minDelay
+	}
+}
+
+/**
+ * @Description: 开启节点之间的TLS,certs的证书同时作为服务端证书和请求其他节点时的客户端证书,
+ * requireClientCert为true时要求其他节点提供由certs的CA签发的证书(双向TLS)
+ * 服务端需要使用 GroupHTTP.ServerTLSConfig(),节点地址需要使用https://
+ * 设置了WithTransport或WithHTTPClient时,客户端的TLS配置需要自行设置
+ * @param certs
+ * @param requireClientCert
+ * @return HTTPOption
+ */
+func WithTLS(certs *CertReloader, requireClientCert bool) HTTPOption {
+	return func(g *GroupHTTP) {
+		g.certs = certs
+		g.requireClientCert = requireClientCert
+	}
+}
+
+/**
+ * @Description: 开启节点请求的认证,请求其他节点时自动签名,节点服务拒绝没有通过验证(401)或者没有该group权限(403)的请求
+ * authz为nil时只认证不授权,/health不需要认证,/metrics见 WithPublicMetrics
+ * @param auth
+ * @param authz
+ * @return HTTPOption
+ */
+func WithAuth(auth Authenticator, authz Authorizer) HTTPOption {
+	return func(g *GroupHTTP) {
+		g.auth = auth
+		g.authz = authz
+	}
+}
+
+/**
+ * @Description: 没有配置 WithAuth 时也接受其他节点转发的PUT和DELETE,只应该在可信的网络中使用,
+ * 默认拒绝(403),否则任何能访问节点端口的人都可以写入和删除
+ * @return HTTPOption
+ */
+func WithUnauthenticatedWrites() HTTPOption {
+	return func(g *GroupHTTP) {
+		g.unauthWrites = true
+	}
+}
+
+/**
+ * @Description: 配置了 WithAuth 或 WithAdmin 时,/metrics 也不需要认证,如Prometheus无法签名请求时使用
+ * 默认需要通过节点认证或者带上管理接口的token(Authorization: Bearer <token>)
+ * @return HTTPOption
+ */
+func WithPublicMetrics() HTTPOption {
+	return func(g *GroupHTTP) {
+		g.publicMetrics = true
+	}
+}
+
+/**
+ * @Description: 开启管理接口 /_admin/,请求需要带上 Authorization: Bearer <token>,见 admin.go
+ * @param token
+ * @return HTTPOption
+ */
+func WithAdmin(token string) HTTPOption {
+	return func(g *GroupHTTP) {
+		g.adminToken = token
+	}
+}
+
+/**
+ * @Description: 节点之间请求的路径前缀,默认为 /cache/,集群中所有节点需要一致
+ * @param prefix 必须以/开头和结尾
+ * @return HTTPOption
+ */
+func WithBasePath(prefix string) HTTPOption {
+	return func(g *GroupHTTP) {
+		g.prefix = prefix
+	}
+}
+
+/**
+ * @Description: 一致性hash上每个节点的虚拟节点数,默认为50,集群中所有节点需要一致
+ * @param replicas
+ * @return HTTPOption
+ */
+func WithReplicas(replicas int) HTTPOption {
+	return func(g *GroupHTTP) {
+		g.replicas = replicas
+	}
+}
+
+/**
+ * @Description: Server的可选配置
+ */
+type ServerOption func(s *Server)
+
+/**
+ * @Description: 同时开启REST API服务,和节点服务一起启动和关闭
+ * @param addr 监听地址
+ * @param handler 一般为 NewAPIHandler
+ * @return ServerOption
+ */
+func WithAPIServer(addr string, handler http.Handler) ServerOption {
+	return func(s *Server) {
+		s.api = &http.Server{Addr: addr, Handler: handler}
+	}
+}
+
+/**
+ * @Description: 启动时从dir恢复缓存,关闭时把缓存保存到dir,每个group一个文件
+ * @param dir
+ * @return ServerOption
+ */
+func WithSnapshotDir(dir string) ServerOption {
+	return func(s *Server) {
+		s.snapshotDir = dir
+	}
+}
+
+/**
+ * @Description: 关闭时通过gossip广播本节点离开,见 JoinGossip
+ * @param m
+ * @return ServerOption
+ */
+func WithGossip(m *gossip.Memberlist) ServerOption {
+	return func(s *Server) {
+		s.gossip = m
+	}
+}
+
+/**
+ * @Description: REST API(NewAPIHandler)的可选配置
+ */
+type APIOption func(h *APIHandler)
+
+/**
+ * @Description: 开启REST API的认证,拒绝没有通过验证(401)或者没有该group权限(403)的请求,
+ * 统计信息只返回有权限的group;authz为nil时只认证不授权
+ * @param auth 如 NewBearerAuth("", tokens)
+ * @param authz
+ * @return APIOption
+ */
+func WithAPIAuth(auth Authenticator, authz Authorizer) APIOption {
+	return func(h *APIHandler) {
+		h.auth = auth
+		h.authz = authz
+	}
+}
+
+/**
+ * @Description: 没有配置 WithAPIAuth 时也接受PUT和DELETE,只应该在可信的网络中使用,
+ * 默认拒绝(403),没有认证的REST API是只读的
+ * @return APIOption
+ */
+func WithAPIUnauthenticatedWrites() APIOption {
+	return func(h *APIHandler) {
+		h.unauthWrites = true
+	}
+}
+
+/**
+ * @Description: TCP协议前端(RESPServer,MemcacheServer)的可选配置
+ */
+type FrontendOption func(s *tcpServer)
+
+/**
+ * @Description: 限制一条命令的参数个数(如MGET的key数)和单个值的字节数,<=0时使用默认值
+ * @param maxArgs 默认1024
+ * @param maxValueBytes 默认1MB
+ * @return FrontendOption
+ */
+func WithFrontendLimits(maxArgs, maxValueBytes int) FrontendOption {
+	return func(s *tcpServer) {
+		if maxArgs > 0 {
+			s.maxArgs = maxArgs
+		}
+		if maxValueBytes > 0 {
+			s.maxValue = maxValueBytes
+		}
+	}
+}
+
+/**
+ * @Description: 限制一条命令所有参数的总字节数,在认证之前读取命令时检查,超过时返回错误并关闭连接,
+ * 防止未认证的连接用参数个数乘以单个值的上限占用内存;<=0时使用默认值,小于单个值的上限时使用单个值的上限
+ * @param n 默认4MB
+ * @return FrontendOption
+ */
+func WithFrontendMaxCommandBytes(n int) FrontendOption {
+	return func(s *tcpServer) {
+		if n > 0 {
+			s.maxCommand = n
+		}
+	}
+}
+
+/**
+ * @Description: 一条多key读取命令(MGET,get)同时读取的key数,默认16
+ * @param n
+ * @return FrontendOption
+ */
+func WithFetchConcurrency(n int) FrontendOption {
+	return func(s *tcpServer) {
+		if n > 0 {
+			s.workers = n
+		}
+	}
+}
+
+/**
+ * @Description: 开启认证,连接需要先用tokens中的密码登录(RESP的AUTH命令,memcached的认证set),
+ * 之后每条命令按group授权,authz为nil时登录后可以访问所有group
+ * @param tokens 密码 => principal
+ * @param authz 和节点请求使用同一个Authorizer,如 AllowGroups
+ * @return FrontendOption
+ */
+func WithFrontendAuth(tokens map[string]string, authz Authorizer) FrontendOption {
+	return func(s *tcpServer) {
+		s.tokens = tokens
+		s.authz = authz
+	}
+}
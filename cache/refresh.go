@@ -0,0 +1,92 @@
+package cache
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"time"
+)
+
+/**
+ * @Description: 过期检查,stale-while-revalidate 以及 XFetch 提前刷新
+ */
+
+//timeNow 获取当前时间,测试时可以替换
+var timeNow = time.Now
+
+/**
+ * @Description: 检查缓存值是否还能返回
+ * 1. 未过期:直接返回,如果开启了提前刷新,按概率在后台刷新
+ * 2. 已过期但在宽限期内:返回旧值,同时在后台刷新
+ * 3. 超过宽限期:c中仍然是这个过期的值时删除(后台刷新可能已经写入了新值),需要重新load;remoteCache中带版本的副本不删除,load时向owner重新验证
+ * @receiver g
+ * @param c 值所在的缓存
+ * @param key
+ * @param v
+ * @return ByteView
+ * @return bool
+ */
+func (g *Group) checkExpire(c *cache, key string, v ByteView) (ByteView, bool) {
+	if v.expire.IsZero() {
+		return v, true
+	}
+	now := timeNow()
+	if !v.expired(now) {
+		if g.shouldRefreshEarly(v, now) {
+			g.refreshAsync(key)
+		}
+		return v, true
+	}
+	if g.staleGrace > 0 && now.Before(v.expire.Add(g.staleGrace)) {
+		g.refreshAsync(key)
+		return v, true
+	}
+	//带版本的remoteCache副本保留到重新验证,见 getRemote
+	if c != &g.remoteCache || v.version == 0 {
+		c.removeExpired(key, v)
+	}
+	return ByteView{}, false
+}
+
+/**
+ * @Description: XFetch算法,越接近过期时间,加载越慢的值,越有可能被提前刷新
+ * 满足 now - delta*beta*ln(rand()) >= expire 时刷新
+ * @receiver g
+ * @param v
+ * @param now
+ * @return bool
+ */
+func (g *Group) shouldRefreshEarly(v ByteView, now time.Time) bool {
+	if g.earlyBeta <= 0 || v.delta <= 0 {
+		return false
+	}
+	gap := time.Duration(float64(v.delta) * g.earlyBeta * -math.Log(rand.Float64()))
+	return !now.Add(gap).Before(v.expire)
+}
+
+/**
+ * @Description: 在后台刷新key,同一个key同时只会有一个后台刷新,并且和前台的load共用singleflight
+ * @receiver g
+ * @param key
+ */
+func (g *Group) refreshAsync(key string) {
+	g.refreshMu.Lock()
+	if g.refreshing == nil {
+		g.refreshing = make(map[string]struct{})
+	}
+	if _, ok := g.refreshing[key]; ok {
+		g.refreshMu.Unlock()
+		return
+	}
+	g.refreshing[key] = struct{}{}
+	g.refreshMu.Unlock()
+
+	go func() {
+		defer func() {
+			g.refreshMu.Lock()
+			delete(g.refreshing, key)
+			g.refreshMu.Unlock()
+		}()
+		g.load(context.Background(), key)
+	}()
+}
@@ -0,0 +1,430 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/**
+ * @Description: Redis RESP2协议前端,key为 "group:key",支持 AUTH GET MGET DEL EXISTS TTL PTTL PING ECHO INFO SELECT COMMAND QUIT
+ * GET和MGET通过Group.GetContext读取,和http一样会经过节点选择和加载
+ * 开启 WithFrontendAuth 时连接需要先AUTH,之后访问的每个group都要经过Authorizer授权
+ */
+
+const respBufferSize = 64 << 10 //同时也是inline命令的最大长度
+
+var errRESPProtocol = errors.New("Protocol error")
+
+/**
+ * @Description: RESP协议服务器
+ */
+type RESPServer struct {
+	*tcpServer
+	start time.Time
+}
+
+/**
+ * @Description: 新建一个RESP协议服务器,使用 ListenAndServe 或 Serve 开始服务
+ * @param opts
+ * @return *RESPServer
+ */
+func NewRESPServer(opts ...FrontendOption) *RESPServer {
+	s := &RESPServer{start: time.Now()}
+	s.tcpServer = newTCPServer("resp", s.serveConn, opts...)
+	return s
+}
+
+/**
+ * @Description: 处理一个连接,按顺序执行命令,读缓冲区为空时才flush,支持pipeline
+ * @receiver s
+ * @param ctx
+ * @param conn
+ */
+func (s *RESPServer) serveConn(ctx context.Context, conn net.Conn) {
+	r := bufio.NewReaderSize(conn, respBufferSize)
+	w := bufio.NewWriter(conn)
+	sess := s.newSession()
+	for {
+		args, err := readRESPCommand(r, s.maxArgs, s.maxValue, s.maxCommand)
+		if err != nil {
+			if errors.Is(err, errRESPProtocol) {
+				writeRESPError(w, err.Error())
+				w.Flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		quit := s.exec(ctx, w, sess, args)
+		if quit || r.Buffered() == 0 {
+			if w.Flush() != nil || quit {
+				return
+			}
+		}
+	}
+}
+
+/**
+ * @Description: 读取一条命令,支持RESP数组和空格分隔的inline命令
+ * @param r
+ * @param maxArgs 参数的最大个数,包括命令名
+ * @param maxBulk 单个参数的最大长度
+ * @param maxTotal 所有参数的总长度
+ * @return []string
+ * @return error
+ */
+func readRESPCommand(r *bufio.Reader, maxArgs, maxBulk, maxTotal int) ([]string, error) {
+	line, err := readRESPLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		args := strings.Fields(line)
+		if len(args) > maxArgs {
+			return nil, fmt.Errorf("%w: too many arguments", errRESPProtocol)
+		}
+		return args, nil
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n > maxArgs {
+		return nil, fmt.Errorf("%w: invalid multibulk length", errRESPProtocol)
+	}
+	if n <= 0 {
+		return nil, nil
+	}
+	args := make([]string, 0, n)
+	total := 0
+	for i := 0; i < n; i++ {
+		line, err := readRESPLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, fmt.Errorf("%w: expected '$', got '%.1s'", errRESPProtocol, line)
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 || size > maxBulk {
+			return nil, fmt.Errorf("%w: invalid bulk length", errRESPProtocol)
+		}
+		//分配内存之前检查总长度
+		if total += size; total > maxTotal {
+			return nil, fmt.Errorf("%w: too big command", errRESPProtocol)
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		if buf[size] != '\r' || buf[size+1] != '\n' {
+			return nil, fmt.Errorf("%w: bulk not terminated by CRLF", errRESPProtocol)
+		}
+		args = append(args, string(buf[:size]))
+	}
+	return args, nil
+}
+
+func readRESPLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return "", fmt.Errorf("%w: too big request", errRESPProtocol)
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+func writeRESPSimple(w *bufio.Writer, s string) {
+	w.WriteString("+" + s + "\r\n")
+}
+
+func writeRESPError(w *bufio.Writer, msg string) {
+	writeRESPCodeError(w, "ERR", msg)
+}
+
+/**
+ * @Description: 写入带错误码的错误,如 NOAUTH,NOPERM,WRONGPASS
+ * @param w
+ * @param code
+ * @param msg
+ */
+func writeRESPCodeError(w *bufio.Writer, code, msg string) {
+	//错误信息中不能有换行
+	msg = strings.NewReplacer("\r", " ", "\n", " ").Replace(msg)
+	w.WriteString("-" + code + " " + msg + "\r\n")
+}
+
+/**
+ * @Description: 写入认证和授权的错误
+ * @param w
+ * @param err
+ */
+func writeRESPAuthError(w *bufio.Writer, err error) {
+	if errors.Is(err, ErrForbidden) {
+		writeRESPCodeError(w, "NOPERM", err.Error())
+	} else {
+		writeRESPCodeError(w, "NOAUTH", "Authentication required.")
+	}
+}
+
+func writeRESPInt(w *bufio.Writer, n int64) {
+	w.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+}
+
+func writeRESPBulk(w *bufio.Writer, b []byte) {
+	w.WriteString("$" + strconv.Itoa(len(b)) + "\r\n")
+	w.Write(b)
+	w.WriteString("\r\n")
+}
+
+func writeRESPNil(w *bufio.Writer) {
+	w.WriteString("$-1\r\n")
+}
+
+func writeRESPArray(w *bufio.Writer, n int) {
+	w.WriteString("*" + strconv.Itoa(n) + "\r\n")
+}
+
+/**
+ * @Description: 执行一条命令
+ * @receiver s
+ * @param ctx
+ * @param w
+ * @param sess 连接的认证状态
+ * @param args
+ * @return bool 是否关闭连接
+ */
+func (s *RESPServer) exec(ctx context.Context, w *bufio.Writer, sess *frontendSession, args []string) bool {
+	name := strings.ToLower(args[0])
+	args = args[1:]
+	arity := func(min, max int) bool {
+		if len(args) < min || (max >= 0 && len(args) > max) {
+			writeRESPError(w, fmt.Sprintf("wrong number of arguments for '%s' command", name))
+			return false
+		}
+		return true
+	}
+	//分割key并检查权限
+	lookup := func(arg string) (*Group, string, error) {
+		g, key, err := splitGroupKey(arg)
+		if err != nil {
+			return nil, "", err
+		}
+		if err := sess.allow(g); err != nil {
+			return nil, "", err
+		}
+		return g, key, nil
+	}
+	//多key命令:任何一个key没有权限时整条命令失败,不存在的group跳过
+	lookupAll := func() ([]*Group, []string, bool) {
+		groups, keys := make([]*Group, len(args)), make([]string, len(args))
+		for i, arg := range args {
+			g, key, err := lookup(arg)
+			if errors.Is(err, ErrForbidden) {
+				writeRESPAuthError(w, err)
+				return nil, nil, false
+			}
+			groups[i], keys[i] = g, key
+		}
+		return groups, keys, true
+	}
+	if !sess.authed && name != "auth" {
+		writeRESPAuthError(w, ErrUnauthenticated)
+		return false
+	}
+	switch name {
+	case "auth":
+		if !arity(1, 2) {
+			break
+		}
+		if s.tokens == nil {
+			writeRESPError(w, "AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+			break
+		}
+		user, password := "", args[0]
+		if len(args) == 2 {
+			user, password = args[0], args[1]
+		}
+		if err := sess.login(user, password); err != nil {
+			writeRESPCodeError(w, "WRONGPASS", "invalid username-password pair or user is disabled.")
+			break
+		}
+		writeRESPSimple(w, "OK")
+	case "ping":
+		if !arity(0, 1) {
+			break
+		}
+		if len(args) == 1 {
+			writeRESPBulk(w, []byte(args[0]))
+		} else {
+			writeRESPSimple(w, "PONG")
+		}
+	case "echo":
+		if arity(1, 1) {
+			writeRESPBulk(w, []byte(args[0]))
+		}
+	case "quit":
+		writeRESPSimple(w, "OK")
+		return true
+	case "select":
+		if !arity(1, 1) {
+			break
+		}
+		if args[0] != "0" {
+			writeRESPError(w, "DB index is out of range")
+		} else {
+			writeRESPSimple(w, "OK")
+		}
+	case "command":
+		//redis-cli启动时会发送COMMAND DOCS,返回空数组即可
+		writeRESPArray(w, 0)
+	case "get":
+		if !arity(1, 1) {
+			break
+		}
+		g, key, err := lookup(args[0])
+		if errors.Is(err, ErrForbidden) {
+			writeRESPAuthError(w, err)
+			break
+		}
+		if err != nil {
+			writeRESPError(w, err.Error())
+			break
+		}
+		v, err := g.GetContext(ctx, key)
+		if errors.Is(err, ErrNotFound) {
+			writeRESPNil(w)
+			break
+		}
+		if err != nil {
+			writeRESPError(w, err.Error())
+			break
+		}
+		writeRESPBulk(w, v.value)
+	case "mget":
+		if !arity(1, -1) {
+			break
+		}
+		groups, keys, ok := lookupAll()
+		if !ok {
+			break
+		}
+		//最多WithFetchConcurrency个key并发读取,读取失败的key返回nil
+		values := make([][]byte, len(args))
+		parallel(s.workers, len(args), func(i int) {
+			if groups[i] == nil {
+				return
+			}
+			if v, err := groups[i].GetContext(ctx, keys[i]); err == nil {
+				values[i] = v.value
+			}
+		})
+		writeRESPArray(w, len(values))
+		for _, v := range values {
+			if v == nil {
+				writeRESPNil(w)
+			} else {
+				writeRESPBulk(w, v)
+			}
+		}
+	case "del", "exists":
+		if !arity(1, -1) {
+			break
+		}
+		groups, keys, ok := lookupAll()
+		if !ok {
+			break
+		}
+		var n int64
+		var failed error
+		for i, g := range groups {
+			if g == nil {
+				continue
+			}
+			key := keys[i]
+			if name == "del" {
+				existed, err := frontendDelete(ctx, g, key)
+				if err != nil {
+					failed = err
+					break
+				}
+				if existed {
+					n++
+				}
+			} else if _, ok := g.peek(key); ok {
+				n++
+			}
+		}
+		if failed != nil {
+			writeRESPError(w, failed.Error())
+			break
+		}
+		writeRESPInt(w, n)
+	case "ttl", "pttl":
+		if !arity(1, 1) {
+			break
+		}
+		g, key, err := lookup(args[0])
+		if errors.Is(err, ErrForbidden) {
+			writeRESPAuthError(w, err)
+			break
+		}
+		if err != nil {
+			writeRESPError(w, err.Error())
+			break
+		}
+		v, ok := g.peek(key)
+		switch {
+		case !ok:
+			writeRESPInt(w, -2)
+		case v.expire.IsZero():
+			writeRESPInt(w, -1)
+		case name == "ttl":
+			writeRESPInt(w, int64((v.expire.Sub(timeNow())+time.Second/2)/time.Second))
+		default:
+			writeRESPInt(w, int64(v.expire.Sub(timeNow())/time.Millisecond))
+		}
+	case "info":
+		writeRESPBulk(w, []byte(s.info(sess)))
+	default:
+		writeRESPError(w, fmt.Sprintf("unknown command '%s'", name))
+	}
+	return false
+}
+
+/**
+ * @Description: INFO命令的内容,每个group一行,只包括连接有权限的group
+ * @receiver s
+ * @param sess
+ * @return string
+ */
+func (s *RESPServer) info(sess *frontendSession) string {
+	s.mu.Lock()
+	clients := len(s.conns)
+	s.mu.Unlock()
+
+	var b strings.Builder
+	b.WriteString("# Server\r\n")
+	b.WriteString("server:cache\r\n")
+	fmt.Fprintf(&b, "uptime_in_seconds:%d\r\n", int64(time.Since(s.start)/time.Second))
+	b.WriteString("\r\n# Clients\r\n")
+	fmt.Fprintf(&b, "connected_clients:%d\r\n", clients)
+	b.WriteString("\r\n# Groups\r\n")
+	for _, g := range allGroups() {
+		if sess.allow(g) != nil {
+			continue
+		}
+		st := g.Stats()
+		fmt.Fprintf(&b, "%s:keys=%d,bytes=%d,gets=%d,hits=%d,loads=%d,peer_loads=%d\r\n",
+			st.Name, st.MainCache.Items+st.RemoteCache.Items, st.MainCache.Bytes+st.RemoteCache.Bytes,
+			st.Gets, st.CacheHits+st.RemoteCacheHits, st.LocalLoads, st.PeerLoads)
+	}
+	return b.String()
+}
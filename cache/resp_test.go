@@ -0,0 +1,331 @@
+package cache
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+/**
+ * @Description: 测试用的RESP客户端
+ */
+type respClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dialRESP(t *testing.T, addr string) *respClient {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return &respClient{conn: conn, r: bufio.NewReader(conn)}
+}
+
+func (c *respClient) send(args ...string) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	c.conn.Write([]byte(b.String()))
+}
+
+/**
+ * @Description: 读取一个回复,简单字符串和批量字符串返回string,整数返回int64,nil返回nil,错误返回error,数组返回[]interface{}
+ */
+func (c *respClient) read() interface{} {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	switch line[0] {
+	case '+':
+		return line[1:]
+	case '-':
+		return errors.New(line[1:])
+	case ':':
+		n, _ := strconv.ParseInt(line[1:], 10, 64)
+		return n
+	case '$':
+		n, _ := strconv.Atoi(line[1:])
+		if n < 0 {
+			return nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return err
+		}
+		return string(buf[:n])
+	case '*':
+		n, _ := strconv.Atoi(line[1:])
+		items := make([]interface{}, n)
+		for i := range items {
+			items[i] = c.read()
+		}
+		return items
+	}
+	return fmt.Errorf("unexpected reply %q", line)
+}
+
+func (c *respClient) do(args ...string) interface{} {
+	c.send(args...)
+	return c.read()
+}
+
+func startRESP(t *testing.T, opts ...FrontendOption) (*RESPServer, string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewRESPServer(opts...)
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(l) }()
+	t.Cleanup(func() {
+		s.Close()
+		if err := <-done; err != ErrServerClosed {
+			t.Errorf("expected ErrServerClosed, got %v", err)
+		}
+	})
+	return s, l.Addr().String()
+}
+
+func TestRESPServer(t *testing.T) {
+	var loads int32
+	NewGroup("resp", 1<<10, GetterFunc(func(key string) ([]byte, error) {
+		atomic.AddInt32(&loads, 1)
+		if key == "missing" {
+			return nil, errors.New("not found")
+		}
+		return []byte("v-" + key), nil
+	}), WithTTL(time.Minute))
+	_, addr := startRESP(t)
+	c := dialRESP(t, addr)
+
+	cases := []struct {
+		args   []string
+		expect interface{}
+	}{
+		{[]string{"PING"}, "PONG"},
+		{[]string{"ping", "hi"}, "hi"},
+		{[]string{"SELECT", "0"}, "OK"},
+		{[]string{"TTL", "resp:a"}, int64(-2)},
+		{[]string{"EXISTS", "resp:a"}, int64(0)},
+		{[]string{"GET", "resp:a"}, "v-a"},
+		{[]string{"TTL", "resp:a"}, int64(60)},
+		{[]string{"EXISTS", "resp:a", "resp:b", "nogroup:a"}, int64(1)},
+		{[]string{"MGET", "resp:a", "resp:b", "resp:missing", "bad"}, []interface{}{"v-a", "v-b", nil, nil}},
+		{[]string{"DEL", "resp:a", "resp:c"}, int64(1)},
+		{[]string{"EXISTS", "resp:a"}, int64(0)},
+	}
+	for _, cs := range cases {
+		got := c.do(cs.args...)
+		if fmt.Sprint(got) != fmt.Sprint(cs.expect) {
+			t.Fatalf("%v: expected %v, got %v", cs.args, cs.expect, got)
+		}
+	}
+	//DEL之后重新加载
+	c.do("GET", "resp:a")
+	if n := atomic.LoadInt32(&loads); n != 4 {
+		t.Fatalf("expected 4 loads, got %d", n)
+	}
+
+	for _, args := range [][]string{{"GET", "resp:missing"}, {"GET", "nogroup:k"}, {"GET"}, {"FLUSHALL"}} {
+		if _, ok := c.do(args...).(error); !ok {
+			t.Fatalf("%v: expected error reply", args)
+		}
+	}
+	if info, _ := c.do("INFO").(string); !strings.Contains(info, "connected_clients:1") || !strings.Contains(info, "resp:keys=") {
+		t.Fatalf("unexpected info: %q", info)
+	}
+}
+
+func TestRESPPipelineAndInline(t *testing.T) {
+	NewGroup("resp-pipe", 1<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	_, addr := startRESP(t)
+	c := dialRESP(t, addr)
+
+	//一次写入多条命令
+	c.send("GET", "resp-pipe:1")
+	c.send("GET", "resp-pipe:2")
+	c.conn.Write([]byte("PING\r\nGET resp-pipe:3\r\n"))
+	for _, expect := range []string{"1", "2", "PONG", "3"} {
+		if got := c.read(); got != expect {
+			t.Fatalf("expected %q, got %v", expect, got)
+		}
+	}
+
+	//协议错误后关闭连接
+	c.conn.Write([]byte("*1\r\n+GET\r\n"))
+	if _, ok := c.read().(error); !ok {
+		t.Fatal("expected protocol error")
+	}
+	if _, err := c.r.ReadByte(); err == nil {
+		t.Fatal("expected connection to be closed")
+	}
+
+	c = dialRESP(t, addr)
+	if got := c.do("QUIT"); got != "OK" {
+		t.Fatalf("expected OK, got %v", got)
+	}
+	if _, err := c.r.ReadByte(); err == nil {
+		t.Fatal("expected connection to be closed after QUIT")
+	}
+}
+
+func TestRESPAuth(t *testing.T) {
+	NewGroup("resp-auth", 1<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	NewGroup("resp-secret", 1<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	_, addr := startRESP(t, WithFrontendAuth(map[string]string{"s3cret": "app"},
+		AllowGroups(map[string][]string{"app": {"resp-auth"}})))
+	c := dialRESP(t, addr)
+
+	cases := []struct {
+		args   []string
+		expect string
+	}{
+		{[]string{"GET", "resp-auth:a"}, "NOAUTH Authentication required."},
+		{[]string{"PING"}, "NOAUTH Authentication required."},
+		{[]string{"AUTH", "wrong"}, "WRONGPASS invalid username-password pair or user is disabled."},
+		{[]string{"AUTH", "other", "s3cret"}, "WRONGPASS invalid username-password pair or user is disabled."},
+		{[]string{"AUTH", "app", "s3cret"}, "OK"},
+		{[]string{"GET", "resp-auth:a"}, "a"},
+		{[]string{"GET", "resp-secret:a"}, "NOPERM forbidden: no permission for group resp-secret"},
+		{[]string{"MGET", "resp-auth:a", "resp-secret:a"}, "NOPERM forbidden: no permission for group resp-secret"},
+		{[]string{"DEL", "resp-secret:a"}, "NOPERM forbidden: no permission for group resp-secret"},
+		{[]string{"TTL", "resp-secret:a"}, "NOPERM forbidden: no permission for group resp-secret"},
+		{[]string{"DEL", "resp-auth:a"}, "1"},
+	}
+	for _, cs := range cases {
+		if got := fmt.Sprint(c.do(cs.args...)); got != cs.expect {
+			t.Fatalf("%v: expected %q, got %q", cs.args, cs.expect, got)
+		}
+	}
+	//INFO只包括有权限的group
+	if info, _ := c.do("INFO").(string); !strings.Contains(info, "resp-auth:keys=") || strings.Contains(info, "resp-secret") {
+		t.Fatalf("expected INFO to list only authorized groups, got %q", info)
+	}
+
+	//没有配置认证时AUTH返回错误,其他命令不受影响
+	_, addr = startRESP(t)
+	c = dialRESP(t, addr)
+	if _, ok := c.do("AUTH", "s3cret").(error); !ok {
+		t.Fatal("expected AUTH to fail without auth configured")
+	}
+	if got := c.do("GET", "resp-secret:b"); got != "b" {
+		t.Fatalf("expected b, got %v", got)
+	}
+}
+
+func TestRESPDeleteFromSource(t *testing.T) {
+	store := newMemStore()
+	store.Set("a", []byte("v"))
+	g := NewGroup("resp-delete", 1<<10, store)
+	_, addr := startRESP(t)
+	c := dialRESP(t, addr)
+
+	//配置了Deleter时DEL从数据源删除,不存在于缓存中的key也计入
+	if got := c.do("GET", "resp-delete:a"); got != "v" {
+		t.Fatalf("expected v, got %v", got)
+	}
+	if got := c.do("DEL", "resp-delete:a", "resp-delete:b"); got != int64(2) {
+		t.Fatalf("expected 2 deleted keys, got %v", got)
+	}
+	if _, err := store.Get("a"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected a to be deleted from the source, got %v", err)
+	}
+	if _, ok := g.peek("a"); ok {
+		t.Fatal("expected a to be removed from the cache")
+	}
+
+	store.failNext(1)
+	if _, ok := c.do("DEL", "resp-delete:c").(error); !ok {
+		t.Fatal("expected DEL to fail when the source is down")
+	}
+}
+
+func TestRESPLimits(t *testing.T) {
+	var inflight, peak int32
+	NewGroup("resp-limit", 1<<20, GetterFunc(func(key string) ([]byte, error) {
+		n := atomic.AddInt32(&inflight, 1)
+		defer atomic.AddInt32(&inflight, -1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		return []byte(key), nil
+	}))
+	_, addr := startRESP(t, WithFrontendLimits(64, 16), WithFetchConcurrency(2))
+
+	c := dialRESP(t, addr)
+	args := []string{"MGET"}
+	for i := 0; i < 20; i++ {
+		args = append(args, fmt.Sprintf("resp-limit:%d", i))
+	}
+	got, _ := c.do(args...).([]interface{})
+	if len(got) != 20 || got[19] != "19" {
+		t.Fatalf("unexpected MGET reply: %v", got)
+	}
+	if p := atomic.LoadInt32(&peak); p > 2 {
+		t.Fatalf("expected at most 2 concurrent loads, got %d", p)
+	}
+
+	//参数太多
+	args = args[:1]
+	for i := 0; i < 64; i++ {
+		args = append(args, "resp-limit:x")
+	}
+	if _, ok := c.do(args...).(error); !ok {
+		t.Fatal("expected error for too many arguments")
+	}
+
+	//参数太长
+	c = dialRESP(t, addr)
+	if _, ok := c.do("GET", strings.Repeat("x", 17)).(error); !ok {
+		t.Fatal("expected error for too long argument")
+	}
+}
+
+func TestRESPCommandBytesLimit(t *testing.T) {
+	_, addr := startRESP(t, WithFrontendAuth(map[string]string{"s3cret": "app"}, nil),
+		WithFrontendLimits(16, 1024), WithFrontendMaxCommandBytes(2048))
+
+	//没有认证的连接在读取命令时就被拒绝,不会先读完所有参数
+	c := dialRESP(t, addr)
+	value := strings.Repeat("x", 1000)
+	err, ok := c.do("MSET", "a", value, "b", value, "c", value).(error)
+	if !ok || !strings.Contains(err.Error(), "too big command") {
+		t.Fatalf("expected error for too big command, got %v", err)
+	}
+	if _, ok := c.read().(error); !ok {
+		t.Fatal("expected the connection to be closed")
+	}
+
+	//总长度没有超过限制的命令正常执行
+	c = dialRESP(t, addr)
+	if got := c.do("AUTH", "s3cret"); got != "OK" {
+		t.Fatalf("unexpected AUTH reply: %v", got)
+	}
+	if got := c.do("ECHO", value); got != value {
+		t.Fatalf("unexpected ECHO reply: %.20v", got)
+	}
+}
@@ -0,0 +1,316 @@
+package cache
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"cache/gossip"
+)
+
+/**
+ * @Description: 节点服务的生命周期
+ * Start:    从快照恢复缓存,开始监听,通知其他节点本节点加入
+ * Shutdown: 通知其他节点本节点离开,停止接收请求并等待处理中的请求,等待正在进行的加载,写完write-behind队列,保存快照
+ *
+ * 其他节点收到离开通知后把该节点从一致性hash上移除,收到加入通知后重新加上,
+ * 只接受Set设置过的节点,见 POST /_cluster/leave 和 POST /_cluster/join,
+ * 配置了认证时通知必须由节点自己签名,principal和通知中的节点相同
+ */
+
+const defaultClusterPath = "/_cluster/"
+
+/**
+ * @Description: 节点服务,包括节点之间的http服务和可选的REST API服务
+ */
+type Server struct {
+	node        *GroupHTTP
+	http        *http.Server
+	api         *http.Server       //为nil时不开启,见 WithAPIServer
+	snapshotDir string             //为空时不保存快照,见 WithSnapshotDir
+	gossip      *gossip.Memberlist //不为nil时通过gossip广播离开,不再使用/_cluster/,见 WithGossip
+	errc        chan error
+}
+
+/**
+ * @Description: 新建一个节点服务
+ * @param listen 节点之间http服务的监听地址
+ * @param node
+ * @param opts
+ * @return *Server
+ */
+func NewServer(listen string, node *GroupHTTP, opts ...ServerOption) *Server {
+	s := &Server{
+		node: node,
+		http: &http.Server{Addr: listen, Handler: node, TLSConfig: node.ServerTLSConfig()},
+		errc: make(chan error, 2),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+/**
+ * @Description: 恢复快照,开始监听并通知其他节点,监听失败时返回错误,之后服务的错误见 Err
+ * @receiver s
+ * @return error
+ */
+func (s *Server) Start() error {
+	if s.snapshotDir != "" {
+		for _, g := range allGroups() {
+			n, err := g.RestoreFile(s.snapshotDir)
+			if err != nil {
+				s.node.logger().Warn("restore snapshot failed", "group", g.name, "dir", s.snapshotDir, "err", err)
+				continue
+			}
+			s.node.logger().Info("restore snapshot", "group", g.name, "entries", n)
+		}
+	}
+
+	if err := s.serve(s.http, s.http.TLSConfig); err != nil {
+		return err
+	}
+	if s.api != nil {
+		if err := s.serve(s.api, nil); err != nil {
+			s.http.Close()
+			return err
+		}
+	}
+
+	//重启后通知其他节点把本节点加回一致性hash,使用gossip时由gossip负责
+	if s.gossip == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), s.node.peerTimeout)
+		defer cancel()
+		s.node.announce(ctx, "join")
+	}
+	return nil
+}
+
+func (s *Server) serve(srv *http.Server, config *tls.Config) error {
+	l, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return err
+	}
+	if config != nil {
+		l = tls.NewListener(l, config)
+	}
+	go func() {
+		if err := srv.Serve(l); err != http.ErrServerClosed {
+			s.errc <- err
+		}
+	}()
+	return nil
+}
+
+/**
+ * @Description: 服务异常退出时返回错误
+ * @receiver s
+ * @return <-chan error
+ */
+func (s *Server) Err() <-chan error {
+	return s.errc
+}
+
+/**
+ * @Description: 优雅地关闭节点服务,ctx结束时不再等待,返回遇到的第一个错误
+ * @receiver s
+ * @param ctx
+ * @return error
+ */
+func (s *Server) Shutdown(ctx context.Context) error {
+	log := s.node.logger()
+	var first error
+	record := func(err error) {
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+
+	//先通知其他节点,它们不再把key路由过来,然后停止接收新的请求
+	//通知失败只记录日志,其他节点会通过熔断或者gossip的故障检测发现本节点已经离开
+	s.node.draining.Add(1)
+	if s.gossip != nil {
+		leaveGossip(ctx, s.gossip)
+	} else {
+		s.node.announce(ctx, "leave")
+	}
+	for _, srv := range []*http.Server{s.http, s.api} {
+		if srv != nil {
+			record(srv.Shutdown(ctx))
+		}
+	}
+
+	//其他协议的请求和后台刷新可能还在加载
+	groups := allGroups()
+	for _, g := range groups {
+		record(g.waitLoads(ctx))
+	}
+	//write-behind队列中的写入必须在退出前写入数据源
+	for _, g := range groups {
+		if err := g.Flush(ctx); err != nil {
+			log.Error("flush write behind failed", "group", g.name, "err", err)
+			record(err)
+		}
+	}
+
+	if s.snapshotDir != "" {
+		for _, g := range groups {
+			n, err := g.SnapshotFile(s.snapshotDir)
+			if err != nil {
+				log.Error("save snapshot failed", "group", g.name, "dir", s.snapshotDir, "err", err)
+				record(err)
+				continue
+			}
+			log.Info("save snapshot", "group", g.name, "entries", n)
+		}
+	}
+	s.node.Close()
+	return first
+}
+
+/**
+ * @Description: 通知Set设置的其他节点本节点加入或者离开,失败时只记录日志
+ * @receiver g
+ * @param ctx
+ * @param action join或者leave
+ * @return error 第一个失败的节点的错误
+ */
+func (g *GroupHTTP) announce(ctx context.Context, action string) error {
+	g.mu.Lock()
+	var peers []string
+	for _, m := range g.members {
+		if m != g.addr {
+			peers = append(peers, m)
+		}
+	}
+	g.mu.Unlock()
+
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		first error
+	)
+	for _, peer := range peers {
+		wg.Add(1)
+		go func(peer string) {
+			defer wg.Done()
+			if err := g.notify(ctx, peer, action); err != nil {
+				g.logger().Warn("notify peer failed", "node", g.addr, "peer", peer, "action", action, "err", err)
+				mu.Lock()
+				if first == nil {
+					first = err
+				}
+				mu.Unlock()
+			}
+		}(peer)
+	}
+	wg.Wait()
+	return first
+}
+
+func (g *GroupHTTP) notify(ctx context.Context, peer, action string) error {
+	body := url.Values{"node": {g.addr}}.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, peer+defaultClusterPath+action, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if g.auth != nil {
+		if err := g.auth.Sign(req); err != nil {
+			return err
+		}
+	}
+	res, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("%s returned:%v", action, res.Status)
+	}
+	return nil
+}
+
+/**
+ * @Description: 处理其他节点的加入和离开通知
+ * @receiver g
+ * @param w
+ * @param r
+ */
+func (g *GroupHTTP) serveCluster(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	principal := ""
+	if g.auth != nil {
+		var err error
+		if principal, err = g.auth.Verify(r); err != nil {
+			g.logger().Warn("reject unauthenticated request", "node", g.addr, "path", r.URL.Path, "err", err)
+			http.Error(w, ErrUnauthenticated.Error(), http.StatusUnauthorized)
+			return
+		}
+	}
+	//表单在请求体中,认证时和请求体一起签名
+	node := r.PostFormValue("node")
+	//节点只能以自己的身份加入或者离开
+	if g.auth != nil && principal != node {
+		g.logger().Warn("reject membership change for another node", "node", g.addr, "principal", principal, "peer", node)
+		http.Error(w, ErrForbidden.Error(), http.StatusForbidden)
+		return
+	}
+	var left bool
+	switch r.URL.Path[len(defaultClusterPath):] {
+	case "join":
+	case "leave":
+		left = true
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	known, changed := g.markLeft(node, left)
+	if !known {
+		http.Error(w, "unknown node: "+node, http.StatusNotFound)
+		return
+	}
+	if changed {
+		g.logger().Info("peer membership changed", "node", g.addr, "peer", node, "left", left)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+/**
+ * @Description: 把节点从一致性hash上移除或者加回
+ * @receiver g
+ * @param node
+ * @param left
+ * @return known node是否是Set设置过的其他节点
+ * @return changed 一致性hash是否改变
+ */
+func (g *GroupHTTP) markLeft(node string, left bool) (known, changed bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if node == g.addr || !contains(g.members, node) {
+		return false, false
+	}
+	if g.left[node] == left {
+		return true, false
+	}
+	if left {
+		if g.left == nil {
+			g.left = make(map[string]bool)
+		}
+		g.left[node] = true
+	} else {
+		delete(g.left, node)
+	}
+	g.setRing()
+	return true, true
+}
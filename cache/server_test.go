@@ -0,0 +1,201 @@
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSnapshot(t *testing.T) {
+	advance := fakeClock(t)
+	k, _ := NewKeyring("k1", map[string][]byte{"k1": testKey1})
+	var loads int32
+	g := NewGroup("snapshot", 1<<20, countingGetter(&loads), WithTTL(time.Minute),
+		WithEncryption(k), WithCompression(NewGzipCodec(gzip.BestSpeed), 0, true))
+	g.Get("old")
+	advance(30 * time.Second)
+	g.Get("a")
+	g.Get("b")
+	advance(45 * time.Second) //old已经过期
+
+	var buf bytes.Buffer
+	if n, err := g.Snapshot(&buf); err != nil || n != 2 {
+		t.Fatalf("expected 2 entries, got %d err=%v", n, err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte("a2")) {
+		t.Fatal("snapshot should keep values encrypted")
+	}
+	if _, err := NewGroup("snapshot-other", 1<<20, countingGetter(&loads)).Restore(bytes.NewReader(buf.Bytes())); err == nil {
+		t.Fatal("expected error restoring a snapshot of another group")
+	}
+
+	g.Purge()
+	if n, err := g.Restore(&buf); err != nil || n != 2 {
+		t.Fatalf("expected 2 restored entries, got %d err=%v", n, err)
+	}
+	before := atomic.LoadInt32(&loads)
+	if v, err := g.Get("b"); err != nil || v.String() != "b3" {
+		t.Fatalf("expected restored value, got %q err=%v", v, err)
+	}
+	if atomic.LoadInt32(&loads) != before {
+		t.Fatal("restored value should not be loaded again")
+	}
+}
+
+func TestSnapshotFileEscapesGroupName(t *testing.T) {
+	parent := t.TempDir()
+	dir := filepath.Join(parent, "snapshots")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	var loads int32
+	for _, name := range []string{"../snapshot-escape", "snapshot/nested", ".."} {
+		g := NewGroup(name, 1<<10, countingGetter(&loads))
+		g.Get("k")
+		if n, err := g.SnapshotFile(dir); err != nil || n != 1 {
+			t.Fatalf("%s: expected 1 entry, got %d err=%v", name, n, err)
+		}
+		g.Purge()
+		if n, err := g.RestoreFile(dir); err != nil || n != 1 {
+			t.Fatalf("%s: expected 1 restored entry, got %d err=%v", name, n, err)
+		}
+	}
+	//快照都在dir中,没有写到dir之外
+	if files, _ := os.ReadDir(dir); len(files) != 3 {
+		t.Fatalf("expected 3 snapshots in dir, got %d", len(files))
+	}
+	if files, _ := os.ReadDir(parent); len(files) != 1 {
+		t.Fatalf("expected nothing written outside dir, got %d entries", len(files))
+	}
+}
+
+func clusterDo(g *GroupHTTP, action, node string, signer ...Authenticator) int {
+	r := httptest.NewRequest("POST", defaultClusterPath+action, strings.NewReader(url.Values{"node": {node}}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for _, s := range signer {
+		s.Sign(r)
+	}
+	w := httptest.NewRecorder()
+	g.ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestClusterMembership(t *testing.T) {
+	g := NewGroupHTTP("http://a")
+	g.Set("http://a", "http://b", "http://c")
+	if code := clusterDo(g, "leave", "http://b"); code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", code)
+	}
+	for i := 0; i < 100; i++ {
+		for _, owner := range g.Owners(string(rune('a'+i%26)) + strings.Repeat("x", i)) {
+			if owner == "http://b" {
+				t.Fatal("left node should not own keys")
+			}
+		}
+	}
+	if len(g.Ring()) != 2 || len(g.PeersHealth()) != 2 {
+		t.Fatalf("expected b removed from ring, got %+v", g.Ring())
+	}
+	if code := clusterDo(g, "leave", "http://unknown"); code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown node, got %d", code)
+	}
+	if code := clusterDo(g, "join", "http://b"); code != http.StatusNoContent || len(g.Ring()) != 3 {
+		t.Fatalf("expected b back on ring, got %d %+v", code, g.Ring())
+	}
+
+	auth := NewBearerAuth("t", map[string]string{"t": "b"})
+	g = NewGroupHTTP("http://a", WithAuth(auth, nil))
+	g.Set("http://a", "http://b")
+	if code := clusterDo(g, "leave", "http://b"); code != http.StatusUnauthorized {
+		t.Fatalf("expected unsigned notification rejected, got %d", code)
+	}
+
+	//节点只能让自己离开
+	keys := map[string][]byte{"*": []byte("secret")}
+	g = NewGroupHTTP("http://a", WithAuth(NewHMACAuth("http://a", keys, 0), nil))
+	g.Set("http://a", "http://b", "http://c")
+	b := NewHMACAuth("http://b", keys, 0)
+	if code := clusterDo(g, "leave", "http://c", b); code != http.StatusForbidden || len(g.Ring()) != 3 {
+		t.Fatalf("expected b to be forbidden to remove c, got %d %+v", code, g.Ring())
+	}
+	if code := clusterDo(g, "leave", "http://b", b); code != http.StatusNoContent || len(g.Ring()) != 2 {
+		t.Fatalf("expected b to leave, got %d %+v", code, g.Ring())
+	}
+}
+
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func TestServerShutdown(t *testing.T) {
+	var peer *GroupHTTP
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { peer.ServeHTTP(w, r) }))
+	defer ts.Close()
+	addr := freeAddr(t)
+	self := "http://" + addr
+	peer = NewGroupHTTP(ts.URL)
+	peer.Set(self, ts.URL)
+	node := NewGroupHTTP(self)
+	node.Set(self, ts.URL)
+
+	release := make(chan struct{})
+	g := NewGroup("shutdown", 1<<10, GetterFunc(func(key string) ([]byte, error) {
+		<-release
+		return []byte(key), nil
+	}))
+	store := newMemStore()
+	writes := NewGroup("shutdown-writes", 1<<10, store, WithWriteBehind(0, time.Hour, 0))
+	writes.Set("k", []byte("v"))
+	dir := t.TempDir()
+	s := NewServer(addr, node, WithSnapshotDir(dir))
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := http.Get(self + defaultHealthPath); err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("expected node to be serving, got %v", err)
+	}
+
+	go g.Get("slow")
+	waitFor(t, func() bool { return g.loader.Inflight() == 1 })
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	done := make(chan error)
+	go func() { done <- s.Shutdown(ctx) }()
+
+	waitFor(t, func() bool { return len(peer.Ring()) == 1 })
+	select {
+	case err := <-done:
+		t.Fatalf("shutdown should wait for the in-flight load, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := http.Get(self + defaultHealthPath); err == nil {
+		t.Fatal("expected node to stop listening")
+	}
+	if v, err := store.Get("k"); err != nil || string(v) != "v" {
+		t.Fatalf("expected shutdown to flush write behind, got %q err=%v", v, err)
+	}
+
+	g.Purge()
+	if n, err := g.RestoreFile(dir); err != nil || n != 1 {
+		t.Fatalf("expected the loaded value in the snapshot, got %d err=%v", n, err)
+	}
+}
@@ -14,6 +14,7 @@ type call struct {
 type Group struct {
 	mu sync.Mutex
 	callMap map[string]*call
+	running int //正在执行的fn的个数,包括被Forget的
 }
 
 /**
@@ -44,16 +45,51 @@ func (g *Group) Do(key string, fn func() (interface{}, error)) (v interface{}, e
 	c := new(call)
 	c.wg.Add(1)//调用fu前添加一个锁标记
 	g.callMap[key] = c //将初始化的call添加到映射中,表示,我在
+	g.running++
 	g.mu.Unlock()
 
 	// 执行获取key的函数，并将结果赋值给这个Call
 	c.val, c.err = fn()
 	c.wg.Done()//调用结束,去除说锁标记
 
-	// 重新上锁操作callMap
+	// 重新上锁操作callMap,被Forget后key可能已经对应新的call
 	g.mu.Lock()
-	delete(g.callMap, key)
+	if g.callMap[key] == c {
+		delete(g.callMap, key)
+	}
+	g.running--
 	g.mu.Unlock()
 
 	return c.val, c.err
-}
\ No newline at end of file
+}
+/**
+ * @Description: 正在执行中的fn的数量,包括被Forget的
+ * @receiver g
+ * @return int
+ */
+func (g *Group) Inflight() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.running
+}
+
+/**
+ * @Description: 不再让之后的Do等待key正在执行的fn,之后的Do会重新执行fn,已经在等待的Do仍然得到旧的结果
+ * @receiver g
+ * @param key
+ */
+func (g *Group) Forget(key string) {
+	g.mu.Lock()
+	delete(g.callMap, key)
+	g.mu.Unlock()
+}
+
+/**
+ * @Description: 对所有正在执行的key执行Forget
+ * @receiver g
+ */
+func (g *Group) ForgetAll() {
+	g.mu.Lock()
+	g.callMap = nil
+	g.mu.Unlock()
+}
@@ -0,0 +1,194 @@
+package cache
+
+import (
+	"context"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"cache/lru"
+)
+
+/**
+ * @Description: 缓存快照,保存的是压缩加密后的值,和内存中的形式一致,恢复时不需要重新压缩加密
+ * 文件格式为gob编码的snapshotHeader,之后是Count个snapshotEntry
+ */
+
+const (
+	snapshotVersion   = 1
+	snapshotExt       = ".snapshot"
+	drainPollInterval = 10 * time.Millisecond
+)
+
+type snapshotHeader struct {
+	Version int
+	Group   string
+	Count   int
+}
+
+type snapshotEntry struct {
+	Key      string
+	Value    []byte
+	Expire   time.Time
+	Encoding string
+	Sealed   bool
+	Version  uint64
+}
+
+/**
+ * @Description: 把主缓存中未过期的值写入w,从最久未使用到最近使用,恢复后保持相同的淘汰顺序
+ * @receiver g
+ * @param w
+ * @return int 写入的数量
+ * @return error
+ */
+func (g *Group) Snapshot(w io.Writer) (int, error) {
+	now := timeNow()
+	var entries []snapshotEntry
+	g.cache.mutex.Lock()
+	if g.cache.lru != nil {
+		g.cache.lru.Range(func(key string, value lru.Value, _ time.Time) bool {
+			v := value.(ByteView)
+			if !v.expired(now) {
+				entries = append(entries, snapshotEntry{key, v.value, v.expire, v.encoding, v.sealed, v.version})
+			}
+			return true
+		})
+	}
+	g.cache.mutex.Unlock()
+
+	enc := gob.NewEncoder(w)
+	if err := enc.Encode(snapshotHeader{snapshotVersion, g.name, len(entries)}); err != nil {
+		return 0, err
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if err := enc.Encode(&entries[i]); err != nil {
+			return len(entries) - 1 - i, err
+		}
+	}
+	return len(entries), nil
+}
+
+/**
+ * @Description: 从Snapshot的结果中恢复主缓存,跳过已经过期的值
+ * 加密的值使用当前的Keyring解密,密钥已经被删除的值在读取时当作未命中
+ * @receiver g
+ * @param r
+ * @return int 恢复的数量
+ * @return error
+ */
+func (g *Group) Restore(r io.Reader) (int, error) {
+	dec := gob.NewDecoder(r)
+	var h snapshotHeader
+	if err := dec.Decode(&h); err != nil {
+		return 0, fmt.Errorf("read snapshot header: %w", err)
+	}
+	if h.Version != snapshotVersion {
+		return 0, fmt.Errorf("unsupported snapshot version %d", h.Version)
+	}
+	if h.Group != g.name {
+		return 0, fmt.Errorf("snapshot is for group %s, not %s", h.Group, g.name)
+	}
+	now := timeNow()
+	restored := 0
+	for i := 0; i < h.Count; i++ {
+		var e snapshotEntry
+		if err := dec.Decode(&e); err != nil {
+			return restored, fmt.Errorf("read snapshot entry %d: %w", i, err)
+		}
+		v := ByteView{value: e.Value, expire: e.Expire, encoding: e.Encoding, sealed: e.Sealed, version: e.Version}
+		if v.version == 0 {
+			v.version = g.nextVersion()
+		}
+		g.observeVersion(v.version)
+		if v.expired(now) {
+			continue
+		}
+		g.cache.add(e.Key, v)
+		restored++
+	}
+	return restored, nil
+}
+
+/**
+ * @Description: 快照的文件名,group名按路径段转义,包含 / 或 .. 的group名不会写到dir之外
+ * @param group
+ * @return string
+ */
+func snapshotName(group string) string {
+	return url.PathEscape(group) + snapshotExt
+}
+
+/**
+ * @Description: 写入dir/<group>.snapshot,先写临时文件再重命名,避免留下不完整的快照
+ * @receiver g
+ * @param dir
+ * @return int
+ * @return error
+ */
+func (g *Group) SnapshotFile(dir string) (int, error) {
+	f, err := os.CreateTemp(dir, snapshotName(g.name)+".*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(f.Name())
+	n, err := g.Snapshot(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return 0, err
+	}
+	return n, os.Rename(f.Name(), filepath.Join(dir, snapshotName(g.name)))
+}
+
+/**
+ * @Description: 从dir/<group>.snapshot恢复,文件不存在时什么都不做
+ * @receiver g
+ * @param dir
+ * @return int
+ * @return error
+ */
+func (g *Group) RestoreFile(dir string) (int, error) {
+	f, err := os.Open(filepath.Join(dir, snapshotName(g.name)))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return g.Restore(f)
+}
+
+/**
+ * @Description: 等待正在进行的加载和后台刷新结束
+ * @receiver g
+ * @param ctx
+ * @return error ctx结束时仍有加载未完成
+ */
+func (g *Group) waitLoads(ctx context.Context) error {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for {
+		g.refreshMu.Lock()
+		refreshing := len(g.refreshing)
+		g.refreshMu.Unlock()
+		inflight := g.loader.Inflight()
+		if inflight == 0 && refreshing == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("group %s: %d loads still in flight: %w", g.name, inflight+refreshing, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
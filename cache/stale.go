@@ -0,0 +1,111 @@
+package cache
+
+import (
+	"sync"
+	"time"
+
+	"cache/lru"
+)
+
+/**
+ * @Description: serve-stale-on-error,保存最近过期或被淘汰的值,当加载失败时作为兜底返回
+ */
+
+/**
+ * @Description: stale缓冲区中的值,记录可以返回的截止时间
+ */
+type staleEntry struct {
+	view     ByteView
+	deadline time.Time
+}
+
+func (e *staleEntry) Len() int {
+	return e.view.Len()
+}
+
+/**
+ * @Description: 保存旧值的侧缓冲区,同样使用lru淘汰
+ */
+type staleBuffer struct {
+	mutex    sync.Mutex
+	lru      *lru.LRU
+	maxBytes int64
+	window   time.Duration //过期或淘汰后仍然可以返回的时间
+}
+
+/**
+ * @Description: 放入一个过期或被淘汰的值
+ * 已经过期的值从过期时间开始计算window,未过期就被淘汰的值从淘汰时间开始计算
+ * @receiver s
+ * @param key
+ * @param view
+ * @param now
+ */
+func (s *staleBuffer) put(key string, view ByteView, now time.Time) {
+	base := now
+	if view.expired(now) {
+		base = view.expire
+	}
+	deadline := base.Add(s.window)
+	if !now.Before(deadline) {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.lru == nil {
+		s.lru = lru.New(s.maxBytes, nil)
+	}
+	s.lru.Add(key, &staleEntry{view: view, deadline: deadline})
+}
+
+/**
+ * @Description: 获取旧值,返回的值被标记为stale
+ * @receiver s
+ * @param key
+ * @param now
+ * @return ByteView
+ * @return bool
+ */
+func (s *staleBuffer) get(key string, now time.Time) (ByteView, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.lru == nil {
+		return ByteView{}, false
+	}
+	v, ok := s.lru.Get(key)
+	if !ok {
+		return ByteView{}, false
+	}
+	e := v.(*staleEntry)
+	if !now.Before(e.deadline) {
+		s.lru.Delete(key)
+		return ByteView{}, false
+	}
+	view := e.view
+	view.stale = true
+	return view, true
+}
+
+/**
+ * @Description: 成功加载新值后,旧值就不再需要了
+ * @receiver s
+ * @param key
+ */
+func (s *staleBuffer) remove(key string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.lru == nil {
+		return
+	}
+	s.lru.Delete(key)
+}
+
+/**
+ * @Description: 清空所有旧值
+ * @receiver s
+ */
+func (s *staleBuffer) clear() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.lru = nil
+}
@@ -0,0 +1,149 @@
+package cache
+
+import (
+	"strconv"
+	"sync/atomic"
+)
+
+/**
+ * @Description: Group和缓存的统计信息
+ */
+
+/**
+ * @Description: 原子操作的int64
+ */
+type AtomicInt int64
+
+/**
+ * @Description: 原子加
+ * @receiver i
+ * @param n
+ */
+func (i *AtomicInt) Add(n int64) {
+	atomic.AddInt64((*int64)(i), n)
+}
+
+/**
+ * @Description: 原子读
+ * @receiver i
+ * @return int64
+ */
+func (i *AtomicInt) Get() int64 {
+	return atomic.LoadInt64((*int64)(i))
+}
+
+func (i *AtomicInt) String() string {
+	return strconv.FormatInt(i.Get(), 10)
+}
+
+/**
+ * @Description: Group的计数器,全部是原子操作
+ */
+type Stats struct {
+	Gets              AtomicInt //所有的Get请求,包括来自其他节点的
+	CacheHits         AtomicInt //cache命中
+	RemoteCacheHits   AtomicInt //remoteCache命中
+	Loads             AtomicInt //未命中缓存,需要load的次数(gets - cacheHits - remoteCacheHits)
+	LoadsDeduped      AtomicInt //经过singleflight去重之后真正执行的load次数
+	LoadWaits         AtomicInt //等待其他协程正在进行的load的次数
+	LocalLoads        AtomicInt //getLocally成功的次数
+	LocalLoadErrs     AtomicInt //getLocally失败的次数
+	PeerLoads         AtomicInt //从其他节点获取成功的次数
+	PeerErrors        AtomicInt //从其他节点获取失败的次数
+	ServerRequests    AtomicInt //来自其他节点的请求
+	StaleServes       AtomicInt //加载失败时返回旧值的次数
+	SourceWrites      AtomicInt //写入数据源成功的次数,包括删除
+	SourceWriteErrs   AtomicInt //写入数据源失败的次数,write-behind重试耗尽后丢弃的写入也计入
+	PeerRevalidations AtomicInt //remoteCache的副本向owner重新验证,版本没有变化(304)的次数
+	LeaseRejects      AtomicInt //加载期间key失效,加载到的值没有写入缓存的次数
+	LeaseStaleServes  AtomicInt //其他调用方持有租约正在加载时返回失效前旧值的次数
+}
+
+/**
+ * @Description: cache的统计信息
+ */
+type CacheStats struct {
+	Bytes     int64 //已经使用的内存
+	Items     int64 //缓存的条目数
+	Gets      int64
+	Hits      int64
+	Evictions int64 //因为内存不足而被淘汰的条目数
+}
+
+/**
+ * @Description: Group统计信息的快照
+ */
+type GroupStats struct {
+	Name              string
+	Gets              int64
+	CacheHits         int64
+	RemoteCacheHits   int64
+	Loads             int64
+	LoadsDeduped      int64
+	LoadWaits         int64
+	LocalLoads        int64
+	LocalLoadErrs     int64
+	PeerLoads         int64
+	PeerErrors        int64
+	ServerRequests    int64
+	StaleServes       int64
+	SourceWrites      int64
+	SourceWriteErrs   int64
+	WriteBehind       int64 //write-behind队列中还没有写入数据源的个数
+	PeerRevalidations int64
+	LeaseRejects      int64
+	LeaseStaleServes  int64
+	MainCache         CacheStats
+	RemoteCache       CacheStats
+}
+
+/**
+ * @Description: 返回Group统计信息的快照
+ * @receiver g
+ * @return GroupStats
+ */
+func (g *Group) Stats() GroupStats {
+	return GroupStats{
+		Name:              g.name,
+		Gets:              g.stats.Gets.Get(),
+		CacheHits:         g.stats.CacheHits.Get(),
+		RemoteCacheHits:   g.stats.RemoteCacheHits.Get(),
+		Loads:             g.stats.Loads.Get(),
+		LoadsDeduped:      g.stats.LoadsDeduped.Get(),
+		LoadWaits:         g.stats.LoadWaits.Get(),
+		LocalLoads:        g.stats.LocalLoads.Get(),
+		LocalLoadErrs:     g.stats.LocalLoadErrs.Get(),
+		PeerLoads:         g.stats.PeerLoads.Get(),
+		PeerErrors:        g.stats.PeerErrors.Get(),
+		ServerRequests:    g.stats.ServerRequests.Get(),
+		StaleServes:       g.stats.StaleServes.Get(),
+		SourceWrites:      g.stats.SourceWrites.Get(),
+		SourceWriteErrs:   g.stats.SourceWriteErrs.Get(),
+		WriteBehind:       g.writeBehindLen(),
+		PeerRevalidations: g.stats.PeerRevalidations.Get(),
+		LeaseRejects:      g.stats.LeaseRejects.Get(),
+		LeaseStaleServes:  g.stats.LeaseStaleServes.Get(),
+		MainCache:         g.cache.stats(),
+		RemoteCache:       g.remoteCache.stats(),
+	}
+}
+
+/**
+ * @Description: 返回cache的统计信息
+ * @receiver c
+ * @return CacheStats
+ */
+func (c *cache) stats() CacheStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	s := CacheStats{
+		Gets:      c.nget,
+		Hits:      c.nhit,
+		Evictions: c.nevict,
+	}
+	if c.lru != nil {
+		s.Bytes = c.lru.UsedBytes()
+		s.Items = int64(c.lru.Len())
+	}
+	return s
+}
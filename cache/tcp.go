@@ -0,0 +1,272 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+/**
+ * @Description: TCP协议前端(RESP,memcached)共用的监听和连接管理
+ */
+
+/**
+ * @Description: Serve在Close之后返回的错误
+ */
+var ErrServerClosed = errors.New("cache: server closed")
+
+const (
+	defaultFrontendMaxArgs    = 1024
+	defaultFrontendMaxValue   = 1 << 20
+	defaultFrontendMaxCommand = 4 << 20
+	defaultFetchWorkers       = 16
+)
+
+/**
+ * @Description: 接受连接并为每个连接启动一个协程处理,Close时关闭监听和所有连接
+ */
+type tcpServer struct {
+	name   string //协议名,用于日志
+	handle func(ctx context.Context, conn net.Conn)
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+	ctx       context.Context //Close时取消,用于取消正在进行的load
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+
+	//限制和认证,见 WithFrontendLimits,WithFrontendMaxCommandBytes,WithFetchConcurrency,WithFrontendAuth
+	maxArgs    int
+	maxValue   int
+	maxCommand int //一条命令所有参数的总字节数
+	workers    int
+	tokens     map[string]string //密码 => principal,nil表示不需要认证
+	authz      Authorizer
+}
+
+func newTCPServer(name string, handle func(ctx context.Context, conn net.Conn), opts ...FrontendOption) *tcpServer {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &tcpServer{
+		name:       name,
+		handle:     handle,
+		listeners:  make(map[net.Listener]struct{}),
+		conns:      make(map[net.Conn]struct{}),
+		ctx:        ctx,
+		cancel:     cancel,
+		maxArgs:    defaultFrontendMaxArgs,
+		maxValue:   defaultFrontendMaxValue,
+		maxCommand: defaultFrontendMaxCommand,
+		workers:    defaultFetchWorkers,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	//至少能放下一个最大的值
+	if s.maxCommand < s.maxValue {
+		s.maxCommand = s.maxValue
+	}
+	return s
+}
+
+/**
+ * @Description: 监听addr并处理连接,直到Close
+ * @receiver s
+ * @param addr
+ * @return error
+ */
+func (s *tcpServer) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+/**
+ * @Description: 在l上接受连接并处理,直到Close,Close之后返回ErrServerClosed
+ * @receiver s
+ * @param l
+ * @return error
+ */
+func (s *tcpServer) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+
+	GetLogger().Info("server started", "protocol", s.name, "addr", l.Addr().String())
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			delete(s.listeners, l)
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		if !s.track(conn) {
+			conn.Close()
+			return ErrServerClosed
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer s.untrack(conn)
+			s.handle(s.ctx, conn)
+		}()
+	}
+}
+
+func (s *tcpServer) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	return true
+}
+
+func (s *tcpServer) untrack(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+	conn.Close()
+}
+
+/**
+ * @Description: 关闭监听和所有连接,等待连接的处理协程退出
+ * @receiver s
+ * @return error
+ */
+func (s *tcpServer) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.cancel()
+	s.wg.Wait()
+	return nil
+}
+
+/**
+ * @Description: 把 "group:key" 形式的key解析为group和group中的key
+ * @param s
+ * @return *Group
+ * @return string
+ * @return error
+ */
+func splitGroupKey(s string) (*Group, string, error) {
+	i := strings.IndexByte(s, ':')
+	if i <= 0 || i == len(s)-1 {
+		return nil, "", fmt.Errorf("key must be group:key, got %q", s)
+	}
+	g := GetGroup(s[:i])
+	if g == nil {
+		return nil, "", fmt.Errorf("no such group: %s", s[:i])
+	}
+	return g, s[i+1:], nil
+}
+
+/**
+ * @Description: 用最多workers个协程执行fn(0)到fn(n-1),用于MGET,get以及REST API的批量获取
+ * @param workers
+ * @param n
+ * @param fn
+ */
+func parallel(workers, n int, fn func(i int)) {
+	if workers > n {
+		workers = n
+	}
+	next := int64(-1)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := int(atomic.AddInt64(&next, 1)); i < n; i = int(atomic.AddInt64(&next, 1)) {
+				fn(i)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+/**
+ * @Description: DEL和delete命令删除key,和REST API一样经过DeleteContext,配置了Deleter时转发给owner从数据源删除
+ * @param ctx
+ * @param g
+ * @param key
+ * @return bool key是否存在:没有Deleter时为本节点缓存中是否有这个key,数据源的删除是幂等的,配置了Deleter时总是为true
+ * @return error
+ */
+func frontendDelete(ctx context.Context, g *Group, key string) (bool, error) {
+	_, cached := g.peek(key)
+	if err := g.DeleteContext(ctx, key); err != nil {
+		return false, err
+	}
+	return cached || g.deleter != nil, nil
+}
+
+/**
+ * @Description: 一个连接的认证状态
+ */
+type frontendSession struct {
+	s         *tcpServer
+	principal string
+	authed    bool
+}
+
+func (s *tcpServer) newSession() *frontendSession {
+	return &frontendSession{s: s, authed: s.tokens == nil}
+}
+
+/**
+ * @Description: 用密码登录,user不为空时必须和密码对应的principal相同
+ * @receiver c
+ * @param user
+ * @param password
+ * @return error
+ */
+func (c *frontendSession) login(user, password string) error {
+	principal, ok := lookupToken(c.s.tokens, password)
+	if !ok || (user != "" && user != principal) {
+		return ErrUnauthenticated
+	}
+	c.principal, c.authed = principal, true
+	return nil
+}
+
+/**
+ * @Description: 检查是否可以访问group
+ * @receiver c
+ * @param g
+ * @return error 没有登录为ErrUnauthenticated,没有权限为ErrForbidden
+ */
+func (c *frontendSession) allow(g *Group) error {
+	if !c.authed {
+		return ErrUnauthenticated
+	}
+	if c.s.tokens != nil && c.s.authz != nil && !c.s.authz(c.principal, g.name) {
+		return fmt.Errorf("%w: no permission for group %s", ErrForbidden, g.name)
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package cache
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+/**
+ * @Description: 节点之间的TLS和双向TLS,证书文件变化时自动重新加载,不需要重启
+ */
+
+/**
+ * @Description: 证书加载器,定期检查证书,私钥和CA文件的修改时间,有变化就重新加载
+ * 同一个证书既作为服务端证书,也作为请求其他节点时的客户端证书,CA用于验证对方的证书
+ */
+type CertReloader struct {
+	certFile, keyFile, caFile string
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	pool     *x509.CertPool
+	modTimes [3]time.Time
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+/**
+ * @Description: 新建一个证书加载器,interval>0时在后台每隔interval检查一次文件,通过Close停止
+ * @param certFile PEM格式的证书
+ * @param keyFile PEM格式的私钥
+ * @param caFile PEM格式的CA证书,用于验证其他节点,为空时使用系统CA
+ * @param interval
+ * @return *CertReloader
+ * @return error
+ */
+func NewCertReloader(certFile, keyFile, caFile string, interval time.Duration) (*CertReloader, error) {
+	r := &CertReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		caFile:   caFile,
+		done:     make(chan struct{}),
+	}
+	if _, err := r.Reload(); err != nil {
+		return nil, err
+	}
+	if interval > 0 {
+		go r.watch(interval)
+	}
+	return r, nil
+}
+
+/**
+ * @Description: 文件有变化时重新加载,加载失败时继续使用旧的证书
+ * @receiver r
+ * @return reloaded 是否重新加载了
+ * @return err
+ */
+func (r *CertReloader) Reload() (reloaded bool, err error) {
+	var modTimes [3]time.Time
+	for i, name := range []string{r.certFile, r.keyFile, r.caFile} {
+		if name == "" {
+			continue
+		}
+		info, err := os.Stat(name)
+		if err != nil {
+			return false, err
+		}
+		modTimes[i] = info.ModTime()
+	}
+	r.mu.RLock()
+	unchanged := r.cert != nil && modTimes == r.modTimes
+	r.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("load key pair: %v", err)
+	}
+	var pool *x509.CertPool
+	if r.caFile != "" {
+		pem, err := ioutil.ReadFile(r.caFile)
+		if err != nil {
+			return false, fmt.Errorf("read ca: %v", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return false, errors.New("no certificates found in " + r.caFile)
+		}
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.pool = pool
+	r.modTimes = modTimes
+	r.mu.Unlock()
+	return true, nil
+}
+
+func (r *CertReloader) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+			if _, err := r.Reload(); err != nil {
+				GetLogger().Warn("reload certificate failed", "cert", r.certFile, "err", err)
+			}
+		}
+	}
+}
+
+/**
+ * @Description: 停止后台检查
+ * @receiver r
+ */
+func (r *CertReloader) Close() {
+	r.closeOnce.Do(func() { close(r.done) })
+}
+
+func (r *CertReloader) certificate() *tls.Certificate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert
+}
+
+func (r *CertReloader) certPool() *x509.CertPool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.pool
+}
+
+/**
+ * @Description: 服务端的TLS配置,requireClientCert为true时要求并验证客户端证书(双向TLS)
+ * @receiver r
+ * @param requireClientCert
+ * @return *tls.Config
+ */
+func (r *CertReloader) ServerConfig(requireClientCert bool) *tls.Config {
+	base := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return r.certificate(), nil
+		},
+	}
+	if !requireClientCert {
+		return base
+	}
+	//每次握手使用最新的CA
+	base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		c := base.Clone()
+		c.GetConfigForClient = nil
+		c.ClientAuth = tls.RequireAndVerifyClientCert
+		c.ClientCAs = r.certPool()
+		return c, nil
+	}
+	return base
+}
+
+/**
+ * @Description: 请求其他节点时的TLS配置,带上客户端证书,并使用最新的CA验证服务端证书
+ * @receiver r
+ * @return *tls.Config
+ */
+func (r *CertReloader) ClientConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return r.certificate(), nil
+		},
+		//RootCAs不能动态更新,所以跳过默认的验证,在VerifyConnection中使用最新的CA验证
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("no server certificate")
+			}
+			opts := x509.VerifyOptions{
+				DNSName:       cs.ServerName,
+				Roots:         r.certPool(),
+				Intermediates: x509.NewCertPool(),
+			}
+			for _, cert := range cs.PeerCertificates[1:] {
+				opts.Intermediates.AddCert(cert)
+			}
+			_, err := cs.PeerCertificates[0].Verify(opts)
+			return err
+		},
+	}
+}
+
+/**
+ * @Description: 节点服务的TLS配置,用于http.Server.TLSConfig,没有开启TLS时返回nil
+ * @receiver g
+ * @return *tls.Config
+ */
+func (g *GroupHTTP) ServerTLSConfig() *tls.Config {
+	if g.certs == nil {
+		return nil
+	}
+	return g.certs.ServerConfig(g.requireClientCert)
+}
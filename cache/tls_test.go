@@ -0,0 +1,186 @@
+package cache
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	pb "cache/cachepb"
+)
+
+/**
+ * @Description: 测试用的CA
+ */
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "cache test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+/**
+ * @Description: 签发一个可以同时作为服务端和客户端的127.0.0.1证书,返回PEM格式的证书和私钥
+ */
+func (ca *testCA) issue(t *testing.T, serial int64) (certPEM, keyPEM []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "cache node"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+/**
+ * @Description: 把证书写入临时目录,返回证书,私钥和CA的文件路径
+ */
+func writeCerts(t *testing.T, dir string, ca *testCA, serial int64) (certFile, keyFile, caFile string) {
+	certPEM, keyPEM := ca.issue(t, serial)
+	certFile, keyFile, caFile = filepath.Join(dir, "node.crt"), filepath.Join(dir, "node.key"), filepath.Join(dir, "ca.crt")
+	for name, data := range map[string][]byte{certFile: certPEM, keyFile: keyPEM, caFile: ca.pem} {
+		if err := ioutil.WriteFile(name, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return
+}
+
+func startTLSNode(t *testing.T, g *GroupHTTP) *httptest.Server {
+	server := httptest.NewUnstartedServer(g)
+	server.TLS = g.ServerTLSConfig()
+	server.StartTLS()
+	return server
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca := newTestCA(t)
+	certFile, keyFile, caFile := writeCerts(t, t.TempDir(), ca, 2)
+	certs, err := NewCertReloader(certFile, keyFile, caFile, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	NewGroup("tls", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	owner := NewGroupHTTP("https://owner", WithTLS(certs, true))
+	server := startTLSNode(t, owner)
+	defer server.Close()
+
+	//带有CA签发的证书的节点可以访问
+	client := NewGroupHTTP("https://self", WithTLS(certs, true))
+	client.Set(server.URL)
+	res := &pb.Response{}
+	if err := client.NodeClientMap[server.URL].Get(context.Background(), &pb.Request{Group: "tls", Key: "k"}, res); err != nil {
+		t.Fatal(err)
+	}
+	if string(res.Value) != "k" {
+		t.Fatalf("unexpected value %q", res.Value)
+	}
+
+	//没有客户端证书的请求会被拒绝
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(ca.pem)
+	anonymous := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	if _, err := anonymous.Get(server.URL + "/health"); err == nil {
+		t.Fatal("request without a client certificate should be rejected")
+	}
+
+	//其他CA签发的证书也会被拒绝
+	certFile, keyFile, caFile = writeCerts(t, t.TempDir(), newTestCA(t), 3)
+	other, err := NewCertReloader(certFile, keyFile, caFile, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stranger := NewGroupHTTP("https://stranger", WithTLS(other, true))
+	stranger.Set(server.URL)
+	if err := stranger.NodeClientMap[server.URL].Get(context.Background(), &pb.Request{Group: "tls", Key: "k"}, res); err == nil {
+		t.Fatal("request with a certificate from another CA should be rejected")
+	}
+}
+
+func TestCertReload(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	certFile, keyFile, caFile := writeCerts(t, dir, ca, 2)
+	certs, err := NewCertReloader(certFile, keyFile, caFile, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial := func() int64 {
+		leaf, _ := x509.ParseCertificate(certs.certificate().Certificate[0])
+		return leaf.SerialNumber.Int64()
+	}
+
+	if reloaded, err := certs.Reload(); err != nil || reloaded {
+		t.Fatalf("unchanged files should not be reloaded, reloaded=%v err=%v", reloaded, err)
+	}
+
+	writeCerts(t, dir, ca, 5)
+	later := time.Now().Add(time.Minute)
+	for _, name := range []string{certFile, keyFile, caFile} {
+		os.Chtimes(name, later, later)
+	}
+	if reloaded, err := certs.Reload(); err != nil || !reloaded || serial() != 5 {
+		t.Fatalf("expected new certificate to be loaded, reloaded=%v err=%v serial=%d", reloaded, err, serial())
+	}
+
+	//加载失败时继续使用旧证书
+	ioutil.WriteFile(keyFile, []byte("broken"), 0600)
+	later = later.Add(time.Minute)
+	os.Chtimes(keyFile, later, later)
+	if _, err := certs.Reload(); err == nil || serial() != 5 {
+		t.Fatalf("broken key should keep the old certificate, err=%v serial=%d", err, serial())
+	}
+}
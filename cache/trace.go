@@ -0,0 +1,295 @@
+package cache
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/**
+ * @Description: 分布式追踪的钩子,默认不做任何事,通过 SetTracer 接入具体的实现
+ * 节点之间通过W3C traceparent请求头传递追踪上下文
+ */
+
+const (
+	traceparentHeader   = "traceparent"
+	traceresponseHeader = "traceresponse"
+)
+
+/**
+ * @Description: 追踪上下文,对应W3C traceparent
+ */
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+/**
+ * @Description: 是否是有效的追踪上下文,全零的TraceID和SpanID无效
+ * @receiver sc
+ * @return bool
+ */
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+/**
+ * @Description: 格式化为traceparent: 00-<trace-id>-<span-id>-<flags>
+ * @receiver sc
+ * @return string
+ */
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", hex.EncodeToString(sc.TraceID[:]), hex.EncodeToString(sc.SpanID[:]), flags)
+}
+
+/**
+ * @Description: 解析traceparent请求头
+ * @param s
+ * @return SpanContext
+ * @return error
+ */
+func ParseTraceparent(s string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return sc, fmt.Errorf("invalid traceparent %q", s)
+	}
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, fmt.Errorf("invalid traceparent %q", s)
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, fmt.Errorf("invalid trace id in %q: %v", s, err)
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, fmt.Errorf("invalid span id in %q: %v", s, err)
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return sc, fmt.Errorf("invalid flags in %q: %v", s, err)
+	}
+	sc.Sampled = flags[0]&1 == 1
+	if !sc.IsValid() {
+		return sc, fmt.Errorf("invalid traceparent %q", s)
+	}
+	return sc, nil
+}
+
+type spanContextKey struct{}
+
+/**
+ * @Description: 把追踪上下文放入ctx,之后创建的span会成为它的子span
+ * @param ctx
+ * @param sc
+ * @return context.Context
+ */
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+/**
+ * @Description: 从ctx中取出追踪上下文
+ * @param ctx
+ * @return SpanContext
+ * @return bool
+ */
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	sc, ok := ctx.Value(spanContextKey{}).(SpanContext)
+	return sc, ok && sc.IsValid()
+}
+
+/**
+ * @Description: 一个追踪阶段
+ */
+type Span interface {
+	//设置属性,成对的键值
+	SetAttributes(keysAndValues ...interface{})
+	//结束span,err不为nil表示该阶段失败
+	End(err error)
+	//span的追踪上下文,用于向其他节点传递
+	SpanContext() SpanContext
+}
+
+/**
+ * @Description: 追踪接口,Start创建一个span,并返回带有该span追踪上下文的ctx
+ */
+type Tracer interface {
+	Start(ctx context.Context, name string, keysAndValues ...interface{}) (context.Context, Span)
+}
+
+/**
+ * @Description: 不做任何事的Tracer,作为默认值
+ */
+type NopTracer struct{}
+
+func (NopTracer) Start(ctx context.Context, name string, keysAndValues ...interface{}) (context.Context, Span) {
+	sc, _ := SpanContextFromContext(ctx)
+	return ctx, nopSpan{sc}
+}
+
+//nopSpan 透传上游的追踪上下文
+type nopSpan struct {
+	sc SpanContext
+}
+
+func (nopSpan) SetAttributes(keysAndValues ...interface{}) {}
+func (nopSpan) End(err error)                              {}
+func (s nopSpan) SpanContext() SpanContext                 { return s.sc }
+
+//全局Tracer,保存的是tracerHolder,保证atomic.Value中的类型一致
+var globalTracer atomic.Value
+
+type tracerHolder struct {
+	Tracer
+}
+
+func init() {
+	globalTracer.Store(tracerHolder{NopTracer{}})
+}
+
+/**
+ * @Description: 设置全局Tracer,nil表示关闭追踪
+ * @param t
+ */
+func SetTracer(t Tracer) {
+	if t == nil {
+		t = NopTracer{}
+	}
+	globalTracer.Store(tracerHolder{t})
+}
+
+/**
+ * @Description: 返回全局Tracer
+ * @return Tracer
+ */
+func GetTracer() Tracer {
+	return globalTracer.Load().(tracerHolder).Tracer
+}
+
+func startSpan(ctx context.Context, name string, keysAndValues ...interface{}) (context.Context, Span) {
+	return GetTracer().Start(ctx, name, keysAndValues...)
+}
+
+/**
+ * @Description: RecordingTracer记录下来的span
+ */
+type RecordedSpan struct {
+	Name       string
+	TraceID    string
+	SpanID     string
+	ParentID   string //根span为空
+	Attributes map[string]interface{}
+	Err        error
+	Start      time.Time
+	End        time.Time
+}
+
+/**
+ * @Description: 把span记录在内存中的Tracer,用于测试
+ */
+type RecordingTracer struct {
+	mu    sync.Mutex
+	spans []RecordedSpan
+}
+
+func NewRecordingTracer() *RecordingTracer {
+	return &RecordingTracer{}
+}
+
+func (t *RecordingTracer) Start(ctx context.Context, name string, keysAndValues ...interface{}) (context.Context, Span) {
+	s := &recordingSpan{tracer: t, data: RecordedSpan{
+		Name:       name,
+		Attributes: make(map[string]interface{}),
+		Start:      time.Now(),
+	}}
+	if parent, ok := SpanContextFromContext(ctx); ok {
+		s.sc.TraceID = parent.TraceID
+		s.sc.Sampled = parent.Sampled
+		s.data.ParentID = hex.EncodeToString(parent.SpanID[:])
+	} else {
+		randomID(s.sc.TraceID[:])
+		s.sc.Sampled = true
+	}
+	randomID(s.sc.SpanID[:])
+	s.data.TraceID = hex.EncodeToString(s.sc.TraceID[:])
+	s.data.SpanID = hex.EncodeToString(s.sc.SpanID[:])
+	s.SetAttributes(keysAndValues...)
+	return ContextWithSpanContext(ctx, s.sc), s
+}
+
+/**
+ * @Description: 返回已经结束的span,按结束顺序
+ * @receiver t
+ * @return []RecordedSpan
+ */
+func (t *RecordingTracer) Spans() []RecordedSpan {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	spans := make([]RecordedSpan, len(t.spans))
+	copy(spans, t.spans)
+	return spans
+}
+
+/**
+ * @Description: 清空已经记录的span
+ * @receiver t
+ */
+func (t *RecordingTracer) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.spans = nil
+}
+
+type recordingSpan struct {
+	tracer *RecordingTracer
+	sc     SpanContext
+	mu     sync.Mutex
+	data   RecordedSpan
+	ended  bool
+}
+
+func (s *recordingSpan) SetAttributes(keysAndValues ...interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		s.data.Attributes[fmt.Sprint(keysAndValues[i])] = keysAndValues[i+1]
+	}
+}
+
+func (s *recordingSpan) End(err error) {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.Err = err
+	s.data.End = time.Now()
+	data := s.data
+	s.mu.Unlock()
+
+	s.tracer.mu.Lock()
+	s.tracer.spans = append(s.tracer.spans, data)
+	s.tracer.mu.Unlock()
+}
+
+func (s *recordingSpan) SpanContext() SpanContext {
+	return s.sc
+}
+
+func randomID(b []byte) {
+	for i := range b {
+		b[i] = byte(rand.Intn(256))
+	}
+	//全零的id无效
+	b[0] |= 1
+}
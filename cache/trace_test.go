@@ -0,0 +1,118 @@
+package cache
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	pb "cache/cachepb"
+)
+
+/**
+ * @Description: 把请求转发到另一个group,避免同一进程中请求和服务的是同一个group
+ */
+type renameNode struct {
+	group string
+	node  NodeClient
+}
+
+func (n renameNode) Get(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	return n.node.Get(ctx, &pb.Request{Group: n.group, Key: in.GetKey(), Version: in.GetVersion()}, out)
+}
+
+func TestTraceparent(t *testing.T) {
+	s := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, err := ParseTraceparent(s)
+	if err != nil || !sc.Sampled || sc.Traceparent() != s {
+		t.Fatalf("round trip failed: %v %+v", err, sc)
+	}
+	for _, bad := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473x-00f067aa0ba902b7-01",
+	} {
+		if _, err := ParseTraceparent(bad); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+func spansByName(spans []RecordedSpan) map[string]RecordedSpan {
+	m := make(map[string]RecordedSpan, len(spans))
+	for _, s := range spans {
+		m[s.Name] = s
+	}
+	return m
+}
+
+func TestTraceLocalGet(t *testing.T) {
+	tracer := NewRecordingTracer()
+	SetTracer(tracer)
+	defer SetTracer(nil)
+
+	g := NewGroup("trace-local", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	g.Get("k")
+
+	spans := spansByName(tracer.Spans())
+	root := spans["cache.Get"]
+	if root.TraceID == "" || root.ParentID != "" || root.Attributes["group"] != "trace-local" {
+		t.Fatalf("unexpected root span %+v", root)
+	}
+	for name, parent := range map[string]string{
+		"cache.lookup": "cache.Get",
+		"cache.load":   "cache.Get",
+		"cache.getter": "cache.load",
+	} {
+		s, ok := spans[name]
+		if !ok || s.TraceID != root.TraceID || s.ParentID != spans[parent].SpanID {
+			t.Errorf("span %s should be a child of %s, got %+v", name, parent, s)
+		}
+	}
+	if spans["cache.lookup"].Attributes["hit"] != "none" || spans["cache.load"].Attributes["singleflight.waited"] != false {
+		t.Errorf("unexpected span attributes %+v %+v", spans["cache.lookup"], spans["cache.load"])
+	}
+}
+
+func TestTracePropagation(t *testing.T) {
+	tracer := NewRecordingTracer()
+	SetTracer(tracer)
+	defer SetTracer(nil)
+
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	})
+	g := NewGroup("trace-remote", 2<<10, getter)
+	NewGroup("trace-owner", 2<<10, getter)
+	owner := NewGroupHTTP("http://owner")
+	server := httptest.NewServer(owner)
+	defer server.Close()
+
+	g.Register(fixedPicker{node: renameNode{group: "trace-owner", node: &httpClient{
+		baseURL: server.URL + defaultPrefix,
+		latency: newHistogram(defaultLatencyBuckets),
+	}}})
+	if v, err := g.Get("k"); err != nil || v.String() != "k" {
+		t.Fatalf("unexpected value %q err=%v", v, err)
+	}
+
+	var peer, serve RecordedSpan
+	for _, s := range tracer.Spans() {
+		switch s.Name {
+		case "cache.peer":
+			peer = s
+		case "cache.serve":
+			serve = s
+		}
+	}
+	if peer.SpanID == "" || serve.SpanID == "" {
+		t.Fatalf("missing peer or serve span: %+v", tracer.Spans())
+	}
+	if serve.TraceID != peer.TraceID || serve.ParentID != peer.SpanID {
+		t.Fatalf("serve span should join the caller's trace, peer=%+v serve=%+v", peer, serve)
+	}
+}
@@ -0,0 +1,146 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+
+	pb "cache/cachepb"
+)
+
+/**
+ * @Description: 值的版本和比较并设置
+ * owner节点每次写入或加载时给值分配一个新的版本,版本随值一起复制到其他节点的remoteCache,
+ * 其他节点用它向owner重新验证(If-None-Match),版本相同时owner返回304,不需要传输value
+ */
+
+const writeLockStripes = 64
+
+/**
+ * @Description: CompareAndSet时owner上的版本和期望的版本不同
+ */
+var ErrVersionConflict = errors.New("version conflict")
+
+/**
+ * @Description: CompareAndSet只能由owner执行,owner熔断中时不会转发给其他节点
+ */
+var ErrOwnerUnavailable = errors.New("owner unavailable")
+
+/**
+ * @Description: 分配一个新的版本,以纳秒时间戳为基础并且严格递增,重启后也不会小于之前分配的版本
+ * @receiver g
+ * @return uint64
+ */
+func (g *Group) nextVersion() uint64 {
+	g.versionMu.Lock()
+	defer g.versionMu.Unlock()
+	v := uint64(timeNow().UnixNano())
+	if v <= g.lastVersion {
+		v = g.lastVersion + 1
+	}
+	g.lastVersion = v
+	return v
+}
+
+/**
+ * @Description: 记录从快照恢复的版本,之后分配的版本都比它大
+ * @receiver g
+ * @param v
+ */
+func (g *Group) observeVersion(v uint64) {
+	g.versionMu.Lock()
+	defer g.versionMu.Unlock()
+	if v > g.lastVersion {
+		g.lastVersion = v
+	}
+}
+
+/**
+ * @Description: 加载后的值的版本,和缓存中还保留的旧值(包括过期的旧值)相同时沿用旧的版本,
+ * 这样过期重新加载后其他节点的副本仍然可以用304重新验证
+ * @receiver g
+ * @param key
+ * @param b 加载到的值
+ * @return uint64
+ */
+func (g *Group) loadVersion(key string, b []byte) uint64 {
+	old, ok := g.cache.peek(key)
+	if !ok && g.stale != nil {
+		old, ok = g.stale.get(key, timeNow())
+	}
+	if ok && old.version != 0 {
+		if u, err := g.unpack(key, old); err == nil && bytes.Equal(u.value, b) {
+			return old.version
+		}
+	}
+	return g.nextVersion()
+}
+
+/**
+ * @Description: 锁住key的写入,按hash分段,owner上同一个key的写入串行执行
+ * @receiver g
+ * @param key
+ * @return func() 解锁
+ */
+func (g *Group) lockKey(key string) func() {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	mu := &g.writeLocks[h.Sum32()%writeLockStripes]
+	mu.Lock()
+	return mu.Unlock
+}
+
+/**
+ * @Description: owner上key的当前版本是version时才写入value,version为0表示key必须不存在
+ * 转发给owner节点执行,没有配置Setter时也会转发,配置了Setter时同Set写入数据源
+ * @receiver g
+ * @param key
+ * @param version 期望的版本,一般为之前Get返回的ByteView.Version()
+ * @param value
+ * @return uint64 写入后的版本
+ * @return error 版本不同时为ErrVersionConflict
+ */
+func (g *Group) CompareAndSet(key string, version uint64, value []byte) (uint64, error) {
+	return g.CompareAndSetContext(context.Background(), key, version, value)
+}
+
+/**
+ * @Description: 同CompareAndSet,ctx用于控制转发给owner节点的请求
+ * @receiver g
+ * @param ctx
+ * @param key
+ * @param version
+ * @param value
+ * @return uint64
+ * @return error
+ */
+func (g *Group) CompareAndSetContext(ctx context.Context, key string, version uint64, value []byte) (uint64, error) {
+	return g.set(ctx, &pb.Request{Group: g.name, Key: key, Version: version, Compare: true}, value)
+}
+
+/**
+ * @Description: 比较key的当前版本,不存在的key版本为0
+ * 只相信本节点缓存中没有过期的值,否则从数据源(包括write-behind队列中还没写入的值)重新加载,
+ * 不使用GetContext可能返回的旧值(WithServeStaleOnError,WithLeaseStale,WithStaleWhileRevalidate)
+ * @receiver g
+ * @param ctx
+ * @param key
+ * @param expect
+ * @return error
+ */
+func (g *Group) compareVersion(ctx context.Context, key string, expect uint64) error {
+	var current uint64
+	if v, ok := g.cache.peek(key); ok && !v.stale && !v.expired(timeNow()) {
+		current = v.version
+	} else if v, err := g.getLocally(ctx, key); err == nil {
+		current = v.version
+	} else if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	if current != expect {
+		return fmt.Errorf("%s: expected version %d, current %d: %w", key, expect, current, ErrVersionConflict)
+	}
+	return nil
+}
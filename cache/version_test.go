@@ -0,0 +1,240 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCompareAndSet(t *testing.T) {
+	store := newMemStore()
+	g := NewGroup("cas", 1<<10, store)
+
+	//version为0表示key必须不存在
+	v1, err := g.CompareAndSet("k", 0, []byte("v1"))
+	if err != nil || v1 == 0 {
+		t.Fatalf("expected first write to succeed, got %d err=%v", v1, err)
+	}
+	if _, err := g.CompareAndSet("k", 0, []byte("v2")); !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("expected conflict, got %v", err)
+	}
+	v, err := g.Get("k")
+	if err != nil || v.Version() != v1 || v.ETag() != formatETag(v1) {
+		t.Fatalf("expected version %d, got %d err=%v", v1, v.Version(), err)
+	}
+
+	v2, err := g.CompareAndSet("k", v1, []byte("v2"))
+	if err != nil || v2 <= v1 {
+		t.Fatalf("expected a newer version than %d, got %d err=%v", v1, v2, err)
+	}
+	if _, err := g.CompareAndSet("k", v1, []byte("v3")); !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("expected conflict with an old version, got %v", err)
+	}
+	if v, _ := store.Get("k"); string(v) != "v2" {
+		t.Fatalf("expected source to keep v2, got %q", v)
+	}
+
+	//普通的Set也分配新的版本
+	if err := g.Set("k", []byte("v4")); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := g.Get("k"); v.Version() <= v2 {
+		t.Fatalf("expected Set to bump the version, got %d", v.Version())
+	}
+}
+
+func TestCompareAndSetIgnoresStaleValue(t *testing.T) {
+	advance := fakeClock(t)
+	store := newMemStore()
+	g := NewGroup("cas-stale", 1<<10, store, WithTTL(time.Minute), WithStaleWhileRevalidate(time.Minute))
+
+	v1, err := g.CompareAndSet("k", 0, []byte("v1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	//数据源被直接修改,缓存中的值过期后在grace期间仍然会被Get返回
+	store.Set("k", []byte("external"))
+	advance(time.Minute + time.Second)
+
+	if _, err := g.CompareAndSet("k", v1, []byte("v2")); !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("expected conflict against the newer source value, got %v", err)
+	}
+	if v, _ := store.Get("k"); string(v) != "external" {
+		t.Fatalf("expected source to keep the external value, got %q", v)
+	}
+	v, err := g.Get("k")
+	if err != nil || v.String() != "external" || v.Version() == v1 {
+		t.Fatalf("expected the reloaded value with a new version, got %q version %d err=%v", v, v.Version(), err)
+	}
+	if _, err := g.CompareAndSet("k", v.Version(), []byte("v2")); err != nil {
+		t.Fatalf("expected the current version to succeed, got %v", err)
+	}
+}
+
+func TestCompareAndSetForwarded(t *testing.T) {
+	owner := NewGroup("cas-owner", 1<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, ErrNotFound
+	}))
+	server := httptest.NewServer(NewGroupHTTP("http://owner", WithUnauthenticatedWrites()))
+	defer server.Close()
+	self := NewGroupHTTP("http://self")
+	self.Set(server.URL)
+
+	//没有Setter时CompareAndSet也转发给owner
+	g := NewGroup("cas-peer", 1<<10, GetterFunc(func(key string) ([]byte, error) { return nil, ErrNotFound }))
+	g.Register(fixedPicker{node: renameWriter{group: "cas-owner", node: self.NodeClientMap[server.URL]}})
+
+	v1, err := g.CompareAndSet("k", 0, []byte("v1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := owner.peek("k"); !ok || v.Version() != v1 {
+		t.Fatalf("expected owner to hold version %d, got %d", v1, v.Version())
+	}
+	_, err = g.CompareAndSet("k", 0, []byte("v2"))
+	if !errors.Is(err, ErrVersionConflict) || statusOf(err) != http.StatusPreconditionFailed {
+		t.Fatalf("expected forwarded conflict, got %v", err)
+	}
+	if _, err := g.CompareAndSet("k", v1, []byte("v2")); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := owner.peek("k"); v.String() != "v2" {
+		t.Fatalf("expected owner to hold v2, got %q", v)
+	}
+}
+
+func TestCompareAndSetOwnerOnly(t *testing.T) {
+	peers := NewGroupHTTP("http://self", WithCircuitBreaker(1, time.Hour), WithUnauthenticatedWrites())
+	peers.Set("http://self", "http://node1")
+	g := NewGroup("cas-owner-only", 1<<10, GetterFunc(func(key string) ([]byte, error) { return nil, ErrNotFound }))
+	g.Register(peers)
+	var key string
+	for i := 0; i < 1000 && key == ""; i++ {
+		if k := strconv.Itoa(i); peers.Owners(k)[0] == "http://node1" {
+			key = k
+		}
+	}
+	if key == "" {
+		t.Fatal("no key owned by node1")
+	}
+
+	//owner熔断时不在下一个节点(本节点)上执行
+	peers.NodeClientMap["http://node1"].health.failure(errors.New("down"), time.Now())
+	_, err := g.CompareAndSet(key, 0, []byte("v"))
+	if !errors.Is(err, ErrOwnerUnavailable) || statusOf(err) != http.StatusServiceUnavailable {
+		t.Fatalf("expected owner unavailable, got %v", err)
+	}
+	if _, ok := g.peek(key); ok {
+		t.Fatal("CompareAndSet should not be applied on a failover node")
+	}
+
+	//转发过来的CompareAndSet也只在owner上执行
+	r := httptest.NewRequest("PUT", defaultPrefix+"cas-owner-only/"+key, strings.NewReader("v"))
+	r.Header.Set("If-None-Match", "*")
+	w := httptest.NewRecorder()
+	peers.ServeHTTP(w, r)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for a forwarded CompareAndSet on a non-owner, got %d", w.Code)
+	}
+}
+
+func TestPeerRevalidation(t *testing.T) {
+	advance := fakeClock(t)
+	var loads int32
+	owner := NewGroup("reval-owner", 1<<10, countingGetter(&loads), WithTTL(time.Minute))
+	server := httptest.NewServer(NewGroupHTTP("http://owner"))
+	defer server.Close()
+	self := NewGroupHTTP("http://self")
+	self.Set(server.URL)
+
+	g := NewGroup("reval-peer", 1<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, errors.New("peer should not load locally")
+	}))
+	node := renameNode{group: "reval-owner", node: self.NodeClientMap[server.URL]}
+	g.Register(fixedPicker{node: node})
+	v, err := g.getRemote(context.Background(), node, "k")
+	if err != nil || v.Version() == 0 {
+		t.Fatalf("expected a versioned value, got %d err=%v", v.Version(), err)
+	}
+	g.store(&g.remoteCache, "k", v)
+
+	//副本过期,owner上的值没有变化,只返回新的过期时间
+	advance(2 * time.Minute)
+	expire := timeNow().Add(time.Hour)
+	owner.touch("k", expire)
+	got, err := g.Get("k")
+	if err != nil || got.String() != "k1" || got.Version() != v.Version() || !got.expire.Equal(expire) {
+		t.Fatalf("expected revalidated k1 until %v, got %q (%d) until %v err=%v", expire, got, got.Version(), got.expire, err)
+	}
+	if s := g.Stats(); s.PeerRevalidations != 1 {
+		t.Fatalf("expected 1 revalidation, got %d", s.PeerRevalidations)
+	}
+
+	//owner上的值变化后返回新的值
+	owner.Set("k", []byte("changed"))
+	owner.touch("k", timeNow().Add(3*time.Hour))
+	advance(2 * time.Hour)
+	got, err = g.Get("k")
+	if err != nil || got.String() != "changed" || got.Version() == v.Version() {
+		t.Fatalf("expected the changed value, got %q (%d) err=%v", got, got.Version(), err)
+	}
+	if s := g.Stats(); s.PeerRevalidations != 1 || atomic.LoadInt32(&loads) != 1 {
+		t.Fatalf("unexpected stats %+v, %d loads", s, loads)
+	}
+}
+
+func TestReloadKeepsVersion(t *testing.T) {
+	advance := fakeClock(t)
+	var loads int32
+	g := NewGroup("reload-version", 1<<10, GetterFunc(func(key string) ([]byte, error) {
+		atomic.AddInt32(&loads, 1)
+		return []byte("same"), nil
+	}), WithTTL(time.Minute), WithStaleWhileRevalidate(time.Minute))
+	v1, _ := g.Get("k")
+
+	//后台刷新到相同的值时沿用旧的版本
+	advance(90 * time.Second)
+	g.Get("k")
+	waitFor(t, func() bool { return atomic.LoadInt32(&loads) == 2 })
+	waitFor(t, func() bool {
+		v, ok := g.peek("k")
+		return ok && v.Version() == v1.Version()
+	})
+}
+
+func TestAPIConditional(t *testing.T) {
+	newTestAPIGroup("api-cas")
+	h := NewAPIHandler("", WithAPIUnauthenticatedWrites())
+
+	w := apiDo(h, "GET", "/api/api-cas/k", "")
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag == "" {
+		t.Fatalf("expected an ETag, got %d %q", w.Code, etag)
+	}
+	if w := apiDo(h, "GET", "/api/api-cas/k", "", "If-None-Match", etag); w.Code != http.StatusNotModified {
+		t.Fatalf("expected 304, got %d", w.Code)
+	}
+
+	w = apiDo(h, "PUT", "/api/api-cas/k", "v2", "If-Match", etag)
+	if w.Code != http.StatusNoContent || w.Header().Get("ETag") == etag {
+		t.Fatalf("expected a new ETag, got %d %q", w.Code, w.Header().Get("ETag"))
+	}
+	if w := apiDo(h, "PUT", "/api/api-cas/k", "v3", "If-Match", etag); w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412 with a stale ETag, got %d", w.Code)
+	}
+	if w := apiDo(h, "PUT", "/api/api-cas/k", "v3", "If-None-Match", "*"); w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412 for an existing key, got %d", w.Code)
+	}
+	if w := apiDo(h, "PUT", "/api/api-cas/missing", "v", "If-None-Match", "*"); w.Code != http.StatusNoContent {
+		t.Fatalf("expected create to succeed, got %d", w.Code)
+	}
+	if w := apiDo(h, "PUT", "/api/api-cas/k", "v3", "If-Match", "bad"); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a bad ETag, got %d", w.Code)
+	}
+}
@@ -1,5 +1,11 @@
 package cache
 
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
 /**
  * @Description: 实现了View接口的缓存结构体
  */
@@ -9,6 +15,12 @@ package cache
  */
 type ByteView struct{
 	value []byte
+	expire time.Time     //过期时间,零值表示永不过期
+	delta  time.Duration //加载该值所花费的时间,用于提前刷新
+	stale  bool          //是否是加载失败时返回的旧值
+	encoding string      //value的压缩格式,空表示未压缩,只出现在缓存内部
+	sealed   bool        //value是否已加密,只出现在缓存内部
+	version  uint64      //owner写入或加载时分配的版本,0表示没有版本
 }
 
 /**
@@ -38,6 +50,74 @@ func (v ByteView) Copy() []byte{
 	return cloneBytes(v.value)
 }
 
+/**
+ * @Description: 返回过期时间,零值表示永不过期
+ * @receiver v ByteView
+ * @return time.Time
+ */
+func (v ByteView) Expire() time.Time {
+	return v.expire
+}
+
+/**
+ * @Description: 是否是加载失败时返回的旧值,见 WithServeStaleOnError
+ * @receiver v ByteView
+ * @return bool
+ */
+func (v ByteView) Stale() bool {
+	return v.stale
+}
+
+/**
+ * @Description: 返回值的版本,由owner节点在写入或加载时分配,同一个key的版本单调递增,用于CompareAndSet
+ * @receiver v ByteView
+ * @return uint64 0表示没有版本
+ */
+func (v ByteView) Version() uint64 {
+	return v.version
+}
+
+/**
+ * @Description: 版本对应的ETag,没有版本时为空
+ * @receiver v ByteView
+ * @return string
+ */
+func (v ByteView) ETag() string {
+	if v.version == 0 {
+		return ""
+	}
+	return formatETag(v.version)
+}
+
+func formatETag(version uint64) string {
+	return `"` + strconv.FormatUint(version, 10) + `"`
+}
+
+/**
+ * @Description: 解析ETag得到版本,忽略弱校验前缀W/
+ * @param etag
+ * @return uint64
+ * @return bool
+ */
+func parseETag(etag string) (uint64, bool) {
+	etag = strings.TrimPrefix(strings.TrimSpace(etag), "W/")
+	if len(etag) < 2 || etag[0] != '"' || etag[len(etag)-1] != '"' {
+		return 0, false
+	}
+	v, err := strconv.ParseUint(etag[1:len(etag)-1], 10, 64)
+	return v, err == nil && v != 0
+}
+
+/**
+ * @Description: 判断在now时刻是否已经过期
+ * @receiver v ByteView
+ * @param now
+ * @return bool
+ */
+func (v ByteView) expired(now time.Time) bool {
+	return !v.expire.IsZero() && !now.Before(v.expire)
+}
+
 func cloneBytes(b []byte) []byte {
 	c:=make([]byte, len(b))
 	copy(c,b)
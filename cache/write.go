@@ -0,0 +1,553 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	pb "cache/cachepb"
+)
+
+/**
+ * @Description: 写数据源,Group.Set和Group.Delete转发给owner节点,owner同步写入(write-through),
+ * 或者放入有序的队列批量异步写入(write-behind),见 WithWriteBehind
+ */
+
+const (
+	defaultWriteBatchSize = 100
+	defaultWriteInterval  = time.Second
+	defaultWriteRetries   = 3
+	defaultWriteBackoff   = 100 * time.Millisecond
+)
+
+/**
+ * @Description: 转发过来的写请求的key不属于本节点,两个节点的一致性hash或者熔断状态不一致
+ */
+var ErrNotOwner = errors.New("not the owner")
+
+/**
+ * @Description: 一次写入,Delete为true时表示删除
+ */
+type Write struct {
+	Key    string
+	Value  []byte
+	Delete bool
+}
+
+/**
+ * @Description: 可选的批量写入接口,Setter实现了它时write-behind按批写入,否则逐个调用Set和Delete
+ * 同一批中同一个key可能出现多次,需要按顺序应用
+ */
+type BatchWriter interface {
+	WriteBatch(writes []Write) error
+}
+
+/**
+ * @Description: 写入数据源失败
+ */
+type writeError struct {
+	key string
+	err error
+}
+
+func (e *writeError) Error() string {
+	return fmt.Sprintf("write %s to source: %v", e.key, e.err)
+}
+
+func (e *writeError) Unwrap() error {
+	return e.err
+}
+
+/**
+ * @Description: 写入key,没有配置Setter的普通Set只写本节点,否则转发给owner节点
+ * @receiver g
+ * @param ctx
+ * @param req Compare为true时比较版本,见 CompareAndSet
+ * @param value
+ * @return uint64 写入后的版本
+ * @return error
+ */
+func (g *Group) set(ctx context.Context, req *pb.Request, value []byte) (uint64, error) {
+	if req.Key == "" {
+		return 0, errors.New("key is required")
+	}
+	if g.setter != nil || req.Compare {
+		if forwarded, version, err := g.forwardWrite(ctx, req, value, false); forwarded {
+			return version, err
+		}
+	}
+	return g.ownerSet(ctx, req, value)
+}
+
+/**
+ * @Description: 在本节点(owner)写入,同一个key的写入串行执行,先写数据源再更新缓存
+ * @receiver g
+ * @param ctx
+ * @param req
+ * @param value
+ * @return uint64
+ * @return error
+ */
+func (g *Group) ownerSet(ctx context.Context, req *pb.Request, value []byte) (uint64, error) {
+	unlock := g.lockKey(req.Key)
+	defer unlock()
+	if req.Compare {
+		if err := g.compareVersion(ctx, req.Key, req.Version); err != nil {
+			return 0, err
+		}
+	}
+	if g.setter != nil {
+		if err := g.writeSource(Write{Key: req.Key, Value: cloneBytes(value)}); err != nil {
+			return 0, err
+		}
+	}
+	return g.setLocally(req.Key, value), nil
+}
+
+/**
+ * @Description: 在本节点(owner)从数据源删除,然后删除缓存
+ * @receiver g
+ * @param key
+ * @return error
+ */
+func (g *Group) ownerDelete(key string) error {
+	unlock := g.lockKey(key)
+	defer unlock()
+	if g.deleter != nil {
+		if err := g.writeSource(Write{Key: key, Delete: true}); err != nil {
+			return err
+		}
+	}
+	g.Remove(key)
+	return nil
+}
+
+/**
+ * @Description: 有owner节点时把写请求转发给owner
+ * @receiver g
+ * @param ctx
+ * @param req
+ * @param value
+ * @param del
+ * @return forwarded 为false时由本节点写入
+ * @return version owner上写入后的版本
+ * @return err
+ */
+func (g *Group) forwardWrite(ctx context.Context, req *pb.Request, value []byte, del bool) (forwarded bool, version uint64, err error) {
+	if g.nodePicker == nil {
+		return false, 0, nil
+	}
+	var node NodeClient
+	if op, ok := g.nodePicker.(OwnerPicker); ok && req.Compare {
+		//CompareAndSet只能由owner执行,owner熔断时不转发给下一个节点
+		owner, self, err := op.PickOwner(req.Key)
+		if err != nil {
+			return true, 0, err
+		}
+		if self {
+			return false, 0, nil
+		}
+		node = owner
+	} else if node, ok = g.nodePicker.PickNode(req.Key); !ok {
+		return false, 0, nil
+	}
+	writer, ok := node.(NodeWriter)
+	if !ok {
+		return true, 0, fmt.Errorf("node %T does not support writes", node)
+	}
+	ctx, span := startSpan(ctx, "cache.forwardWrite", "group", g.name, "key", req.Key, "delete", del)
+	defer func() { span.End(err) }()
+
+	res := &pb.Response{}
+	if del {
+		err = writer.Delete(ctx, req)
+	} else {
+		err = writer.Set(ctx, req, value, res)
+	}
+	if err != nil {
+		return true, 0, err
+	}
+	//本节点上的副本已经过时
+	g.Remove(req.Key)
+	return true, res.Version, nil
+}
+
+/**
+ * @Description: 处理其他节点转发过来的写请求,PUT的body为值,
+ * If-Match为期望的版本,If-None-Match: * 表示key必须不存在
+ * @receiver g
+ * @param r
+ * @param key
+ * @return uint64 PUT写入后的版本
+ * @return error
+ */
+func (g *Group) serveWrite(r *http.Request, key string) (uint64, error) {
+	if r.Method == http.MethodDelete {
+		if !g.ownsKey(key, false) {
+			return 0, fmt.Errorf("%s: %w", key, ErrNotOwner)
+		}
+		return 0, g.ownerDelete(key)
+	}
+	value, err := ioutil.ReadAll(io.LimitReader(r.Body, apiMaxValueBytes+1))
+	if err != nil {
+		return 0, err
+	}
+	if len(value) > apiMaxValueBytes {
+		return 0, fmt.Errorf("value of %s is too large", key)
+	}
+	req, err := writeRequest(r, g.name, key)
+	if err != nil {
+		return 0, err
+	}
+	if !g.ownsKey(key, req.Compare) {
+		return 0, fmt.Errorf("%s: %w", key, ErrNotOwner)
+	}
+	return g.ownerSet(r.Context(), req, value)
+}
+
+/**
+ * @Description: 本节点是否负责key的写入,PickNode选择了其他节点时不是;
+ * CompareAndSet必须是一致性hash上的owner,见 OwnerPicker
+ * @receiver g
+ * @param key
+ * @param compare 是否是CompareAndSet
+ * @return bool
+ */
+func (g *Group) ownsKey(key string, compare bool) bool {
+	if g.nodePicker == nil {
+		return true
+	}
+	if op, ok := g.nodePicker.(OwnerPicker); ok && compare {
+		_, self, _ := op.PickOwner(key)
+		return self
+	}
+	_, ok := g.nodePicker.PickNode(key)
+	return !ok
+}
+
+/**
+ * @Description: 按条件请求头构造写请求
+ * @param r
+ * @param group
+ * @param key
+ * @return *pb.Request
+ * @return error 条件请求头格式错误
+ */
+func writeRequest(r *http.Request, group, key string) (*pb.Request, error) {
+	req := &pb.Request{Group: group, Key: key}
+	if etag := r.Header.Get("If-Match"); etag != "" {
+		v, ok := parseETag(etag)
+		if !ok {
+			return nil, fmt.Errorf("invalid If-Match %q", etag)
+		}
+		req.Version, req.Compare = v, true
+	} else if r.Header.Get("If-None-Match") == "*" {
+		req.Compare = true
+	}
+	return req, nil
+}
+
+/**
+ * @Description: 写入数据源,开启write-behind时放入队列后立即返回
+ * @receiver g
+ * @param w
+ * @return error
+ */
+func (g *Group) writeSource(w Write) error {
+	if g.writeBehind != nil {
+		g.writeBehind.push(w)
+		return nil
+	}
+	if err := g.applyWrite(w); err != nil {
+		g.stats.SourceWriteErrs.Add(1)
+		return &writeError{key: w.Key, err: err}
+	}
+	g.stats.SourceWrites.Add(1)
+	return nil
+}
+
+/**
+ * @Description: 调用Setter或者Deleter
+ * @receiver g
+ * @param w
+ * @return error
+ */
+func (g *Group) applyWrite(w Write) error {
+	if w.Delete {
+		return g.deleter.Delete(w.Key)
+	}
+	return g.setter.Set(w.Key, w.Value)
+}
+
+/**
+ * @Description: 从数据源读取,write-behind队列中还没有写入的值优先,保证owner读到自己写入的值
+ * @receiver g
+ * @param key
+ * @return []byte
+ * @return error
+ */
+func (g *Group) getSource(key string) ([]byte, error) {
+	if g.writeBehind != nil {
+		if w, ok := g.writeBehind.pending(key); ok {
+			if w.Delete {
+				return nil, fmt.Errorf("%s is deleted: %w", key, ErrNotFound)
+			}
+			return w.Value, nil
+		}
+	}
+	return g.getter.Get(key)
+}
+
+/**
+ * @Description: 等待write-behind队列全部写入数据源,没有开启write-behind时直接返回
+ * @receiver g
+ * @param ctx
+ * @return error ctx结束时还没有写完
+ */
+func (g *Group) Flush(ctx context.Context) error {
+	if g.writeBehind == nil {
+		return nil
+	}
+	return g.writeBehind.flush(ctx)
+}
+
+/**
+ * @Description: write-behind队列的长度
+ * @receiver g
+ * @return int64
+ */
+func (g *Group) writeBehindLen() int64 {
+	if g.writeBehind == nil {
+		return 0
+	}
+	return int64(g.writeBehind.len())
+}
+
+type queuedWrite struct {
+	Write
+	seq uint64
+}
+
+/**
+ * @Description: write-behind队列,按写入的顺序批量写数据源,失败时重试,重试耗尽后丢弃并记录错误
+ * 队列不为空时才有后台协程,每interval或者攒够batchSize写一次
+ */
+type writeQueue struct {
+	g         *Group
+	batchSize int
+	interval  time.Duration
+	retries   int
+	backoff   time.Duration
+
+	mu      sync.Mutex
+	seq     uint64
+	writes  []queuedWrite          //等待写入和正在写入的,按顺序
+	latest  map[string]queuedWrite //每个key最后一次写入,用于读到还没有写入数据源的值
+	running bool                   //后台协程是否在运行
+	kick    chan struct{}
+}
+
+func newWriteQueue(g *Group, batchSize int, interval time.Duration, retries int) *writeQueue {
+	if batchSize <= 0 {
+		batchSize = defaultWriteBatchSize
+	}
+	if interval <= 0 {
+		interval = defaultWriteInterval
+	}
+	if retries < 0 {
+		retries = defaultWriteRetries
+	}
+	return &writeQueue{
+		g:         g,
+		batchSize: batchSize,
+		interval:  interval,
+		retries:   retries,
+		backoff:   defaultWriteBackoff,
+		latest:    make(map[string]queuedWrite),
+		kick:      make(chan struct{}, 1),
+	}
+}
+
+func (q *writeQueue) push(w Write) {
+	q.mu.Lock()
+	q.seq++
+	qw := queuedWrite{Write: w, seq: q.seq}
+	q.writes = append(q.writes, qw)
+	q.latest[w.Key] = qw
+	full := len(q.writes) >= q.batchSize
+	if !q.running {
+		q.running = true
+		go q.run()
+	}
+	q.mu.Unlock()
+	if full {
+		q.wake()
+	}
+}
+
+func (q *writeQueue) wake() {
+	select {
+	case q.kick <- struct{}{}:
+	default:
+	}
+}
+
+/**
+ * @Description: key还没有写入数据源的最后一次写入
+ * @receiver q
+ * @param key
+ * @return Write
+ * @return bool
+ */
+func (q *writeQueue) pending(key string) (Write, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	qw, ok := q.latest[key]
+	return qw.Write, ok
+}
+
+/**
+ * @Description: 队列中的写入数,包括正在写入的
+ * @receiver q
+ * @return int
+ */
+func (q *writeQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.writes)
+}
+
+func (q *writeQueue) run() {
+	for {
+		select {
+		case <-time.After(q.interval):
+		case <-q.kick:
+		}
+		for q.writeBatch() {
+		}
+		q.mu.Lock()
+		if len(q.writes) == 0 {
+			q.running = false
+			q.mu.Unlock()
+			return
+		}
+		q.mu.Unlock()
+	}
+}
+
+/**
+ * @Description: 写入队首的一批,写入完成后才从队列中移除
+ * @receiver q
+ * @return bool 是否还有没写入的
+ */
+func (q *writeQueue) writeBatch() bool {
+	q.mu.Lock()
+	n := len(q.writes)
+	if n > q.batchSize {
+		n = q.batchSize
+	}
+	batch := append([]queuedWrite(nil), q.writes[:n]...)
+	q.mu.Unlock()
+	if n == 0 {
+		return false
+	}
+
+	for len(batch) > 0 {
+		written, dropped := q.writeWithRetry(batch)
+		q.g.stats.SourceWrites.Add(int64(written))
+		q.g.stats.SourceWriteErrs.Add(int64(dropped))
+		q.remove(batch[:written+dropped])
+		batch = batch[written+dropped:]
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.writes) > 0
+}
+
+/**
+ * @Description: 按顺序写入,失败时从失败的位置重试,重试耗尽后丢弃失败的写入(BatchWriter为整批),
+ * 保证后面的写入不被一直阻塞
+ * @receiver q
+ * @param batch
+ * @return written 成功写入的个数
+ * @return dropped 丢弃的个数,紧跟在成功写入的之后
+ */
+func (q *writeQueue) writeWithRetry(batch []queuedWrite) (written, dropped int) {
+	g := q.g
+	bw, _ := g.setter.(BatchWriter)
+	for attempt := 0; ; attempt++ {
+		var err error
+		if bw != nil {
+			writes := make([]Write, len(batch))
+			for i, qw := range batch {
+				writes[i] = qw.Write
+			}
+			if err = bw.WriteBatch(writes); err == nil {
+				return len(batch), 0
+			}
+		} else {
+			for ; written < len(batch); written++ {
+				if err = g.applyWrite(batch[written].Write); err != nil {
+					break
+				}
+			}
+			if err == nil {
+				return written, 0
+			}
+		}
+		if attempt >= q.retries {
+			dropped = 1
+			if bw != nil {
+				dropped = len(batch)
+			}
+			g.logger().Error("write behind failed, drop writes", "group", g.name, "key", batch[written].Key, "dropped", dropped, "err", err)
+			return written, dropped
+		}
+		g.logger().Warn("write behind failed, retry", "group", g.name, "key", batch[written].Key, "attempt", attempt+1, "err", err)
+		time.Sleep(backoff(q.backoff, attempt))
+	}
+}
+
+/**
+ * @Description: 从队首移除已经处理的写入
+ * @receiver q
+ * @param written
+ */
+func (q *writeQueue) remove(written []queuedWrite) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.writes = q.writes[len(written):]
+	for _, qw := range written {
+		if q.latest[qw.Key].seq == qw.seq {
+			delete(q.latest, qw.Key)
+		}
+	}
+}
+
+/**
+ * @Description: 立即写入并等待队列为空
+ * @receiver q
+ * @param ctx
+ * @return error
+ */
+func (q *writeQueue) flush(ctx context.Context) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		if q.len() == 0 {
+			return nil
+		}
+		q.wake()
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("flush %d writes of group %s: %w", q.len(), q.g.name, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	pb "cache/cachepb"
+)
+
+// 内存中的数据源,实现了Getter,Setter和Deleter,记录写入的顺序
+type memStore struct {
+	mu    sync.Mutex
+	data  map[string]string
+	log   []string
+	fails int //接下来失败的写入次数
+}
+
+func newMemStore() *memStore {
+	return &memStore{data: make(map[string]string)}
+}
+
+func (s *memStore) Get(key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.data[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return []byte(v), nil
+}
+
+func (s *memStore) Set(key string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fails > 0 {
+		s.fails--
+		return errors.New("db is down")
+	}
+	s.data[key] = string(value)
+	s.log = append(s.log, "set "+key+"="+string(value))
+	return nil
+}
+
+func (s *memStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fails > 0 {
+		s.fails--
+		return errors.New("db is down")
+	}
+	delete(s.data, key)
+	s.log = append(s.log, "delete "+key)
+	return nil
+}
+
+func (s *memStore) failNext(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fails = n
+}
+
+func (s *memStore) writes() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.log...)
+}
+
+func TestWriteThrough(t *testing.T) {
+	store := newMemStore()
+	g := NewGroup("write-through", 1<<10, store)
+	if err := g.Set("k", []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := g.peek("k"); !ok || v.String() != "v1" {
+		t.Fatalf("expected cached v1, got %q", v)
+	}
+
+	//写入数据源失败时缓存不变
+	store.failNext(1)
+	if err := g.Set("k", []byte("v2")); err == nil {
+		t.Fatal("expected write error")
+	}
+	if v, _ := g.Get("k"); v.String() != "v1" {
+		t.Fatalf("cache should keep v1 after a failed write, got %q", v)
+	}
+
+	if err := g.Delete("k"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.Get("k"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected not found after delete, got %v", err)
+	}
+	if s := g.Stats(); s.SourceWrites != 2 || s.SourceWriteErrs != 1 {
+		t.Fatalf("unexpected write stats %+v", s)
+	}
+
+	//没有Setter时只写本节点
+	local := NewGroup("write-local", 1<<10, GetterFunc(func(key string) ([]byte, error) { return nil, ErrNotFound }))
+	if err := local.Set("k", []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if err := local.Delete("k"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWriteBehind(t *testing.T) {
+	store := newMemStore()
+	g := NewGroup("write-behind", 1<<10, store, WithWriteBehind(3, time.Hour, 2))
+	g.writeBehind.backoff = time.Millisecond
+
+	store.failNext(2) //第一次写入重试后成功
+	g.Set("a", []byte("1"))
+	g.Set("b", []byte("1"))
+	g.Set("a", []byte("2")) //攒够一批,立即写入
+	g.Delete("b")
+	g.Set("c", []byte("1"))
+
+	//还没有写入数据源时,淘汰后读到的也是最后写入的值
+	g.Remove("c")
+	if v, err := g.Get("c"); err != nil || v.String() != "1" {
+		t.Fatalf("expected pending value, got %q err=%v", v, err)
+	}
+	if _, err := g.Get("b"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected pending delete, got %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err := g.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprint([]string{"set a=1", "set b=1", "set a=2", "delete b", "set c=1"})
+	if got := fmt.Sprint(store.writes()); got != want {
+		t.Fatalf("expected writes in order %s, got %s", want, got)
+	}
+	if s := g.Stats(); s.SourceWrites != 5 || s.WriteBehind != 0 {
+		t.Fatalf("unexpected write stats %+v", s)
+	}
+
+	//重试耗尽后丢弃,后面的写入不受影响
+	store.failNext(3)
+	g.Set("d", []byte("1"))
+	g.Set("e", []byte("1"))
+	if err := g.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get("d"); err == nil {
+		t.Fatal("d should be dropped")
+	}
+	if v, err := store.Get("e"); err != nil || string(v) != "1" {
+		t.Fatalf("e should be written, got %q err=%v", v, err)
+	}
+	if n := g.Stats().SourceWriteErrs; n != 1 {
+		t.Fatalf("expected 1 dropped write, got %d", n)
+	}
+}
+
+// 改写group名并支持写入的节点客户端
+type renameWriter struct {
+	group string
+	node  *httpClient
+}
+
+func (n renameWriter) Get(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	return n.node.Get(ctx, &pb.Request{Group: n.group, Key: in.GetKey(), Version: in.GetVersion()}, out)
+}
+
+func (n renameWriter) Set(ctx context.Context, in *pb.Request, value []byte, out *pb.Response) error {
+	return n.node.Set(ctx, &pb.Request{Group: n.group, Key: in.GetKey(), Version: in.GetVersion(), Compare: in.GetCompare()}, value, out)
+}
+
+func (n renameWriter) Delete(ctx context.Context, in *pb.Request) error {
+	return n.node.Delete(ctx, &pb.Request{Group: n.group, Key: in.GetKey()})
+}
+
+func TestWriteForwardedToOwner(t *testing.T) {
+	store := newMemStore()
+	owner := NewGroup("write-owner", 1<<10, store)
+	server := httptest.NewServer(NewGroupHTTP("http://owner", WithUnauthenticatedWrites()))
+	defer server.Close()
+	self := NewGroupHTTP("http://self")
+	self.Set(server.URL)
+
+	//非owner节点不直接写数据源
+	g := NewGroup("write-peer", 1<<10, GetterFunc(func(key string) ([]byte, error) { return nil, ErrNotFound }),
+		WithSetter(SetterFunc(func(key string, value []byte) error {
+			t.Errorf("peer should forward the write of %s", key)
+			return nil
+		})),
+		WithDeleter(DeleterFunc(func(key string) error {
+			t.Errorf("peer should forward the delete of %s", key)
+			return nil
+		})))
+	g.Register(fixedPicker{node: renameWriter{group: "write-owner", node: self.NodeClientMap[server.URL]}})
+
+	if err := g.Set("k", []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := store.Get("k"); err != nil || string(v) != "v" {
+		t.Fatalf("expected owner to write the source, got %q err=%v", v, err)
+	}
+	if v, ok := owner.peek("k"); !ok || v.String() != "v" {
+		t.Fatalf("expected owner to cache the written value, got %q", v)
+	}
+
+	store.failNext(1)
+	err := g.Set("k", []byte("v2"))
+	if err == nil || statusOf(err) != http.StatusBadGateway {
+		t.Fatalf("expected peer error, got %v", err)
+	}
+	if v, _ := owner.peek("k"); v.String() != "v" {
+		t.Fatalf("owner should keep v after a failed write, got %q", v)
+	}
+
+	if err := g.Delete("k"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := owner.peek("k"); ok {
+		t.Fatal("expected owner to drop the deleted key")
+	}
+	if _, err := store.Get("k"); err == nil {
+		t.Fatal("expected owner to delete from the source")
+	}
+}
+
+func TestServeWriteGuards(t *testing.T) {
+	store := newMemStore()
+	g := NewGroup("write-guard", 1<<10, store)
+	put := func(h http.Handler) int {
+		r := httptest.NewRequest("PUT", defaultPrefix+"write-guard/k", strings.NewReader("v"))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	//没有认证时默认拒绝写入
+	if code := put(NewGroupHTTP("http://owner")); code != http.StatusForbidden {
+		t.Fatalf("expected 403 without auth, got %d", code)
+	}
+	open := NewGroupHTTP("http://owner", WithUnauthenticatedWrites())
+	if code := put(open); code != http.StatusNoContent {
+		t.Fatalf("expected 204 with unauthenticated writes, got %d", code)
+	}
+
+	//本节点不是owner
+	g.Register(fixedPicker{node: failingNode{}})
+	if code := put(open); code != http.StatusConflict {
+		t.Fatalf("expected 409 for a key owned by another node, got %d", code)
+	}
+	if v, _ := store.Get("k"); string(v) != "v" {
+		t.Fatalf("expected the rejected write to leave the source alone, got %q", v)
+	}
+}
@@ -5,6 +5,7 @@ go 1.13
 require (
 	cache v0.0.0
 	github.com/go-delve/delve v1.6.0
+	google.golang.org/protobuf v1.23.0
 )
 
 replace cache => ./cache
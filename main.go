@@ -2,11 +2,16 @@ package main
 
 import (
 	"cache"
+	"context"
 	"flag"
 	"fmt"
+	"io"
 	"log"
-	"net/http"
+	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
+	"time"
 )
 
 var db = map[string]int{
@@ -16,58 +21,132 @@ var db = map[string]int{
 }
 
 /**
- * @Description: 创建一个group
- * @return *cache.Group
+ * @Description: 没有指定配置文件时使用的默认配置,本机上的三个节点和一个test group
+ * @param port
+ * @param scheme
+ * @param api 是否开启API服务
+ * @return *cache.Config
  */
-func createGroup() *cache.Group {
-	return cache.NewGroup("test",2<<10,cache.GetterFunc(
-		func(key string) ([]byte, error) {
-			log.Println("db search key: " + key)
-			if v,ok := db[key];ok {
-				return []byte(strconv.Itoa(v)),nil
-			}
-			return nil,fmt.Errorf("%s not exist",key)
-		},
-	))
+func defaultConfig(port int, scheme string, api bool) *cache.Config {
+	cfg := &cache.Config{
+		Addr:   fmt.Sprintf("%s://localhost:%d", scheme, port),
+		Groups: []cache.GroupSpec{{Name: "test", MaxBytes: 2 << 10}},
+	}
+	for _, p := range []int{8001, 8002, 8003} {
+		cfg.Peers = append(cfg.Peers, fmt.Sprintf("%s://localhost:%d", scheme, p))
+	}
+	if api {
+		cfg.API = "localhost:9999"
+	}
+	return cfg
+}
+
+/**
+ * @Description: 按配置创建group
+ * @param cfg
+ * @param opts 每个group额外的选项
+ * @return []*cache.Group
+ */
+func createGroups(cfg *cache.Config, opts ...cache.GroupOption) []*cache.Group {
+	var groups []*cache.Group
+	for _, spec := range cfg.Groups {
+		name := spec.Name
+		groups = append(groups, spec.NewGroup(cache.GetterFunc(
+			func(key string) ([]byte, error) {
+				log.Println("db search key: " + name + "/" + key)
+				if v,ok := db[key];ok {
+					return []byte(strconv.Itoa(v)),nil
+				}
+				return nil,fmt.Errorf("%s not exist: %w",key,cache.ErrNotFound)
+			},
+		), opts...))
+	}
+	return groups
 }
 
 /**
- * @Description: 开始一个节点服务
- * @param addr
- * @param addrs
- * @param group
+ * @Description: 运行一个节点服务,收到SIGINT或者SIGTERM后优雅地关闭
+ * @param cfg
+ * @param configPath 配置文件路径,不为空并且没有使用gossip和节点发现时,收到SIGHUP后重新加载节点列表
+ * @param groups
+ * @param serverOpts
+ * @param closers 关闭节点服务之前先关闭的其他协议的服务
+ * @param drainTimeout 关闭时最多等待的时间
+ * @param opts
  */
-func startCacheServer(addr string,addrs []string,group *cache.Group){
+func runCacheServer(cfg *cache.Config,configPath string,groups []*cache.Group,serverOpts []cache.ServerOption,closers []io.Closer,drainTimeout time.Duration,opts ...cache.HTTPOption){
 
 	//创建一个节点服务
-	nodeServer:=cache.NewGroupHTTP(addr)
+	nodeServer:=cache.NewGroupHTTP(cfg.Addr,append(cfg.HTTPOptions(),opts...)...)
 
 	//为该服务添加其他节点的信息到一致性hash上
-	nodeServer.Set(addrs...)
+	nodeServer.Set(cfg.Nodes()...)
 
-	//为一个group注册一个节点服务,该节点服务能够支持分布式节点寻找的能力
-	group.Register(nodeServer)
+	//为group注册一个节点服务,该节点服务能够支持分布式节点寻找的能力
+	for _, group := range groups {
+		group.Register(nodeServer)
+	}
+	if cfg.Gossip != nil {
+		m, err := cache.JoinGossip(nodeServer, cfg.Gossip.Config(), cfg.Gossip.Seeds)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Println("gossip for cache is running at ", m.Addr())
+		serverOpts = append(serverOpts, cache.WithGossip(m))
+	} else if cfg.Discovery != nil {
+		nodeServer.Subscribe(cfg.Discovery.Discovery(cfg.Addr), cfg.Discovery.Debounce)
+	} else if configPath != "" {
+		go reloadPeersOnSignal(configPath, cfg, nodeServer)
+	}
 
-	log.Println("nodeServer for cache is running at ",addr)
-	log.Fatal(http.ListenAndServe(addr[7:],nodeServer))
-}
+	server := cache.NewServer(cfg.ListenAddr(), nodeServer, serverOpts...)
+	if err := server.Start(); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("nodeServer for cache is running at ",cfg.Addr)
 
-func  startAPIServer(apiAddr string,group *cache.Group)  {
-	http.Handle("/api",http.HandlerFunc(
-		func(writer http.ResponseWriter, request *http.Request) {
-			key:=request.URL.Query().Get("key")
-			view,err :=group.Get(key)
-			if err != nil {
-				http.Error(writer,err.Error(),http.StatusInternalServerError)
-				return
-			}
-			writer.Header().Set("Content-Type","application/octet-stream")
-			writer.Write(view.Copy())
-		}))
-	log.Println("apiServer for cache is running at ",apiAddr)
-	log.Fatal(http.ListenAndServe(apiAddr[7:],nil))
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case err := <-server.Err():
+		log.Fatal(err)
+	case s := <-sig:
+		log.Printf("received %v, shutting down", s)
+	}
+	for _, c := range closers {
+		c.Close()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Fatalf("shutdown: %v", err)
+	}
+	log.Println("nodeServer for cache stopped")
 }
 
+/**
+ * @Description: 收到SIGHUP时重新读取配置文件并更新节点列表,其他配置的修改需要重启才能生效
+ * @param path
+ * @param cfg 启动时的配置
+ * @param nodeServer
+ */
+func reloadPeersOnSignal(path string, cfg *cache.Config, nodeServer *cache.GroupHTTP) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	for range sig {
+		next, err := cache.LoadConfig(path)
+		if err != nil {
+			log.Printf("reload config failed, keep the old peers:\n%v", err)
+			continue
+		}
+		if next.Addr != cfg.Addr {
+			log.Printf("reload config: addr changed from %s to %s, restart to apply", cfg.Addr, next.Addr)
+		}
+		nodes := next.Nodes()
+		nodeServer.Set(nodes...)
+		log.Println("reload config: peers are now", nodes)
+	}
+}
 
 /**
  * @Description: Test
@@ -76,7 +155,7 @@ func  startAPIServer(apiAddr string,group *cache.Group)  {
 2. ./server -port=8001
 3. ./server -port=8002
 4. ./server -port=8003 -api=1
-5. curl "http://localhost:9999/api?key=1"
+5. curl "http://localhost:9999/api/test/1"
 5. curl "http://localhost:8002/cache/test/1"
 ```
  */
@@ -85,25 +164,105 @@ func main()  {
 	var (
 		port int
 		api bool
+		verbose bool
+		tlsCert, tlsKey, tlsCA string
+		authSecret string
+		respAddr string
+		memcacheAddr string
+		frontendPassword string
+		adminToken string
+		apiToken string
+		configPath string
+		snapshotDir string
+		drainTimeout time.Duration
 	)
 	flag.IntVar(&port,"port",8001,"Node Server for Cache with port")
 	flag.BoolVar(&api, "api", false, "Start API Server?")
+	flag.BoolVar(&verbose, "v", false, "Print debug logs?")
+	flag.StringVar(&tlsCert, "tls-cert", "", "TLS certificate for node traffic")
+	flag.StringVar(&tlsKey, "tls-key", "", "TLS private key for node traffic")
+	flag.StringVar(&tlsCA, "tls-ca", "", "CA to verify other nodes, enables mutual TLS")
+	flag.StringVar(&authSecret, "auth-secret", "", "Shared secret to sign and verify node requests")
+	flag.StringVar(&respAddr, "resp", "", "Address for the Redis protocol server, e.g. :6379")
+	flag.StringVar(&memcacheAddr, "memcache", "", "Address for the memcached protocol server, e.g. :11211")
+	flag.StringVar(&frontendPassword, "frontend-password", "", "Password for the Redis and memcached protocol servers, disabled when empty")
+	flag.StringVar(&adminToken, "admin-token", "", "Token for the /_admin/ endpoints, disabled when empty")
+	flag.StringVar(&apiToken, "api-token", "", "Bearer token for the REST API, PUT and DELETE are rejected when empty")
+	flag.StringVar(&configPath, "config", "", "YAML or JSON config file for the node, peers and groups, overrides -port and -api")
+	flag.StringVar(&snapshotDir, "snapshot-dir", "", "Directory to save caches on shutdown and restore them on start")
+	flag.DurationVar(&drainTimeout, "drain-timeout", 30*time.Second, "How long to wait for in-flight requests and loads on shutdown")
 	flag.Parse()
 
-	apiAddr:="http://localhost:9999"
-	addrMap := map[int]string{
-		8001: "http://localhost:8001",
-		8002: "http://localhost:8002",
-		8003: "http://localhost:8003",
+	level := cache.LevelInfo
+	if verbose {
+		level = cache.LevelDebug
+	}
+	cache.SetLogger(cache.NewStdLogger(nil, level))
+
+	scheme := "http"
+	var opts []cache.HTTPOption
+	if tlsCert != "" {
+		certs, err := cache.NewCertReloader(tlsCert, tlsKey, tlsCA, time.Minute)
+		if err != nil {
+			log.Fatal(err)
+		}
+		scheme = "https"
+		opts = append(opts, cache.WithTLS(certs, tlsCA != ""))
+	}
+	cfg := defaultConfig(port, scheme, api)
+	if configPath != "" {
+		var err error
+		if cfg, err = cache.LoadConfig(configPath); err != nil {
+			log.Fatalf("invalid config:\n%v", err)
+		}
 	}
-	var addrs []string
-	for _, v := range addrMap {
-		addrs = append(addrs,v)
+	var groupOpts []cache.GroupOption
+	if adminToken != "" {
+		opts = append(opts, cache.WithAdmin(adminToken))
+		//监控页面显示热点key
+		groupOpts = append(groupOpts, cache.WithHotKeys(0))
+		log.Printf("dashboard is running at %s/_admin/dashboard", cfg.Addr)
+	}
+	if authSecret != "" {
+		keys := map[string][]byte{"*": []byte(authSecret)}
+		opts = append(opts, cache.WithAuth(cache.NewHMACAuth(cfg.Addr, keys, 0), nil))
 	}
 
-	group :=createGroup()
-	if api{
-		go startAPIServer(apiAddr,group)
+	groups := createGroups(cfg, groupOpts...)
+	var serverOpts []cache.ServerOption
+	if cfg.API != "" {
+		log.Println("apiServer for cache is running at ", cfg.API)
+		var apiOpts []cache.APIOption
+		if apiToken != "" {
+			apiOpts = append(apiOpts, cache.WithAPIAuth(cache.NewBearerAuth("", map[string]string{apiToken: "api"}), nil))
+		}
+		serverOpts = append(serverOpts, cache.WithAPIServer(cfg.API, cache.NewAPIHandler("/api/", apiOpts...)))
+	}
+	if snapshotDir != "" {
+		serverOpts = append(serverOpts, cache.WithSnapshotDir(snapshotDir))
+	}
+	var closers []io.Closer
+	var frontendOpts []cache.FrontendOption
+	if frontendPassword != "" {
+		frontendOpts = append(frontendOpts, cache.WithFrontendAuth(map[string]string{frontendPassword: "default"}, nil))
+	}
+	if respAddr != "" {
+		resp := cache.NewRESPServer(frontendOpts...)
+		closers = append(closers, resp)
+		go func() {
+			if err := resp.ListenAndServe(respAddr); err != cache.ErrServerClosed {
+				log.Fatal(err)
+			}
+		}()
+	}
+	if memcacheAddr != "" {
+		mc := cache.NewMemcacheServer(frontendOpts...)
+		closers = append(closers, mc)
+		go func() {
+			if err := mc.ListenAndServe(memcacheAddr); err != cache.ErrServerClosed {
+				log.Fatal(err)
+			}
+		}()
 	}
-	startCacheServer(addrMap[port],addrs,group)
+	runCacheServer(cfg,configPath,groups,serverOpts,closers,drainTimeout,opts...)
 }
\ No newline at end of file